@@ -2,28 +2,37 @@ package main
 
 import (
 	"encoding/gob"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/joho/godotenv"
+	"github.com/tsawler/bookings-app/internal/booking"
 	"github.com/tsawler/bookings-app/internal/config"
 	"github.com/tsawler/bookings-app/internal/driver"
+	"github.com/tsawler/bookings-app/internal/forms"
 	"github.com/tsawler/bookings-app/internal/handlers"
 	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/ical"
+	"github.com/tsawler/bookings-app/internal/mailer"
 	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
 	"github.com/tsawler/bookings-app/internal/render"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
 )
 
 const portNumber = ":8080"
 
 var app config.AppConfig
 var session *scs.SessionManager
-
-// var infoLog *log.Logger
-// var errorLog *log.Logger
+var demo = flag.Bool("demo", false, "run against an in-memory database instead of postgres")
 
 // main is the main function
 func main() {
@@ -31,11 +40,16 @@ func main() {
 	if err != nil {
 		log.Println("Not loading env from dot file:", err)
 	}
-	db, err := run()
+	flag.Parse()
+
+	db, stopWorkers, err := run()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
+	if db != nil {
+		defer db.SQL.Close()
+	}
+	defer stopWorkers()
 
 	fmt.Println(fmt.Sprintf("Staring application on port %s", portNumber))
 
@@ -50,7 +64,9 @@ func main() {
 	}
 }
 
-func run() (*driver.DB, error) {
+// run sets the application up and starts the outbox worker and the calendar importer. It returns the
+// connection pool, if there is one, and a function that stops the workers.
+func run() (*driver.DB, func(), error) {
 	// what am I going to put in the session
 	gob.Register(models.Reservation{})
 	gob.Register(models.User{})
@@ -69,28 +85,251 @@ func run() (*driver.DB, error) {
 
 	app.Session = session
 
-	// connect to database
-	log.Println("Connecting to database...")
-	db, err := driver.ConnectSQL(driver.BuildDSN())
+	tc, err := render.CreateTemplateCache()
 	if err != nil {
-		log.Fatal("Cannot connect to database! Dying...")
+		log.Fatal("cannot create template cache")
+		return nil, nil, err
 	}
 
-	log.Println("Connected to database!")
+	app.TemplateCache = tc
+	app.UseCache = false
 
-        tc, err := render.CreateTemplateCache()
+	app.Pricing, err = pricingPolicy()
 	if err != nil {
-		log.Fatal("cannot create template cache")
-		return nil, err
+		return nil, nil, err
 	}
 
-	app.TemplateCache = tc
-	app.UseCache = false
+	app.PhoneRegion, err = phoneRegion()
+	if err != nil {
+		return nil, nil, err
+	}
 
-	repo := handlers.NewRepo(&app, db)
+	backend := os.Getenv("DB_BACKEND")
+	if *demo {
+		backend = "memory"
+	}
+
+	db, dbRepo, err := openDatabaseRepo(backend)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo := handlers.NewRepo(&app, dbRepo)
 	handlers.NewHandlers(repo)
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app, nil, nil)
 
-	return db, nil
+	m, err := mailSettings()
+	if err != nil {
+		return nil, nil, err
+	}
+	app.OwnerEmail = envOr("OWNER_EMAIL", "owner@here.com")
+
+	app.APIKeys, err = apiKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	app.Cancellation, err = cancellationPolicy()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	outbox, err := outboxSettings(dbRepo, m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	importer, err := icalImporter(dbRepo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stop := make(chan struct{})
+
+	log.Println("Starting mail outbox...")
+	go outbox.Run(stop)
+
+	log.Println("Starting calendar importer...")
+	go importer.Run(stop)
+
+	return db, func() { close(stop) }, nil
+}
+
+// openDatabaseRepo sets up the store named by backend. The connection pool is returned as well
+// when there is one, so the caller can close it on the way out.
+func openDatabaseRepo(backend string) (*driver.DB, repository.DatabaseRepo, error) {
+	switch backend {
+	case "", "postgres":
+		log.Println("Connecting to database...")
+		db, err := driver.ConnectSQL(driver.BuildDSN())
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot connect to database: %w", err)
+		}
+
+		log.Println("Connected to database!")
+		return db, dbrepo.NewPostgresRepo(db.SQL, &app), nil
+	case "memory":
+		log.Println("Using an in-memory database; nothing will be saved")
+		return nil, dbrepo.NewMemoryRepo(&app), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown database backend %q", backend)
+	}
+}
+
+// pricingPolicy reads the taxes and fees added to every stay from TAX_RATE, a percentage such as 12.5,
+// and STAY_FEE, in dollars. They default to 10% and $25.
+func pricingPolicy() (pricing.Policy, error) {
+	p := pricing.Policy{TaxRate: 1000, StayFee: 2500}
+
+	if v := os.Getenv("TAX_RATE"); v != "" {
+		// two decimal places of a percentage are basis points, just as they are cents of a dollar
+		rate, err := pricing.ParseAmount(v)
+		if err != nil {
+			return p, fmt.Errorf("TAX_RATE: %w", err)
+		}
+		p.TaxRate = rate
+	}
+
+	if v := os.Getenv("STAY_FEE"); v != "" {
+		fee, err := pricing.ParseAmount(v)
+		if err != nil {
+			return p, fmt.Errorf("STAY_FEE: %w", err)
+		}
+		p.StayFee = fee
+	}
+
+	return p, nil
+}
+
+// phoneRegion reads PHONE_REGION, the two letter region that guests' phone numbers are in when they
+// leave out the country code. It defaults to US.
+func phoneRegion() (string, error) {
+	region := strings.ToUpper(strings.TrimSpace(os.Getenv("PHONE_REGION")))
+	if region == "" {
+		return "US", nil
+	}
+	if !forms.IsPhoneRegion(region) {
+		return "", fmt.Errorf("PHONE_REGION: unknown region %q", region)
+	}
+	return region, nil
+}
+
+// mailSettings reads how to reach the SMTP server from SMTP_HOST, SMTP_PORT, SMTP_USERNAME and
+// SMTP_PASSWORD, and the sender of the site's email from MAIL_FROM. The defaults suit MailHog
+// running locally.
+func mailSettings() (*mailer.Mailer, error) {
+	port, err := strconv.Atoi(envOr("SMTP_PORT", "1025"))
+	if err != nil {
+		return nil, fmt.Errorf("SMTP_PORT: %w", err)
+	}
+
+	return &mailer.Mailer{
+		Host:     envOr("SMTP_HOST", "localhost"),
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     envOr("MAIL_FROM", "bookings@here.com"),
+	}, nil
+}
+
+// outboxSettings reads how hard to try to deliver each email from MAIL_MAX_ATTEMPTS, which defaults
+// to 8. The first retry is a minute after a failure, and the wait doubles from there.
+func outboxSettings(db repository.DatabaseRepo, m *mailer.Mailer) (*mailer.Outbox, error) {
+	attempts, err := strconv.Atoi(envOr("MAIL_MAX_ATTEMPTS", "8"))
+	if err != nil {
+		return nil, fmt.Errorf("MAIL_MAX_ATTEMPTS: %w", err)
+	}
+	if attempts < 1 {
+		return nil, fmt.Errorf("MAIL_MAX_ATTEMPTS: must be at least 1, got %d", attempts)
+	}
+
+	return &mailer.Outbox{
+		DB:          db,
+		Mailer:      m,
+		ErrorLog:    app.ErrorLog,
+		Interval:    10 * time.Second,
+		BatchSize:   20,
+		MaxAttempts: attempts,
+		BaseDelay:   time.Minute,
+	}, nil
+}
+
+// icalImporter reads how often to import the calendars of other booking sites from ICAL_SYNC_INTERVAL,
+// such as 30m. It defaults to every 15 minutes.
+func icalImporter(db repository.DatabaseRepo) (*ical.Importer, error) {
+	interval, err := time.ParseDuration(envOr("ICAL_SYNC_INTERVAL", "15m"))
+	if err != nil {
+		return nil, fmt.Errorf("ICAL_SYNC_INTERVAL: %w", err)
+	}
+	if interval < time.Minute {
+		return nil, fmt.Errorf("ICAL_SYNC_INTERVAL: must be at least a minute, got %s", interval)
+	}
+
+	return &ical.Importer{
+		DB:       db,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		InfoLog:  app.InfoLog,
+		ErrorLog: app.ErrorLog,
+		Interval: interval,
+	}, nil
+}
+
+// minAPIKeyLength keeps guessable keys out of API_KEYS
+const minAPIKeyLength = 20
+
+// apiKeys reads the keys partner sites use the API with from API_KEYS, a comma separated list. Without
+// any, the API refuses every request.
+func apiKeys() ([]string, error) {
+	var keys []string
+	for _, key := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if len(key) < minAPIKeyLength {
+			return nil, fmt.Errorf("API_KEYS: keys must be at least %d characters long", minAPIKeyLength)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// cancellationPolicy reads from CANCELLATION_DAYS how many days before arrival guests can still change
+// their booking online or cancel it for a full refund, and from CANCELLATION_LATE_REFUND the percentage
+// refunded for later cancellations, up to the day before arrival. They default to 2 days and nothing;
+// 0 days lets guests change and cancel for free up to the day before they arrive.
+func cancellationPolicy() (booking.Policy, error) {
+	p := booking.Policy{}
+
+	days, err := strconv.Atoi(envOr("CANCELLATION_DAYS", "2"))
+	if err != nil {
+		return p, fmt.Errorf("CANCELLATION_DAYS: %w", err)
+	}
+	if days < 0 {
+		return p, fmt.Errorf("CANCELLATION_DAYS: can't be negative, got %d", days)
+	}
+	p.FreeDays = days
+
+	if v := os.Getenv("CANCELLATION_LATE_REFUND"); v != "" {
+		// in basis points, as TAX_RATE is
+		rate, err := pricing.ParseAmount(v)
+		if err != nil {
+			return p, fmt.Errorf("CANCELLATION_LATE_REFUND: %w", err)
+		}
+		if rate > 10000 {
+			return p, fmt.Errorf("CANCELLATION_LATE_REFUND: can't be more than 100%%, got %s", v)
+		}
+		p.LateRefund = rate
+	}
+
+	return p, nil
+}
+
+// envOr returns the environment variable key, or def if it isn't set
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
 }
@@ -1,10 +1,174 @@
 package main
 
-import "testing"
+import (
+	"os"
+	"testing"
+	"time"
+)
 
 func TestRun(t *testing.T) {
-	_, err := run()
+	os.Setenv("DB_BACKEND", "memory")
+	defer os.Unsetenv("DB_BACKEND")
+
+	_, stopWorkers, err := run()
+	if err != nil {
+		t.Fatal("failed run")
+	}
+	stopWorkers()
+}
+
+func TestRunUnknownBackend(t *testing.T) {
+	os.Setenv("DB_BACKEND", "nosuchdb")
+	defer os.Unsetenv("DB_BACKEND")
+
+	_, _, err := run()
+	if err == nil {
+		t.Error("expected run to fail for an unknown database backend")
+	}
+}
+
+func TestPricingPolicy(t *testing.T) {
+	os.Setenv("TAX_RATE", "12.5")
+	os.Setenv("STAY_FEE", "40")
+	defer os.Unsetenv("TAX_RATE")
+	defer os.Unsetenv("STAY_FEE")
+
+	p, err := pricingPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TaxRate != 1250 || p.StayFee != 4000 {
+		t.Errorf("expected 1250 basis points and 4000 cents, got %+v", p)
+	}
+
+	os.Setenv("TAX_RATE", "lots")
+	if _, err := pricingPolicy(); err == nil {
+		t.Error("expected an invalid TAX_RATE to be refused")
+	}
+}
+
+func TestPhoneRegion(t *testing.T) {
+	defer os.Unsetenv("PHONE_REGION")
+
+	if region, err := phoneRegion(); err != nil || region != "US" {
+		t.Errorf("expected the region to default to US, got %q (%v)", region, err)
+	}
+
+	os.Setenv("PHONE_REGION", "gb")
+	if region, err := phoneRegion(); err != nil || region != "GB" {
+		t.Errorf("expected GB, got %q (%v)", region, err)
+	}
+
+	os.Setenv("PHONE_REGION", "Narnia")
+	if _, err := phoneRegion(); err == nil {
+		t.Error("expected an unknown PHONE_REGION to be refused")
+	}
+}
+
+func TestMailSettings(t *testing.T) {
+	os.Setenv("SMTP_HOST", "mail.here.com")
+	os.Setenv("SMTP_PORT", "587")
+	defer os.Unsetenv("SMTP_HOST")
+	defer os.Unsetenv("SMTP_PORT")
+
+	m, err := mailSettings()
 	if err != nil {
-		t.Error("failed run")
+		t.Fatal(err)
+	}
+	if m.Host != "mail.here.com" || m.Port != 587 || m.From != "bookings@here.com" {
+		t.Errorf("unexpected settings %+v", m)
+	}
+
+	os.Setenv("SMTP_PORT", "smtp")
+	if _, err := mailSettings(); err == nil {
+		t.Error("expected an invalid SMTP_PORT to be refused")
+	}
+}
+
+func TestOutboxSettings(t *testing.T) {
+	os.Setenv("MAIL_MAX_ATTEMPTS", "3")
+	defer os.Unsetenv("MAIL_MAX_ATTEMPTS")
+
+	o, err := outboxSettings(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.MaxAttempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", o.MaxAttempts)
+	}
+
+	for _, v := range []string{"lots", "0"} {
+		os.Setenv("MAIL_MAX_ATTEMPTS", v)
+		if _, err := outboxSettings(nil, nil); err == nil {
+			t.Errorf("expected MAIL_MAX_ATTEMPTS=%s to be refused", v)
+		}
+	}
+}
+
+func TestICalImporter(t *testing.T) {
+	os.Setenv("ICAL_SYNC_INTERVAL", "30m")
+	defer os.Unsetenv("ICAL_SYNC_INTERVAL")
+
+	im, err := icalImporter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if im.Interval != 30*time.Minute {
+		t.Errorf("expected 30m, got %s", im.Interval)
+	}
+
+	for _, v := range []string{"often", "10s"} {
+		os.Setenv("ICAL_SYNC_INTERVAL", v)
+		if _, err := icalImporter(nil); err == nil {
+			t.Errorf("expected ICAL_SYNC_INTERVAL=%s to be refused", v)
+		}
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	os.Setenv("API_KEYS", " 0123456789abcdef0123, ,fedcba9876543210fedc ")
+	defer os.Unsetenv("API_KEYS")
+
+	keys, err := apiKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "0123456789abcdef0123" || keys[1] != "fedcba9876543210fedc" {
+		t.Errorf("expected the two keys, got %q", keys)
+	}
+
+	os.Setenv("API_KEYS", "0123456789abcdef0123,secret")
+	if _, err := apiKeys(); err == nil {
+		t.Error("expected a short key to be refused")
+	}
+}
+
+func TestCancellationPolicy(t *testing.T) {
+	defer os.Unsetenv("CANCELLATION_DAYS")
+	defer os.Unsetenv("CANCELLATION_LATE_REFUND")
+
+	if p, err := cancellationPolicy(); err != nil || p.FreeDays != 2 || p.LateRefund != 0 {
+		t.Errorf("expected 2 free days and no late refund by default, got %+v (%v)", p, err)
+	}
+
+	os.Setenv("CANCELLATION_DAYS", "0")
+	os.Setenv("CANCELLATION_LATE_REFUND", "50")
+	if p, err := cancellationPolicy(); err != nil || p.FreeDays != 0 || p.LateRefund != 5000 {
+		t.Errorf("expected 0 days and 5000 basis points, got %+v (%v)", p, err)
+	}
+
+	for _, v := range []string{"-1", "a week"} {
+		os.Setenv("CANCELLATION_DAYS", v)
+		if _, err := cancellationPolicy(); err == nil {
+			t.Errorf("expected %q days to be refused", v)
+		}
+	}
+
+	os.Setenv("CANCELLATION_DAYS", "2")
+	for _, v := range []string{"120", "half"} {
+		os.Setenv("CANCELLATION_LATE_REFUND", v)
+		if _, err := cancellationPolicy(); err == nil {
+			t.Errorf("expected a late refund of %q to be refused", v)
+		}
 	}
 }
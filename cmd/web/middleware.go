@@ -1,13 +1,21 @@
 package main
 
 import (
-	"github.com/justinas/nosurf"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"net/http"
+	"strings"
+
+	"github.com/justinas/nosurf"
+	"github.com/tsawler/bookings-app/internal/helpers"
 )
 
 // NoSurf is the csrf protection middleware
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
+	// the API is used with API keys rather than cookies, so there is no request to forge
+	csrfHandler.ExemptRegexp("^/api/")
 
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
@@ -22,3 +30,68 @@ func NoSurf(next http.Handler) http.Handler {
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
+
+// Auth sends anyone who is not logged in to the login page
+func Auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !helpers.IsAuthenticated(r) {
+			session.Put(r.Context(), "error", "Log in first!")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAccess refuses logged in users whose access level is below level. It belongs after Auth,
+// which deals with anonymous users.
+func RequireAccess(level int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if session.GetInt(r.Context(), "access_level") < level {
+				helpers.Forbidden(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIAuth refuses API requests that don't carry one of app.APIKeys as a bearer token, and tells the
+// handlers which partner made the others
+func APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := apiKey(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			helpers.APIError(w, http.StatusUnauthorized, "unauthorized", "Send your API key in the Authorization header, as Bearer <key>", nil)
+			return
+		}
+		next.ServeHTTP(w, helpers.WithAPIPartner(r, apiPartner(key)))
+	})
+}
+
+// apiKey returns the one of app.APIKeys the request's Authorization header holds, if any
+func apiKey(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	token := []byte(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+
+	found := ""
+	for _, key := range app.APIKeys {
+		if subtle.ConstantTimeCompare(token, []byte(key)) == 1 {
+			found = key
+		}
+	}
+	return found, found != ""
+}
+
+// apiPartner identifies the partner using key in the reservations it books, without storing the key
+// itself. It is the start of the key's SHA-256 hash, so reservations booked with a key that is replaced
+// can only be changed by staff.
+func apiPartner(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
@@ -3,7 +3,11 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -18,6 +22,24 @@ func TestNoSurf(t *testing.T) {
 	}
 }
 
+func TestNoSurfAPI(t *testing.T) {
+	var myH myHandler
+	h := NoSurf(&myH)
+
+	for path, expectedCode := range map[string]int{
+		"/make-reservation":    http.StatusBadRequest,
+		"/api/v1/reservations": http.StatusOK,
+	} {
+		req := httptest.NewRequest("POST", path, nil)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+		if rr.Code != expectedCode {
+			t.Errorf("POST %s without a CSRF token: expected %d but got %d", path, expectedCode, rr.Code)
+		}
+	}
+}
+
 func TestSessionLoad(t *testing.T) {
 	var myH myHandler
 	h := SessionLoad(&myH)
@@ -29,3 +51,112 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler but is %T", v))
 	}
 }
+
+func TestAuth(t *testing.T) {
+	var myH myHandler
+	h := Auth(&myH)
+
+	req := httptest.NewRequest("GET", "/admin/dashboard", nil)
+	ctx, _ := session.Load(req.Context(), "")
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/login" {
+		t.Errorf("anonymous user should be sent to /user/login, got %d to %s", rr.Code, rr.Header().Get("Location"))
+	}
+
+	session.Put(ctx, "user_id", 1)
+	rr = httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("logged in user should be let through, got %d", rr.Code)
+	}
+}
+
+func TestRequireAccess(t *testing.T) {
+	var myH myHandler
+	h := RequireAccess(models.AccessManager)(&myH)
+
+	var tests = []struct {
+		name         string
+		accessLevel  int
+		expectedCode int
+	}{
+		{"guest", models.AccessGuest, http.StatusForbidden},
+		{"front desk", models.AccessFrontDesk, http.StatusForbidden},
+		{"manager", models.AccessManager, http.StatusOK},
+		{"admin", models.AccessAdmin, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/admin/dashboard", nil)
+		ctx, _ := session.Load(req.Context(), "")
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+		session.Put(ctx, "access_level", e.accessLevel)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestAPIAuth(t *testing.T) {
+	var myH myHandler
+	h := APIAuth(&myH)
+
+	app.APIKeys = []string{"0123456789abcdef0123", "fedcba9876543210fedc"}
+	defer func() { app.APIKeys = nil }()
+
+	var tests = []struct {
+		name         string
+		header       string
+		expectedCode int
+	}{
+		{"no key", "", http.StatusUnauthorized},
+		{"wrong key", "Bearer 0123456789abcdef0124", http.StatusUnauthorized},
+		{"not a bearer token", "Basic 0123456789abcdef0123", http.StatusUnauthorized},
+		{"first key", "Bearer 0123456789abcdef0123", http.StatusOK},
+		{"second key", "Bearer fedcba9876543210fedc", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/api/v1/rooms", nil)
+		if e.header != "" {
+			req.Header.Set("Authorization", e.header)
+		}
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if rr.Code == http.StatusUnauthorized && rr.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: expected a JSON error", e.name)
+		}
+	}
+
+	// each key is a partner of its own, which the handlers know without seeing the key
+	partners := make(map[string]bool)
+	for _, key := range app.APIKeys {
+		var partner string
+		h := APIAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			partner = helpers.APIPartner(r)
+		}))
+		req := httptest.NewRequest("GET", "/api/v1/rooms", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if partner == "" || partner == key {
+			t.Errorf("expected the key to identify a partner, got %q", partner)
+		}
+		partners[partner] = true
+	}
+	if len(partners) != 2 {
+		t.Errorf("expected the two keys to be different partners, got %v", partners)
+	}
+}
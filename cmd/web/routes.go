@@ -1,11 +1,13 @@
 package main
 
 import (
+	"net/http"
+
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/tsawler/bookings-app/internal/config"
 	"github.com/tsawler/bookings-app/internal/handlers"
-	"net/http"
+	"github.com/tsawler/bookings-app/internal/models"
 )
 
 func routes(app *config.AppConfig) http.Handler {
@@ -17,12 +19,16 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	// the rooms' pages before they moved into the catalogue
+	mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
 
 	mux.Get("/contact", handlers.Repo.Contact)
 
@@ -30,8 +36,68 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
+	mux.Get("/manage-booking", handlers.Repo.ManageBooking)
+	mux.Post("/manage-booking", handlers.Repo.PostManageBooking)
+	mux.Get("/manage-booking/details", handlers.Repo.ManageBookingDetails)
+	mux.Post("/manage-booking/dates", handlers.Repo.PostManageBookingDates)
+	mux.Post("/manage-booking/cancel", handlers.Repo.PostManageBookingCancel)
+
+	mux.Get("/user/login", handlers.Repo.ShowLogin)
+	mux.Post("/user/login", handlers.Repo.PostShowLogin)
+	mux.Get("/user/logout", handlers.Repo.Logout)
+
+	mux.Get("/ical/rooms/{id}.ics", handlers.Repo.ICalRoom)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
+	mux.Get("/api/openapi.json", handlers.Repo.OpenAPI)
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Use(APIAuth)
+		mux.NotFound(handlers.Repo.APINotFound)
+		mux.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+
+		mux.Get("/rooms", handlers.Repo.APIRooms)
+		mux.Get("/rooms/{id}/availability", handlers.Repo.APIRoomAvailability)
+		mux.Post("/reservations", handlers.Repo.APIPostReservation)
+		mux.Get("/reservations/{code}", handlers.Repo.APIReservation)
+		mux.Post("/reservations/{code}/status", handlers.Repo.APIPostReservationStatus)
+	})
+
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(Auth)
+		mux.Use(RequireAccess(models.AccessFrontDesk))
+
+		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+
+		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
+		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+		mux.Get("/reservations/{src}/{id}", handlers.Repo.AdminShowReservation)
+		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		mux.Post("/reservations/{src}/{id}/status", handlers.Repo.AdminPostReservationStatus)
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequireAccess(models.AccessManager))
+
+			mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+			mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+			mux.Get("/outbox", handlers.Repo.AdminOutbox)
+		})
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequireAccess(models.AccessAdmin))
+
+			mux.Get("/rooms", handlers.Repo.AdminRooms)
+			mux.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+			mux.Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+			mux.Get("/rooms/{id}/rules", handlers.Repo.AdminRoomRules)
+			mux.Post("/rooms/{id}/rules", handlers.Repo.AdminPostRoomRules)
+			mux.Post("/rooms/{id}/ical-token", handlers.Repo.AdminPostRoomICalToken)
+			mux.Get("/rooms/{id}/calendars", handlers.Repo.AdminRoomCalendars)
+			mux.Post("/rooms/{id}/calendars", handlers.Repo.AdminPostRoomCalendars)
+			mux.Post("/rooms/{id}/calendars/{feedID}/delete", handlers.Repo.AdminPostDeleteRoomCalendar)
+		})
+	})
+
 	return mux
 }
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
 	"github.com/go-chi/chi"
 	"github.com/tsawler/bookings-app/internal/config"
-	"testing"
 )
 
 func TestRoutes(t *testing.T) {
@@ -19,3 +24,61 @@ func TestRoutes(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not *chi.Mux, but is %T", v))
 	}
 }
+
+// TestAPIRoutesDocumented fails when an API route is missing from the OpenAPI document, or the document
+// describes a route that isn't there
+func TestAPIRoutesDocumented(t *testing.T) {
+	mux := routes(&app)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the OpenAPI document, got %d", rr.Code)
+	}
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") || len(doc.Servers) != 1 {
+		t.Fatalf("expected an OpenAPI 3 document with one server, got %s", rr.Body.String())
+	}
+
+	documented := make(map[string]bool)
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+doc.Servers[0].URL+path] = true
+		}
+	}
+
+	routed := make(map[string]bool)
+	err := chi.Walk(mux.(chi.Routes), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, "/api/v1/") {
+			routed[method+" "+route] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routed) == 0 {
+		t.Fatal("expected to find the API routes")
+	}
+
+	for route := range routed {
+		if !documented[route] {
+			t.Errorf("%s is routed but missing from the OpenAPI document", route)
+		}
+	}
+	for route := range documented {
+		if !routed[route] {
+			t.Errorf("%s is in the OpenAPI document but isn't routed", route)
+		}
+	}
+}
@@ -4,9 +4,19 @@ import (
 	"net/http"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/render"
 )
 
 func TestMain(m *testing.M) {
+	session = scs.New()
+	session.Lifetime = 24 * time.Hour
+	app.Session = session
+	helpers.NewHelpers(&app, nil, nil)
+	render.NewRenderer(&app)
 
 	os.Exit(m.Run())
 }
@@ -6,8 +6,10 @@ require (
 	github.com/alexedwards/scs/v2 v2.4.0
 	github.com/asaskevich/govalidator v0.0.0-20200907205600-7a23bdc65eef
 	github.com/go-chi/chi v1.5.1
-	github.com/jackc/pgconn v1.10.0 // indirect
-	github.com/jackc/pgx/v4 v4.13.0 // indirect
-	github.com/joho/godotenv v1.3.0 // indirect
+	github.com/jackc/pgconn v1.10.0
+	github.com/jackc/pgx/v4 v4.13.0
+	github.com/joho/godotenv v1.3.0
 	github.com/justinas/nosurf v1.1.1
+	github.com/nyaruka/phonenumbers v1.0.71
+	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97
 )
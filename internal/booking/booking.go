@@ -0,0 +1,214 @@
+package booking
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// Action is a move a reservation can make from its status, as offered to staff
+type Action struct {
+	To    string
+	Label string
+}
+
+// transitions are the moves allowed from each status. Checked out, cancelled and no-show reservations
+// are finished and can't move on.
+var transitions = map[string][]Action{
+	models.StatusPending: {
+		{To: models.StatusConfirmed, Label: "Confirm"},
+		{To: models.StatusCancelled, Label: "Cancel"},
+	},
+	models.StatusConfirmed: {
+		{To: models.StatusCheckedIn, Label: "Check in"},
+		{To: models.StatusNoShow, Label: "Mark as no-show"},
+		{To: models.StatusCancelled, Label: "Cancel"},
+	},
+	models.StatusCheckedIn: {
+		{To: models.StatusCheckedOut, Label: "Check out"},
+	},
+}
+
+// Actions returns the moves a reservation in status can make
+func Actions(status string) []Action {
+	return transitions[status]
+}
+
+// CanMove reports whether a reservation in status from may move to status to
+func CanMove(from, to string) bool {
+	for _, a := range transitions[from] {
+		if a.To == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Open reports whether a reservation in status is still to come, so its dates can be changed and it can
+// be cancelled
+func Open(status string) bool {
+	return status == models.StatusPending || status == models.StatusConfirmed
+}
+
+// IsStatus reports whether status is one of the statuses in models.Statuses
+func IsStatus(status string) bool {
+	_, ok := models.StatusNames[status]
+	return ok
+}
+
+// TransitionError is returned when a reservation can't make a move
+type TransitionError struct {
+	ReservationID int
+	From          string
+	To            string
+	// Reason says why, in words fit to show staff and guests
+	Reason string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("reservation %d can't move from %s to %s: %s", e.ReservationID, e.From, e.To, e.Reason)
+}
+
+// Check returns a *TransitionError if res can't move to status to on day. Besides being allowed from
+// its status, a guest can't check in or be a no-show before their arrival day.
+func Check(res models.Reservation, to string, day time.Time) error {
+	refuse := func(reason string) error {
+		return &TransitionError{ReservationID: res.ID, From: res.Status, To: to, Reason: reason}
+	}
+
+	if !IsStatus(to) {
+		return refuse(fmt.Sprintf("%q is not a status", to))
+	}
+	if !CanMove(res.Status, to) {
+		return refuse(fmt.Sprintf("a reservation that is %s can't be marked %s", lower(res.Status), lower(to)))
+	}
+
+	switch to {
+	case models.StatusCheckedIn:
+		if day.Before(res.StartDate) {
+			return refuse("guests can't check in before their arrival day")
+		}
+		if !day.Before(res.EndDate) {
+			return refuse("the stay has already ended")
+		}
+	case models.StatusNoShow:
+		if day.Before(res.StartDate) {
+			return refuse("guests can't be marked as no-shows before their arrival day")
+		}
+	}
+
+	return nil
+}
+
+// lower is a status's name as used in the middle of a sentence
+func lower(status string) string {
+	return strings.ToLower(models.StatusNames[status])
+}
+
+// Policy is the cancellation policy
+type Policy struct {
+	// FreeDays is how many days before arrival guests can still change their booking online, or cancel it
+	// for a full refund
+	FreeDays int
+	// LateRefund is the share of the total refunded for cancellations after that, up to the day before
+	// arrival, in basis points, so 5000 is 50%
+	LateRefund int
+}
+
+// Deadline is the last day res can be changed online or cancelled for a full refund
+func (p Policy) Deadline(res models.Reservation) time.Time {
+	return res.StartDate.AddDate(0, 0, -p.FreeDays)
+}
+
+// CanChange reports whether guests can still change res online on day. Even with no free days, they
+// can't once it is their arrival day.
+func (p Policy) CanChange(res models.Reservation, day time.Time) bool {
+	return Open(res.Status) && !day.After(p.Deadline(res)) && day.Before(res.StartDate)
+}
+
+// Refund is how much of res's total, in cents, is owed back if it is cancelled on day. Nothing is refunded
+// from the arrival day on.
+func (p Policy) Refund(res models.Reservation, day time.Time) int {
+	switch {
+	case !day.Before(res.StartDate):
+		// checked first, as with no free days the deadline is the arrival day
+		return 0
+	case !day.After(p.Deadline(res)):
+		return res.Quote.Total
+	}
+	// rounded to the nearest cent, as taxes are
+	return (res.Quote.Total*p.LateRefund + 5000) / 10000
+}
+
+// MailFunc renders the mail to send about a reservation's move, given the reservation as it is after it
+type MailFunc func(res models.Reservation) ([]models.MailData, error)
+
+// Service moves reservations between statuses, for staff, guests and partner sites alike
+type Service struct {
+	DB     repository.DatabaseRepo
+	Policy Policy
+}
+
+// NewService creates a service for the reservations in db, cancelled under policy p
+func NewService(db repository.DatabaseRepo, p Policy) *Service {
+	return &Service{
+		DB:     db,
+		Policy: p,
+	}
+}
+
+// Transition moves reservation id to status to at now, recording when it did and, if it is cancelled, the
+// refund it is owed. The mail rendered by mail, which may be nil, is sent with the move. A move that isn't
+// allowed gives a *TransitionError, and an unknown reservation sql.ErrNoRows.
+func (s *Service) Transition(id int, to string, now time.Time, mail MailFunc) (models.Reservation, error) {
+	res, err := s.DB.GetReservationByID(id)
+	if err != nil {
+		return res, err
+	}
+
+	day := Day(now)
+	if err := Check(res, to, day); err != nil {
+		return res, err
+	}
+
+	from := res.Status
+	moved := res
+	moved.Status = to
+	moved.SetStatusTime(to, now)
+	if to == models.StatusCancelled {
+		moved.Refund = s.Policy.Refund(res, day)
+	}
+
+	var messages []models.MailData
+	if mail != nil {
+		messages, err = mail(moved)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	err = s.DB.UpdateReservationStatus(moved, from, messages...)
+	if err == sql.ErrNoRows {
+		return res, &TransitionError{
+			ReservationID: id,
+			From:          from,
+			To:            to,
+			Reason:        "someone else changed the reservation first, please try again",
+		}
+	}
+	if err != nil {
+		return res, err
+	}
+
+	return s.DB.GetReservationByID(id)
+}
+
+// Day is the date of t, at midnight UTC like the dates parsed from forms
+func Day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
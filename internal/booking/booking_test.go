@@ -0,0 +1,179 @@
+package booking
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// arrival is the first night of the stays in these tests
+var arrival = time.Date(2030, time.August, 10, 0, 0, 0, 0, time.UTC)
+
+func stay(status string) models.Reservation {
+	return models.Reservation{
+		ID:        1,
+		Status:    status,
+		StartDate: arrival,
+		EndDate:   arrival.AddDate(0, 0, 3),
+		Quote:     models.Quote{Total: 33333},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var tests = []struct {
+		name  string
+		from  string
+		to    string
+		day   time.Time
+		valid bool
+	}{
+		{"confirm", models.StatusPending, models.StatusConfirmed, arrival.AddDate(0, -1, 0), true},
+		{"cancel new", models.StatusPending, models.StatusCancelled, arrival, true},
+		{"check in unconfirmed", models.StatusPending, models.StatusCheckedIn, arrival, false},
+		{"check in", models.StatusConfirmed, models.StatusCheckedIn, arrival, true},
+		{"check in early", models.StatusConfirmed, models.StatusCheckedIn, arrival.AddDate(0, 0, -1), false},
+		{"check in after the stay", models.StatusConfirmed, models.StatusCheckedIn, arrival.AddDate(0, 0, 3), false},
+		{"no-show", models.StatusConfirmed, models.StatusNoShow, arrival.AddDate(0, 0, 1), true},
+		{"no-show early", models.StatusConfirmed, models.StatusNoShow, arrival.AddDate(0, 0, -1), false},
+		{"check out", models.StatusCheckedIn, models.StatusCheckedOut, arrival.AddDate(0, 0, 3), true},
+		{"cancel a stay under way", models.StatusCheckedIn, models.StatusCancelled, arrival, false},
+		{"confirm cancelled", models.StatusCancelled, models.StatusConfirmed, arrival.AddDate(0, -1, 0), false},
+		{"stay put", models.StatusConfirmed, models.StatusConfirmed, arrival, false},
+		{"unknown status", models.StatusPending, "approved", arrival, false},
+	}
+
+	for _, e := range tests {
+		err := Check(stay(e.from), e.to, e.day)
+		if e.valid && err != nil {
+			t.Errorf("%s: expected the move to be allowed, got %s", e.name, err)
+		}
+		var te *TransitionError
+		if !e.valid && !errors.As(err, &te) {
+			t.Errorf("%s: expected a *TransitionError, got %v", e.name, err)
+		}
+	}
+}
+
+func TestActions(t *testing.T) {
+	for _, status := range models.Statuses {
+		for _, a := range Actions(status) {
+			if !CanMove(status, a.To) || !IsStatus(a.To) || a.Label == "" {
+				t.Errorf("unexpected action %+v from %s", a, status)
+			}
+		}
+	}
+	for _, status := range []string{models.StatusCheckedOut, models.StatusCancelled, models.StatusNoShow} {
+		if len(Actions(status)) != 0 || Open(status) {
+			t.Errorf("expected a %s reservation to be finished", status)
+		}
+	}
+}
+
+func TestPolicy_Refund(t *testing.T) {
+	p := Policy{FreeDays: 7, LateRefund: 5000}
+	res := stay(models.StatusConfirmed)
+
+	noFreeDays := Policy{FreeDays: 0, LateRefund: 5000}
+
+	var tests = []struct {
+		name     string
+		policy   Policy
+		day      time.Time
+		expected int
+	}{
+		{"long before", p, arrival.AddDate(0, -2, 0), 33333},
+		{"on the deadline", p, arrival.AddDate(0, 0, -7), 33333},
+		{"after the deadline", p, arrival.AddDate(0, 0, -6), 16667}, // half of 333.33, rounded up
+		{"the day before arrival", p, arrival.AddDate(0, 0, -1), 16667},
+		{"on arrival", p, arrival, 0},
+		{"during the stay", p, arrival.AddDate(0, 0, 1), 0},
+		{"no free days, the day before arrival", noFreeDays, arrival.AddDate(0, 0, -1), 33333},
+		{"no free days, on arrival", noFreeDays, arrival, 0},
+	}
+
+	for _, e := range tests {
+		if refund := e.policy.Refund(res, e.day); refund != e.expected {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expected, refund)
+		}
+	}
+
+	if !p.CanChange(res, arrival.AddDate(0, 0, -7)) || p.CanChange(res, arrival.AddDate(0, 0, -6)) {
+		t.Error("expected changes to be allowed up to the deadline only")
+	}
+	if p.CanChange(stay(models.StatusCancelled), arrival.AddDate(0, -1, 0)) {
+		t.Error("expected a cancelled booking not to be changed")
+	}
+	if (Policy{}).CanChange(res, arrival) {
+		t.Error("expected a booking not to be changed on its arrival day")
+	}
+}
+
+// fakeDB holds one reservation. Calling any other method of the repository panics.
+type fakeDB struct {
+	repository.DatabaseRepo
+	res  models.Reservation
+	mail []models.MailData
+	// stale makes the next update lose a race with another change
+	stale bool
+}
+
+func (f *fakeDB) GetReservationByID(id int) (models.Reservation, error) {
+	if id != f.res.ID {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+	return f.res, nil
+}
+
+func (f *fakeDB) UpdateReservationStatus(res models.Reservation, from string, mail ...models.MailData) error {
+	if f.stale || f.res.Status != from {
+		return sql.ErrNoRows
+	}
+	f.res = res
+	f.mail = append(f.mail, mail...)
+	return nil
+}
+
+func TestService_Transition(t *testing.T) {
+	db := &fakeDB{res: stay(models.StatusConfirmed)}
+	s := NewService(db, Policy{FreeDays: 7, LateRefund: 2500})
+	now := time.Date(2030, time.August, 5, 14, 30, 0, 0, time.UTC)
+
+	mail := func(res models.Reservation) ([]models.MailData, error) {
+		return []models.MailData{{To: "guest@here.com", Subject: res.Status}}, nil
+	}
+	res, err := s.Transition(1, models.StatusCancelled, now, mail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a quarter of 333.33, rounded down
+	if !res.Cancelled() || !res.CancelledAt.Equal(now) || res.Refund != 8333 {
+		t.Errorf("expected the reservation to be cancelled with a late refund, got %+v", res)
+	}
+	if len(db.mail) != 1 || db.mail[0].Subject != models.StatusCancelled {
+		t.Errorf("expected the mail about the cancelled reservation to be sent, got %+v", db.mail)
+	}
+
+	var te *TransitionError
+	if _, err := s.Transition(1, models.StatusConfirmed, now, nil); !errors.As(err, &te) {
+		t.Errorf("expected a cancelled reservation not to be confirmed, got %v", err)
+	}
+	if _, err := s.Transition(2, models.StatusConfirmed, now, nil); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for an unknown reservation, got %v", err)
+	}
+
+	db.res = stay(models.StatusPending)
+	db.stale = true
+	if _, err := s.Transition(1, models.StatusConfirmed, now, nil); !errors.As(err, &te) {
+		t.Errorf("expected losing a race to be a *TransitionError, got %v", err)
+	}
+
+	db.stale = false
+	failing := func(models.Reservation) ([]models.MailData, error) { return nil, errors.New("no template") }
+	if _, err := s.Transition(1, models.StatusConfirmed, now, failing); err == nil || db.res.Status != models.StatusPending {
+		t.Errorf("expected the reservation not to move when its mail can't be rendered, got %v", err)
+	}
+}
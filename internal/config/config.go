@@ -5,6 +5,8 @@ import (
 	"log"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/tsawler/bookings-app/internal/booking"
+	"github.com/tsawler/bookings-app/internal/pricing"
 )
 
 // AppConfig holds the application config
@@ -15,4 +17,11 @@ type AppConfig struct {
 	ErrorLog      *log.Logger
 	InProduction  bool
 	Session       *scs.SessionManager
+	Pricing       pricing.Policy
+	PhoneRegion   string
+	OwnerEmail    string
+	// APIKeys are the keys partner sites use the API with
+	APIKeys []string
+	// Cancellation is the policy for changing and cancelling bookings
+	Cancellation booking.Policy
 }
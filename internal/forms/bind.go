@@ -0,0 +1,149 @@
+package forms
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/rules"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Bind parses the request's form and fills dst, a pointer to a struct, from it. The form is read from
+// the body for POST, PUT and PATCH requests and from the url otherwise. See Decode for the tags used.
+// The error is only for a request that can't be parsed or a dst that can't be filled; bad input ends
+// up in the returned form's Errors.
+func Bind(r *http.Request, dst interface{}) (*Form, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+
+	form := New(r.Form)
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		form = New(r.PostForm)
+	}
+
+	return form, form.Decode(dst)
+}
+
+// Decode fills dst, a pointer to a struct, from the form. Each field with a `form:"name"` tag is set
+// from that form value: strings as posted, ints and bools parsed, and time.Times as dates in DateLayout.
+// A value that can't be parsed is a field error, as is a broken rule from the field's `validate` tag,
+// a comma separated list of:
+//
+//	required    the field can't be blank
+//	min=n       strings are at least n characters long and ints are at least n
+//	email       an email address
+//	slug        a url slug
+//	notpast     a date no earlier than today
+//	after=name  a date after the one in the form field name
+func (f *Form) Decode(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("forms: can't decode into %T, it must be a pointer to a struct", dst)
+	}
+	v = v.Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("form")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		if err := f.decodeField(v.Field(i), name); err != nil {
+			return fmt.Errorf("forms: %s.%s: %w", t.Name(), field.Name, err)
+		}
+		if err := f.validate(v.Field(i), name, field.Tag.Get("validate")); err != nil {
+			return fmt.Errorf("forms: %s.%s: %w", t.Name(), field.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// decodeField sets the struct field v from the form value name
+func (f *Form) decodeField(v reflect.Value, name string) error {
+	value := strings.TrimSpace(f.Get(name))
+
+	switch {
+	case v.Type() == timeType:
+		if value != "" && f.IsDate(name, DateLayout) {
+			v.Set(reflect.ValueOf(f.Date(name)))
+		}
+	case v.Kind() == reflect.String:
+		v.SetString(f.Get(name))
+	case v.Kind() == reflect.Int:
+		if value == "" {
+			return nil
+		}
+		x, err := strconv.Atoi(value)
+		if err != nil {
+			f.Errors.Add(name, "This field must be a whole number")
+			return nil
+		}
+		v.SetInt(int64(x))
+	case v.Kind() == reflect.Bool:
+		// an unticked checkbox isn't posted at all, and a ticked one is "on" unless it has a value
+		v.SetBool(value == "on" || value == "true" || value == "1")
+	default:
+		return fmt.Errorf("can't decode into a %s", v.Type())
+	}
+
+	return nil
+}
+
+// validate checks the form value name against the rules in tag
+func (f *Form) validate(v reflect.Value, name, tag string) error {
+	if tag == "" {
+		return nil
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		rule, arg := strings.TrimSpace(rule), ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			rule, arg = rule[:i], rule[i+1:]
+		}
+
+		switch rule {
+		case "required":
+			f.Required(name)
+		case "min":
+			min, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("bad min rule %q", arg)
+			}
+			if v.Kind() == reflect.Int {
+				if f.Has(name) && f.Errors.Get(name) == "" {
+					f.MinInt(name, min)
+				}
+			} else {
+				f.MinLength(name, min)
+			}
+		case "email":
+			f.IsEmail(name)
+		case "slug":
+			f.IsSlug(name)
+		case "url":
+			f.IsWebURL(name)
+		case "notpast":
+			f.DateNotInPast(name, rules.Today())
+		case "after":
+			if arg == "" {
+				return fmt.Errorf("the after rule needs a field")
+			}
+			f.DateAfter(name, arg)
+		default:
+			return fmt.Errorf("unknown rule %q", rule)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package forms
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bookingForm struct {
+	Name      string    `form:"name" validate:"required,min=3"`
+	Email     string    `form:"email" validate:"required,email"`
+	Guests    int       `form:"guests" validate:"required,min=1"`
+	Breakfast bool      `form:"breakfast"`
+	Arrival   time.Time `form:"arrival" validate:"required,notpast"`
+	Departure time.Time `form:"departure" validate:"required,after=arrival"`
+	Notes     string    `form:"-"`
+}
+
+func TestBind(t *testing.T) {
+	valid := url.Values{
+		"name":      {"Jane"},
+		"email":     {"jane@here.com"},
+		"guests":    {" 2 "},
+		"breakfast": {"on"},
+		"arrival":   {"2050-01-10"},
+		"departure": {"2050-01-12"},
+		"notes":     {"ignored"},
+	}
+
+	var tests = []struct {
+		name   string
+		change url.Values
+		field  string
+		error  string
+	}{
+		{"valid", nil, "", ""},
+		{"blank name", url.Values{"name": {""}}, "name", "This field cannot be blank"},
+		{"short name", url.Values{"name": {"Jo"}}, "name", "This field must be at least 3 characters long"},
+		{"bad email", url.Values{"email": {"jane"}}, "email", "Invalid email address"},
+		{"guests not a number", url.Values{"guests": {"two"}}, "guests", "This field must be a whole number"},
+		{"no guests", url.Values{"guests": {"0"}}, "guests", "This field must be a whole number of at least 1"},
+		{"bad arrival", url.Values{"arrival": {"soon"}}, "arrival", "This field must be a date like 2021-12-31"},
+		{"past arrival", url.Values{"arrival": {"2000-01-10"}}, "arrival", "This date can't be in the past"},
+		{"departure before arrival", url.Values{"departure": {"2050-01-09"}}, "departure", "This date must be after 2050-01-10"},
+		{"missing departure", url.Values{"departure": {""}}, "departure", "This field cannot be blank"},
+	}
+
+	for _, e := range tests {
+		values := url.Values{}
+		for k, v := range valid {
+			values[k] = v
+		}
+		for k, v := range e.change {
+			values[k] = v
+		}
+
+		req := httptest.NewRequest("POST", "/book", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		var dst bookingForm
+		form, err := Bind(req, &dst)
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+
+		if e.field == "" {
+			if !form.Valid() {
+				t.Errorf("%s: expected no errors, got %v", e.name, form.Errors)
+			}
+			arrival := time.Date(2050, time.January, 10, 0, 0, 0, 0, time.UTC)
+			if dst.Name != "Jane" || dst.Guests != 2 || !dst.Breakfast || !dst.Arrival.Equal(arrival) ||
+				!dst.Departure.Equal(arrival.AddDate(0, 0, 2)) || dst.Notes != "" {
+				t.Errorf("%s: form was not bound as posted: %+v", e.name, dst)
+			}
+			continue
+		}
+
+		if form.Errors.Get(e.field) != e.error {
+			t.Errorf("%s: expected %q on %s but got %q", e.name, e.error, e.field, form.Errors.Get(e.field))
+		}
+	}
+}
+
+func TestBind_Query(t *testing.T) {
+	req := httptest.NewRequest("GET", "/book?name=Jane&guests=3", nil)
+
+	var dst struct {
+		Name   string `form:"name"`
+		Guests int    `form:"guests"`
+	}
+	form, err := Bind(req, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !form.Valid() || dst.Name != "Jane" || dst.Guests != 3 {
+		t.Errorf("expected a GET to bind from the url, got %+v", dst)
+	}
+}
+
+func TestForm_DecodeErrors(t *testing.T) {
+	form := New(url.Values{})
+
+	var notStruct string
+	if err := form.Decode(&notStruct); err == nil {
+		t.Error("expected a pointer to a string to be refused")
+	}
+	if err := form.Decode(bookingForm{}); err == nil {
+		t.Error("expected a struct that isn't a pointer to be refused")
+	}
+
+	var unsupported struct {
+		Price float64 `form:"price"`
+	}
+	if err := form.Decode(&unsupported); err == nil {
+		t.Error("expected an unsupported field type to be refused")
+	}
+
+	var unknown struct {
+		Name string `form:"name" validate:"required,shiny"`
+	}
+	if err := form.Decode(&unknown); err == nil {
+		t.Error("expected an unknown rule to be refused")
+	}
+}
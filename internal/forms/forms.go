@@ -3,9 +3,14 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/asaskevich/govalidator"
+	"github.com/nyaruka/phonenumbers"
+	"github.com/tsawler/bookings-app/internal/rules"
 )
 
 // Form creates a custom form struct and embeds a url.Values object
@@ -62,3 +67,134 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// IsSlug checks for a url slug: lower case letters and digits, separated by single hyphens
+func (f *Form) IsSlug(field string) {
+	if !slugPattern.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use lower case letters, digits and hyphens only")
+	}
+}
+
+// IsWebURL checks for an absolute http or https address
+func (f *Form) IsWebURL(field string) {
+	u, err := url.Parse(strings.TrimSpace(f.Get(field)))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		f.Errors.Add(field, "Enter a web address starting with http:// or https://")
+	}
+}
+
+// MinInt checks for a whole number of at least min
+func (f *Form) MinInt(field string, min int) bool {
+	x, err := strconv.Atoi(strings.TrimSpace(f.Get(field)))
+	if err != nil || x < min {
+		f.Errors.Add(field, fmt.Sprintf("This field must be a whole number of at least %d", min))
+		return false
+	}
+	return true
+}
+
+// IsPhone checks for a phone number that can be dialled. Numbers without a +country code are read as
+// being in region, a two letter code such as "US". A blank field is left to Required.
+func (f *Form) IsPhone(field, region string) bool {
+	if strings.TrimSpace(f.Get(field)) == "" {
+		return true
+	}
+	if f.Phone(field, region) == "" {
+		f.Errors.Add(field, "Invalid phone number")
+		return false
+	}
+	return true
+}
+
+// Phone returns the field's phone number in E.164 form, such as +12025550143, or "" if it isn't one
+func (f *Form) Phone(field, region string) string {
+	number, err := phonenumbers.Parse(f.Get(field), strings.ToUpper(region))
+	if err != nil || !phonenumbers.IsValidNumber(number) {
+		return ""
+	}
+	return phonenumbers.Format(number, phonenumbers.E164)
+}
+
+// IsPhoneRegion reports whether region is a two letter region code IsPhone knows
+func IsPhoneRegion(region string) bool {
+	return phonenumbers.GetCountryCodeForRegion(strings.ToUpper(region)) != 0
+}
+
+// DateLayout is the layout of the dates posted by the site's forms
+const DateLayout = "2006-01-02"
+
+// IsDate checks for a date in the given layout
+func (f *Form) IsDate(field, layout string) bool {
+	if _, err := time.Parse(layout, strings.TrimSpace(f.Get(field))); err != nil {
+		f.Errors.Add(field, fmt.Sprintf("This field must be a date like %s", time.Date(2021, time.December, 31, 0, 0, 0, 0, time.UTC).Format(layout)))
+		return false
+	}
+	return true
+}
+
+// Date returns the field's value as a date in DateLayout, or the zero time if it isn't one
+func (f *Form) Date(field string) time.Time {
+	d, _ := time.Parse(DateLayout, strings.TrimSpace(f.Get(field)))
+	return d
+}
+
+// DateAfter checks that the date in field is after the date in other. Like the other date validators
+// it leaves dates that aren't in DateLayout to IsDate.
+func (f *Form) DateAfter(field, other string) bool {
+	d, o := f.Date(field), f.Date(other)
+	if d.IsZero() || o.IsZero() || d.After(o) {
+		return true
+	}
+	f.Errors.Add(field, fmt.Sprintf("This date must be after %s", o.Format(DateLayout)))
+	return false
+}
+
+// DateNotInPast checks that the date in field is today or later
+func (f *Form) DateNotInPast(field string, today time.Time) bool {
+	d := f.Date(field)
+	if d.IsZero() || !d.Before(today) {
+		return true
+	}
+	f.Errors.Add(field, "This date can't be in the past")
+	return false
+}
+
+// DateRangeMaxDays checks that the dates in the start and end fields are at most max days apart,
+// reporting a range that is too long on the end field
+func (f *Form) DateRangeMaxDays(start, end string, max int) bool {
+	s, e := f.Date(start), f.Date(end)
+	if s.IsZero() || e.IsZero() || !e.After(s.AddDate(0, 0, max)) {
+		return true
+	}
+	f.Errors.Add(end, fmt.Sprintf("The dates can be at most %d days apart", max))
+	return false
+}
+
+// StayDates checks the yyyy-mm-dd dates in the arrival and departure fields against a room's stay rules.
+// Each broken rule is reported on the field it is about.
+func (f *Form) StayDates(arrival, departure string, r rules.StayRules, today time.Time) bool {
+	start, err := time.Parse("2006-01-02", f.Get(arrival))
+	if err != nil {
+		f.Errors.Add(arrival, "Invalid arrival date")
+	}
+	end, err2 := time.Parse("2006-01-02", f.Get(departure))
+	if err2 != nil {
+		f.Errors.Add(departure, "Invalid departure date")
+	}
+	if err != nil || err2 != nil {
+		return false
+	}
+
+	violations := r.Check(start, end, today)
+	for _, v := range violations {
+		field := arrival
+		if v.Field == rules.Departure {
+			field = departure
+		}
+		f.Errors.Add(field, v.Message)
+	}
+
+	return len(violations) == 0
+}
@@ -4,6 +4,9 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/rules"
 )
 
 // An empty form should be valid
@@ -127,3 +130,194 @@ func TestForm_IsEmail(t *testing.T) {
 	}
 
 }
+
+func TestForm_IsSlug(t *testing.T) {
+	var tests = []struct {
+		slug  string
+		valid bool
+	}{
+		{"majors-suite", true},
+		{"room-12", true},
+		{"", false},
+		{"Majors-Suite", false},
+		{"majors--suite", false},
+		{"-majors", false},
+		{"majors suite", false},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"slug": []string{e.slug}})
+		form.IsSlug("slug")
+		if form.Valid() != e.valid {
+			t.Errorf("%q: expected valid to be %t", e.slug, e.valid)
+		}
+	}
+}
+
+func TestForm_IsWebURL(t *testing.T) {
+	var tests = []struct {
+		url   string
+		valid bool
+	}{
+		{"https://www.othersite.com/calendar/ical/123.ics?s=abc", true},
+		{" http://localhost:8080/feed.ics ", true},
+		{"", false},
+		{"www.othersite.com/feed.ics", false},
+		{"ftp://othersite.com/feed.ics", false},
+		{"https://", false},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"url": []string{e.url}})
+		form.IsWebURL("url")
+		if form.Valid() != e.valid {
+			t.Errorf("%q: expected valid to be %t", e.url, e.valid)
+		}
+	}
+}
+
+func TestForm_MinInt(t *testing.T) {
+	var tests = []struct {
+		value string
+		valid bool
+	}{
+		{"2", true},
+		{" 10 ", true},
+		{"1", false},
+		{"two", false},
+		{"", false},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"capacity": []string{e.value}})
+		if form.MinInt("capacity", 2) != e.valid || form.Valid() != e.valid {
+			t.Errorf("%q: expected valid to be %t", e.value, e.valid)
+		}
+	}
+}
+
+func TestForm_StayDates(t *testing.T) {
+	today := time.Date(2030, time.July, 1, 0, 0, 0, 0, time.UTC)
+	stayRules := rules.Default(1)
+	stayRules.MinNights = 2
+
+	var tests = []struct {
+		name           string
+		arrival        string
+		departure      string
+		arrivalError   string
+		departureError string
+	}{
+		{"valid", "2030-07-10", "2030-07-12", "", ""},
+		{"bad arrival", "10/07/2030", "2030-07-12", "Invalid arrival date", ""},
+		{"bad departure", "2030-07-10", "", "", "Invalid departure date"},
+		{"too short", "2030-07-10", "2030-07-11", "", "Stays in this room are at least 2 nights"},
+		{"in the past", "2030-06-10", "2030-06-12", "Arrival can't be in the past", ""},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"start_date": []string{e.arrival}, "end_date": []string{e.departure}})
+		valid := form.StayDates("start_date", "end_date", stayRules, today)
+		if valid != (e.arrivalError == "" && e.departureError == "") || valid != form.Valid() {
+			t.Errorf("%s: got valid %t", e.name, valid)
+		}
+		if form.Errors.Get("start_date") != e.arrivalError {
+			t.Errorf("%s: expected arrival error %q but got %q", e.name, e.arrivalError, form.Errors.Get("start_date"))
+		}
+		if form.Errors.Get("end_date") != e.departureError {
+			t.Errorf("%s: expected departure error %q but got %q", e.name, e.departureError, form.Errors.Get("end_date"))
+		}
+	}
+}
+
+func TestForm_IsDate(t *testing.T) {
+	var tests = []struct {
+		value  string
+		layout string
+		valid  bool
+	}{
+		{"2050-01-31", DateLayout, true},
+		{" 2050-01-31 ", DateLayout, true},
+		{"31/01/2050", "02/01/2006", true},
+		{"2050-02-30", DateLayout, false},
+		{"31/01/2050", DateLayout, false},
+		{"", DateLayout, false},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"date": []string{e.value}})
+		if form.IsDate("date", e.layout) != e.valid || form.Valid() != e.valid {
+			t.Errorf("%q in %s: expected valid to be %t", e.value, e.layout, e.valid)
+		}
+	}
+
+	form := New(url.Values{"date": []string{"soon"}})
+	form.IsDate("date", "02/01/2006")
+	if form.Errors.Get("date") != "This field must be a date like 31/12/2021" {
+		t.Errorf("expected the error to show the layout, got %q", form.Errors.Get("date"))
+	}
+}
+
+func TestForm_DateRules(t *testing.T) {
+	today := time.Date(2030, time.July, 1, 0, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name     string
+		start    string
+		end      string
+		validate func(f *Form) bool
+		valid    bool
+		field    string
+	}{
+		{"after", "2030-07-10", "2030-07-11", func(f *Form) bool { return f.DateAfter("end", "start") }, true, ""},
+		{"same day", "2030-07-10", "2030-07-10", func(f *Form) bool { return f.DateAfter("end", "start") }, false, "end"},
+		{"before", "2030-07-10", "2030-07-09", func(f *Form) bool { return f.DateAfter("end", "start") }, false, "end"},
+		{"after with a bad date", "2030-07-10", "never", func(f *Form) bool { return f.DateAfter("end", "start") }, true, ""},
+		{"today", "2030-07-01", "", func(f *Form) bool { return f.DateNotInPast("start", today) }, true, ""},
+		{"yesterday", "2030-06-30", "", func(f *Form) bool { return f.DateNotInPast("start", today) }, false, "start"},
+		{"in range", "2030-07-01", "2030-07-08", func(f *Form) bool { return f.DateRangeMaxDays("start", "end", 7) }, true, ""},
+		{"out of range", "2030-07-01", "2030-07-09", func(f *Form) bool { return f.DateRangeMaxDays("start", "end", 7) }, false, "end"},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"start": []string{e.start}, "end": []string{e.end}})
+		if e.validate(form) != e.valid || form.Valid() != e.valid {
+			t.Errorf("%s: expected valid to be %t", e.name, e.valid)
+		}
+		if e.field != "" && form.Errors.Get(e.field) == "" {
+			t.Errorf("%s: expected an error on %s", e.name, e.field)
+		}
+	}
+}
+
+func TestForm_IsPhone(t *testing.T) {
+	var tests = []struct {
+		value    string
+		region   string
+		valid    bool
+		expected string
+	}{
+		{"(202) 555-0143", "US", true, "+12025550143"},
+		{"202.555.0143", "us", true, "+12025550143"},
+		{"+44 20 7946 0958", "US", true, "+442079460958"},
+		{"020 7946 0958", "GB", true, "+442079460958"},
+		{"020 7946 0958", "US", false, ""},
+		{"", "US", true, ""},
+		{"555-555-5555", "US", false, ""},
+		{"phone me", "US", false, ""},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"phone": []string{e.value}})
+		if form.IsPhone("phone", e.region) != e.valid || form.Valid() != e.valid {
+			t.Errorf("%q in %s: expected valid to be %t", e.value, e.region, e.valid)
+		}
+		if phone := form.Phone("phone", e.region); phone != e.expected {
+			t.Errorf("%q in %s: expected %q but got %q", e.value, e.region, e.expected, phone)
+		}
+	}
+
+	if !IsPhoneRegion("gb") || IsPhoneRegion("XX") {
+		t.Error("expected GB to be a phone region and XX not to be")
+	}
+}
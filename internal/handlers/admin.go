@@ -0,0 +1,731 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/booking"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
+	"github.com/tsawler/bookings-app/internal/render"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"github.com/tsawler/bookings-app/internal/rules"
+)
+
+// AdminDashboard shows the staff dashboard
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+}
+
+// AdminNewReservations shows the reservations nobody has confirmed yet
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllNewReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+
+	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminAllReservations shows every reservation
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+
+	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// reservationFromURL reads the {src} and {id} url parameters shared by the reservation detail routes.
+// src is the list the user came from, and is where they go back to.
+func reservationFromURL(r *http.Request) (string, int, bool) {
+	src := chi.URLParam(r, "src")
+	if src != "new" && src != "all" {
+		return "", 0, false
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return "", 0, false
+	}
+
+	return src, id, true
+}
+
+// AdminShowReservation shows a reservation so staff can edit it
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	src, id, ok := reservationFromURL(r)
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err == sql.ErrNoRows {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.showReservation(w, r, src, res, forms.New(nil))
+}
+
+// statusChange is a move in a reservation's history
+type statusChange struct {
+	Status string
+	At     time.Time
+}
+
+// showReservation renders the reservation page for res, with the moves staff can make it and the
+// times it made those it already has
+func (m *Repository) showReservation(w http.ResponseWriter, r *http.Request, src string, res models.Reservation, form *forms.Form) {
+	var history []statusChange
+	for _, status := range models.Statuses {
+		if at := res.StatusTime(status); !at.IsZero() {
+			history = append(history, statusChange{Status: models.StatusNames[status], At: at})
+		}
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["history"] = history
+	data["actions"] = booking.Actions(res.Status)
+	data["cancellable"] = booking.CanMove(res.Status, models.StatusCancelled)
+	data["refund"] = m.App.Cancellation.Refund(res, rules.Today())
+
+	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// guestForm is the form staff edit a reservation's guest details with
+type guestForm struct {
+	FirstName string `form:"first_name" validate:"required"`
+	LastName  string `form:"last_name" validate:"required"`
+	Email     string `form:"email" validate:"required,email"`
+	Phone     string `form:"phone"`
+}
+
+// AdminPostShowReservation saves changes to a reservation's guest details
+func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	var guest guestForm
+	form, err := forms.Bind(r, &guest)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form.IsPhone("phone", m.App.PhoneRegion)
+
+	src, id, ok := reservationFromURL(r)
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err == sql.ErrNoRows {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res.FirstName = guest.FirstName
+	res.LastName = guest.LastName
+	res.Email = guest.Email
+	res.Phone = guest.Phone
+
+	if !form.Valid() {
+		m.showReservation(w, r, src, res, form)
+		return
+	}
+	res.Phone = form.Phone("phone", m.App.PhoneRegion)
+
+	err = m.DB.UpdateReservation(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
+
+// AdminPostReservationStatus moves a reservation to the posted status, such as checked-in
+func (m *Repository) AdminPostReservationStatus(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src, id, ok := reservationFromURL(r)
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.bookings().Transition(id, r.PostForm.Get("status"), time.Now(), m.statusMail(""))
+	var refused *booking.TransitionError
+	if errors.As(err, &refused) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, "+refused.Reason)
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
+		return
+	}
+	if err == sql.ErrNoRows {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation marked %s", strings.ToLower(res.StatusName())))
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
+
+// calendarDay is one night in a room's row of the reservations calendar. Owner blocks can be
+// ticked on and off from the calendar; other blocks are only shown.
+type calendarDay struct {
+	Date          time.Time
+	ReservationID int
+	BlockID       int
+	Block         models.Restriction
+}
+
+// IsOwnerBlock reports whether the night is held by an owner block
+func (d calendarDay) IsOwnerBlock() bool {
+	return d.BlockID > 0 && d.Block.ID == models.RestrictionOwnerBlock
+}
+
+// BlockLabel is the letter the calendar shows for a block other than an owner block: the first letter
+// of its type's name, such as M for maintenance, or ? if the type has no name
+func (d calendarDay) BlockLabel() string {
+	first, _ := utf8.DecodeRuneInString(d.Block.RestrictionName)
+	if first == utf8.RuneError {
+		return "?"
+	}
+	return string(unicode.ToUpper(first))
+}
+
+// calendarRow is one room's row of the reservations calendar
+type calendarRow struct {
+	Room models.Room
+	Days []calendarDay
+}
+
+// calendarMonth returns the first day of the month named by year and month strings,
+// or of the current month if both are empty
+func calendarMonth(year, month string) (time.Time, bool) {
+	if year == "" && month == "" {
+		now := time.Now()
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), true
+	}
+
+	y, err := strconv.Atoi(year)
+	if err != nil || y < 1 {
+		return time.Time{}, false
+	}
+	mo, err := strconv.Atoi(month)
+	if err != nil || mo < 1 || mo > 12 {
+		return time.Time{}, false
+	}
+
+	return time.Date(y, time.Month(mo), 1, 0, 0, 0, 0, time.UTC), true
+}
+
+// AdminReservationsCalendar shows, for each room, which nights of a month are reserved or blocked
+func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	first, ok := calendarMonth(r.URL.Query().Get("y"), r.URL.Query().Get("m"))
+	if !ok {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	next := first.AddDate(0, 1, 0)
+	last := first.AddDate(0, -1, 0)
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var days []time.Time
+	for d := first; d.Before(next); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+
+	var rows []calendarRow
+	for _, room := range rooms {
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, first, next)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		row := calendarRow{Room: room, Days: make([]calendarDay, len(days))}
+		for i, d := range days {
+			row.Days[i].Date = d
+		}
+
+		for _, rr := range restrictions {
+			for d := rr.StartDate; d.Before(rr.EndDate); d = d.AddDate(0, 0, 1) {
+				if d.Before(first) || !d.Before(next) {
+					continue
+				}
+				day := &row.Days[d.Day()-1]
+				if rr.ReservationID > 0 {
+					day.ReservationID = rr.ReservationID
+				} else {
+					day.BlockID = rr.ID
+					day.Block = rr.Restriction
+				}
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["this_month"] = first.Format("January 2006")
+	stringMap["y"] = first.Format("2006")
+	stringMap["m"] = first.Format("01")
+	stringMap["next_y"] = next.Format("2006")
+	stringMap["next_m"] = next.Format("01")
+	stringMap["last_y"] = last.Format("2006")
+	stringMap["last_m"] = last.Format("01")
+
+	data := make(map[string]interface{})
+	data["days"] = days
+	data["rows"] = rows
+
+	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// keptRuns splits the nights of rr that keep reports true for into runs of consecutive nights
+func keptRuns(rr models.RoomRestriction, keep func(night time.Time) bool) []models.RoomRestriction {
+	var runs []models.RoomRestriction
+	for d := rr.StartDate; d.Before(rr.EndDate); d = d.AddDate(0, 0, 1) {
+		if !keep(d) {
+			continue
+		}
+		if n := len(runs); n > 0 && runs[n-1].EndDate.Equal(d) {
+			runs[n-1].EndDate = d.AddDate(0, 0, 1)
+			continue
+		}
+		runs = append(runs, models.RoomRestriction{RoomID: rr.RoomID, StartDate: d, EndDate: d.AddDate(0, 0, 1)})
+	}
+	return runs
+}
+
+// AdminPostReservationsCalendar saves the owner blocks ticked and unticked on the calendar.
+// Existing blocks are posted as block_{room}_{date} while they stay ticked, and newly ticked
+// nights as add_block_{room}_{date}. Unticking some nights of a block, such as one that runs
+// into another month, only frees those nights.
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	first, ok := calendarMonth(r.Form.Get("y"), r.Form.Get("m"))
+	if !ok {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	next := first.AddDate(0, 1, 0)
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// free the unticked nights of owner blocks, splitting the blocks around them
+	lost := 0
+	for _, room := range rooms {
+		blocks, err := m.DB.GetBlocksForRoomByDate(room.ID, first, next)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		for _, rr := range blocks {
+			if rr.RestrictionID != models.RestrictionOwnerBlock {
+				continue
+			}
+
+			ticked := func(night time.Time) bool {
+				if night.Before(first) || !night.Before(next) {
+					// nights in other months aren't on the calendar, so they stay blocked
+					return true
+				}
+				return r.PostForm.Get(fmt.Sprintf("block_%d_%s", room.ID, night.Format("2006-01-02"))) != ""
+			}
+
+			kept := keptRuns(rr, ticked)
+			if len(kept) == 1 && kept[0].StartDate.Equal(rr.StartDate) && kept[0].EndDate.Equal(rr.EndDate) {
+				continue
+			}
+
+			err = m.DB.DeleteBlockByID(rr.ID)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			for _, run := range kept {
+				_, err = m.DB.InsertBlockForRoom(room.ID, models.RestrictionOwnerBlock, run.StartDate, run.EndDate)
+				if repository.IsRoomUnavailable(err) {
+					// someone booked the nights in the moment they were free
+					lost++
+					continue
+				}
+				if err != nil {
+					helpers.ServerError(w, err)
+					return
+				}
+			}
+		}
+	}
+
+	// add the newly ticked nights
+	refused := 0
+	for name := range r.PostForm {
+		if !strings.HasPrefix(name, "add_block_") {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimPrefix(name, "add_block_"), "_")
+		if len(parts) != 2 {
+			continue
+		}
+		roomID, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		night, err := time.Parse("2006-01-02", parts[1])
+		if err != nil {
+			continue
+		}
+
+		_, err = m.DB.InsertBlockForRoom(roomID, models.RestrictionOwnerBlock, night, night.AddDate(0, 0, 1))
+		if repository.IsRoomUnavailable(err) {
+			refused++
+			continue
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	switch {
+	case lost > 0:
+		m.App.Session.Put(r.Context(), "warning", "Some of the nights left ticked were booked while the changes were saved, please check the calendar")
+	case refused > 0:
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("%d night(s) could not be blocked because they are already taken", refused))
+	default:
+		m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", first.Format("2006"), first.Format("01")), http.StatusSeeOther)
+}
+
+// AdminOutbox lists the email that could not be sent, after every retry failed
+func (m *Repository) AdminOutbox(w http.ResponseWriter, r *http.Request) {
+	failed, err := m.DB.FailedOutboxMessages()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["failed"] = failed
+
+	render.Template(w, r, "admin-outbox.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRooms lists the rooms in the catalogue
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewRoom shows the form for adding a room
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "admin-rooms-new.page.tmpl", &models.TemplateData{
+		Form: forms.New(url.Values{"capacity": []string{"2"}}),
+	})
+}
+
+// roomForm is the form for adding a room to the catalogue
+type roomForm struct {
+	RoomName    string `form:"room_name" validate:"required"`
+	Slug        string `form:"slug" validate:"required,slug"`
+	Capacity    int    `form:"capacity" validate:"required,min=1"`
+	NightlyRate string `form:"nightly_rate" validate:"required"`
+	Description string `form:"description"`
+	Amenities   string `form:"amenities"`
+	Photos      string `form:"photos"`
+}
+
+// AdminPostNewRoom adds a room to the catalogue
+func (m *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	var posted roomForm
+	form, err := forms.Bind(r, &posted)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	nightlyRate, err := pricing.ParseAmount(posted.NightlyRate)
+	if err != nil && form.Has("nightly_rate") {
+		form.Errors.Add("nightly_rate", "Enter an amount in dollars, like 129.00")
+	}
+
+	showForm := func() {
+		render.Template(w, r, "admin-rooms-new.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+	}
+
+	if !form.Valid() {
+		showForm()
+		return
+	}
+
+	room := models.Room{
+		RoomName:    strings.TrimSpace(posted.RoomName),
+		Slug:        posted.Slug,
+		Description: strings.TrimSpace(posted.Description),
+		Capacity:    posted.Capacity,
+		Amenities:   lines(posted.Amenities),
+		Photos:      lines(posted.Photos),
+	}
+
+	_, err = m.DB.InsertRoom(room, nightlyRate)
+	if err == repository.ErrDuplicateSlug {
+		form.Errors.Add("slug", "Another room already uses this slug")
+		showForm()
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s added", room.RoomName))
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// lines splits a textarea into its non-blank lines
+func lines(s string) []string {
+	var result []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+// weekdayOption is an arrival weekday checkbox on the stay rules form
+type weekdayOption struct {
+	Value   int
+	Name    string
+	Checked bool
+}
+
+// weekdayOptions lists the days of the week, ticking those in the form's arrival_weekdays
+func weekdayOptions(form *forms.Form) []weekdayOption {
+	var options []weekdayOption
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		option := weekdayOption{Value: int(d), Name: d.String()}
+		for _, v := range form.Values["arrival_weekdays"] {
+			if v == strconv.Itoa(int(d)) {
+				option.Checked = true
+			}
+		}
+		options = append(options, option)
+	}
+	return options
+}
+
+// roomFromURL loads the room named by the {id} url parameter, writing a 404 if there isn't one
+func (m *Repository) roomFromURL(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomByID(id)
+	if err == sql.ErrNoRows {
+		helpers.ClientError(w, http.StatusNotFound)
+		return room, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return room, false
+	}
+	return room, true
+}
+
+// showStayRules renders the stay rules form for a room
+func (m *Repository) showStayRules(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["weekdays"] = weekdayOptions(form)
+
+	render.Template(w, r, "admin-rooms-rules.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminRoomRules shows the form for a room's stay rules
+func (m *Repository) AdminRoomRules(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	stayRules, err := m.DB.GetStayRulesForRoom(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	values := url.Values{}
+	values.Set("min_nights", strconv.Itoa(stayRules.MinNights))
+	values.Set("max_nights", strconv.Itoa(stayRules.MaxNights))
+	values.Set("min_lead_days", strconv.Itoa(stayRules.MinLeadDays))
+	values.Set("max_advance_days", strconv.Itoa(stayRules.MaxAdvanceDays))
+	for _, d := range stayRules.ArrivalWeekdays {
+		values.Add("arrival_weekdays", strconv.Itoa(int(d)))
+	}
+	var closed []string
+	for _, d := range stayRules.ClosedDates {
+		closed = append(closed, d.Format("2006-01-02"))
+	}
+	values.Set("closed_dates", strings.Join(closed, "\n"))
+
+	m.showStayRules(w, r, room, forms.New(values))
+}
+
+// stayRulesForm is the form for a room's stay rules. The arrival weekdays are checkboxes sharing a name,
+// so they are read from the form directly.
+type stayRulesForm struct {
+	MinNights      int    `form:"min_nights" validate:"required,min=1"`
+	MaxNights      int    `form:"max_nights" validate:"required,min=0"`
+	MinLeadDays    int    `form:"min_lead_days" validate:"required,min=0"`
+	MaxAdvanceDays int    `form:"max_advance_days" validate:"required,min=0"`
+	ClosedDates    string `form:"closed_dates"`
+}
+
+// AdminPostRoomRules saves a room's stay rules
+func (m *Repository) AdminPostRoomRules(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var posted stayRulesForm
+	form, err := forms.Bind(r, &posted)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stayRules := rules.StayRules{
+		RoomID:         room.ID,
+		MinNights:      posted.MinNights,
+		MaxNights:      posted.MaxNights,
+		MinLeadDays:    posted.MinLeadDays,
+		MaxAdvanceDays: posted.MaxAdvanceDays,
+	}
+	if stayRules.MaxNights > 0 && stayRules.MaxNights < stayRules.MinNights {
+		form.Errors.Add("max_nights", "The longest stay can't be shorter than the shortest")
+	}
+
+	for _, v := range form.Values["arrival_weekdays"] {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < int(time.Sunday) || d > int(time.Saturday) {
+			form.Errors.Add("arrival_weekdays", "Choose days of the week")
+			break
+		}
+		stayRules.ArrivalWeekdays = append(stayRules.ArrivalWeekdays, time.Weekday(d))
+	}
+
+	for _, line := range lines(posted.ClosedDates) {
+		d, err := time.Parse("2006-01-02", line)
+		if err != nil {
+			form.Errors.Add("closed_dates", fmt.Sprintf("%s is not a yyyy-mm-dd date", line))
+			break
+		}
+		stayRules.ClosedDates = append(stayRules.ClosedDates, d)
+	}
+
+	if !form.Valid() {
+		m.showStayRules(w, r, room, form)
+		return
+	}
+
+	err = m.DB.SetStayRules(stayRules)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Stay rules for %s saved", room.RoomName))
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
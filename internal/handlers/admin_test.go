@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
+	"github.com/tsawler/bookings-app/internal/rules"
+)
+
+// jan2060 is the first night of the stays booked by the admin tests, spread out so that they don't overlap
+var jan2060 = time.Date(2060, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// makeTestReservation books roomID for the guest jane@here.com at quote, returning the stored reservation
+func makeTestReservation(t *testing.T, repo *Repository, roomID int, start time.Time, nights int, quote models.Quote) models.Reservation {
+	id, err := repo.DB.CreateReservation(models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@here.com",
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, nights),
+		RoomID:    roomID,
+		Quote:     quote,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := repo.DB.GetReservationByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestRepository_AdminShowReservation(t *testing.T) {
+	id := strconv.Itoa(makeTestReservation(t, Repo, 2, jan2060, 2, models.Quote{}).ID)
+	failing := NewRepo(&app, dbrepo.NewMemoryRepo(&app, dbrepo.Failure{Method: "GetReservationByID"}))
+
+	var tests = []struct {
+		name         string
+		repo         *Repository
+		src          string
+		id           string
+		expectedCode int
+	}{
+		{"valid", Repo, "new", id, http.StatusOK},
+		{"unknown source", Repo, "elsewhere", id, http.StatusNotFound},
+		{"bad id", Repo, "all", "abc", http.StatusNotFound},
+		{"missing", Repo, "all", "99999", http.StatusNotFound},
+		{"database failure", failing, "all", id, http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/"+e.src+"/"+e.id, nil)
+		req = req.WithContext(getCtx(req))
+		req = withURLParams(req, map[string]string{"src": e.src, "id": e.id})
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(e.repo.AdminShowReservation).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: AdminShowReservation returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+	}
+}
+
+func TestRepository_AdminPostShowReservation(t *testing.T) {
+	booked := makeTestReservation(t, Repo, 2, jan2060.AddDate(0, 0, 10), 2, models.Quote{})
+	id := strconv.Itoa(booked.ID)
+
+	var tests = []struct {
+		name         string
+		id           string
+		email        string
+		phone        string
+		expectedCode int
+	}{
+		{"valid", id, "janet@here.com", "202-555-1234", http.StatusSeeOther},
+		{"invalid email", id, "janet", "202-555-1234", http.StatusOK},
+		{"invalid phone", id, "janet@here.com", "555-555-1234", http.StatusOK},
+		{"missing", "99999", "janet@here.com", "202-555-1234", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		values := url.Values{}
+		values.Add("first_name", "Janet")
+		values.Add("last_name", "Doe")
+		values.Add("email", e.email)
+		values.Add("phone", e.phone)
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id, strings.NewReader(values.Encode()))
+		req = req.WithContext(getCtx(req))
+		req = withURLParams(req, map[string]string{"src": "all", "id": e.id})
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostShowReservation).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: AdminPostShowReservation returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+	}
+
+	res, _ := Repo.DB.GetReservationByID(booked.ID)
+	if res.FirstName != "Janet" || res.Email != "janet@here.com" || res.Phone != "+12025551234" {
+		t.Errorf("expected guest details to be saved, got %s %s %s", res.FirstName, res.Email, res.Phone)
+	}
+}
+
+func TestRepository_AdminPostReservationStatus(t *testing.T) {
+	id := makeTestReservation(t, Repo, 2, jan2060.AddDate(0, 0, 20), 2, models.Quote{}).ID
+	params := map[string]string{"src": "new", "id": strconv.Itoa(id)}
+
+	move := func(params map[string]string, status string) *httptest.ResponseRecorder {
+		values := url.Values{}
+		values.Add("status", status)
+		req, _ := http.NewRequest("POST", "/admin/reservations/new/"+params["id"]+"/status", strings.NewReader(values.Encode()))
+		req = withURLParams(req.WithContext(getCtx(req)), params)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostReservationStatus).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := move(params, models.StatusConfirmed)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/reservations-new" {
+		t.Errorf("AdminPostReservationStatus should redirect to the new list, got %d to %s", rr.Code, rr.Header().Get("Location"))
+	}
+	res, _ := Repo.DB.GetReservationByID(id)
+	if res.Status != models.StatusConfirmed || res.ConfirmedAt.IsZero() {
+		t.Errorf("expected reservation to be confirmed, got %s at %s", res.Status, res.ConfirmedAt)
+	}
+	queued, err := Repo.DB.ClaimOutboxMessages(time.Now(), 100, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var confirmed bool
+	for _, msg := range queued {
+		if msg.Mail.Subject == "Your reservation is confirmed" && strings.Contains(msg.Mail.Content, res.Code) {
+			confirmed = true
+		}
+	}
+	if !confirmed {
+		t.Errorf("expected the guest to be told the reservation is confirmed, got %+v", queued)
+	}
+
+	// the guest can't check out before checking in
+	rr = move(params, models.StatusCheckedOut)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/reservations/new/"+params["id"] {
+		t.Errorf("expected a refused move to go back to the reservation, got %d to %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if res, _ := Repo.DB.GetReservationByID(id); res.Status != models.StatusConfirmed {
+		t.Errorf("expected a refused move to leave the reservation confirmed, got %s", res.Status)
+	}
+
+	// cancelled long before arrival, it is refunded in full
+	move(params, models.StatusCancelled)
+	res, _ = Repo.DB.GetReservationByID(id)
+	if !res.Cancelled() || res.Refund != res.Quote.Total {
+		t.Errorf("expected reservation to be cancelled with a full refund, got %s refunding %d", res.Status, res.Refund)
+	}
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(res.StartDate, res.EndDate, 2); !available {
+		t.Error("expected cancelling to free the room")
+	}
+
+	if rr := move(map[string]string{"src": "new", "id": "99999"}, models.StatusConfirmed); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing reservation, got %d", rr.Code)
+	}
+}
+
+func TestCalendarDay_BlockLabel(t *testing.T) {
+	var tests = []struct {
+		name     string
+		expected string
+	}{
+		{"Maintenance", "M"},
+		{"external booking", "E"},
+		{"Évènement", "É"},
+		{"", "?"},
+	}
+
+	for _, e := range tests {
+		day := calendarDay{BlockID: 1, Block: models.Restriction{RestrictionName: e.name}}
+		if label := day.BlockLabel(); label != e.expected {
+			t.Errorf("%q: expected %q but got %q", e.name, e.expected, label)
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationsCalendar(t *testing.T) {
+	// room 2 is reserved for the nights of March 1st and 2nd 2060
+	makeTestReservation(t, Repo, 2, jan2060.AddDate(0, 0, 60), 2, models.Quote{})
+	march := time.Date(2060, time.March, 1, 0, 0, 0, 0, time.UTC)
+	// and room 1 is closed for maintenance on the 20th, which the calendar must not undo
+	if _, err := Repo.DB.InsertBlockForRoom(1, models.RestrictionMaintenance, march.AddDate(0, 0, 19), march.AddDate(0, 0, 20)); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(values url.Values) *httptest.ResponseRecorder {
+		values.Set("y", "2060")
+		values.Set("m", "3")
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(values.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostReservationsCalendar).ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/reservations-calendar?y=2060&m=03" {
+			t.Fatalf("AdminPostReservationsCalendar should redirect back to the month, got %d to %s", rr.Code, rr.Header().Get("Location"))
+		}
+		return rr
+	}
+
+	values := url.Values{}
+	values.Add("add_block_1_2060-03-10", "1")
+	values.Add("add_block_2_2060-03-02", "1")
+	post(values)
+
+	blocks, _ := Repo.DB.GetBlocksForRoomByDate(1, march, march.AddDate(0, 1, 0))
+	if len(blocks) != 2 || blocks[0].RestrictionID != models.RestrictionOwnerBlock {
+		t.Fatalf("expected an owner block and a maintenance block on room 1, got %+v", blocks)
+	}
+	restrictions, _ := Repo.DB.GetRestrictionsForRoomByDate(2, march, march.AddDate(0, 1, 0))
+	if len(restrictions) != 1 || restrictions[0].ReservationID == 0 {
+		t.Errorf("expected the reserved night on room 2 to stay unblocked, got %+v", restrictions)
+	}
+
+	// posting without the block's box ticked removes it
+	post(url.Values{})
+	blocks, _ = Repo.DB.GetBlocksForRoomByDate(1, march, march.AddDate(0, 1, 0))
+	if len(blocks) != 1 || blocks[0].RestrictionID != models.RestrictionMaintenance {
+		t.Errorf("expected only the maintenance block to remain, got %+v", blocks)
+	}
+	restrictions, _ = Repo.DB.GetRestrictionsForRoomByDate(2, march, march.AddDate(0, 1, 0))
+	if len(restrictions) != 1 {
+		t.Error("expected the reservation to be left alone")
+	}
+
+	// a block from March 30th into April loses only the night unticked in March
+	if _, err := Repo.DB.InsertBlockForRoom(1, models.RestrictionOwnerBlock, march.AddDate(0, 0, 29), march.AddDate(0, 0, 33)); err != nil {
+		t.Fatal(err)
+	}
+	values = url.Values{}
+	values.Add("block_1_2060-03-30", "1")
+	post(values)
+
+	blocks, _ = Repo.DB.GetBlocksForRoomByDate(1, march.AddDate(0, 0, 29), march.AddDate(0, 0, 33))
+	var nights []string
+	for _, b := range blocks {
+		for d := b.StartDate; d.Before(b.EndDate); d = d.AddDate(0, 0, 1) {
+			nights = append(nights, d.Format("2006-01-02"))
+		}
+	}
+	if strings.Join(nights, ",") != "2060-03-30,2060-04-01,2060-04-02" {
+		t.Errorf("expected only the night of March 31st to be freed, got %v", nights)
+	}
+}
+
+func TestRepository_AdminPostNewRoom(t *testing.T) {
+	var tests = []struct {
+		name         string
+		roomName     string
+		slug         string
+		capacity     string
+		rate         string
+		expectedCode int
+	}{
+		{"valid", "Colonel's Cabin", "colonels-cabin", "3", "99.50", http.StatusSeeOther},
+		{"duplicate slug", "Another Suite", "majors-suite", "2", "100", http.StatusOK},
+		{"bad slug", "Bad Slug", "Bad Slug", "2", "100", http.StatusOK},
+		{"bad capacity", "No Beds", "no-beds", "0", "100", http.StatusOK},
+		{"bad rate", "Free Room", "free-room", "2", "free", http.StatusOK},
+		{"missing rate", "Free Room", "free-room", "2", "", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		values := url.Values{}
+		values.Add("room_name", e.roomName)
+		values.Add("slug", e.slug)
+		values.Add("capacity", e.capacity)
+		values.Add("nightly_rate", e.rate)
+		values.Add("description", "A cosy cabin by the dunes.")
+		values.Add("amenities", "Wood stove\n\nSea view\n")
+		values.Add("photos", "/static/images/outside.png")
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/new", strings.NewReader(values.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostNewRoom).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: AdminPostNewRoom returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+	}
+
+	room, err := Repo.DB.GetRoomBySlug("colonels-cabin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Capacity != 3 || len(room.Amenities) != 2 || len(room.Photos) != 1 {
+		t.Errorf("room was not saved as posted: %+v", room)
+	}
+	rate, err := Repo.DB.GetRoomRate(room.ID)
+	if err != nil || rate.NightlyRate != 9950 {
+		t.Errorf("expected the room's rate to be saved as 9950 cents, got %d (%v)", rate.NightlyRate, err)
+	}
+
+	// the new room gets a page without a deploy
+	req, _ := http.NewRequest("GET", "/rooms/colonels-cabin", nil)
+	req = withURLParams(req.WithContext(getCtx(req)), map[string]string{"slug": "colonels-cabin"})
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.Room).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Wood stove") {
+		t.Errorf("expected the new room's page to render, got %d", rr.Code)
+	}
+
+	// a room whose rate can't be saved isn't added either, so it can't be left without a price
+	failing := NewRepo(&app, dbrepo.NewMemoryRepo(&app, dbrepo.Failure{Method: "SetRoomRate"}))
+	values := url.Values{}
+	values.Add("room_name", "Priceless Room")
+	values.Add("slug", "priceless-room")
+	values.Add("capacity", "2")
+	values.Add("nightly_rate", "100")
+
+	req, _ = http.NewRequest("POST", "/admin/rooms/new", strings.NewReader(values.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	http.HandlerFunc(failing.AdminPostNewRoom).ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected a failed rate to be a server error, got %d", rr.Code)
+	}
+	if _, err := failing.DB.GetRoomBySlug("priceless-room"); err == nil {
+		t.Error("expected no room to be added without its rate")
+	}
+}
+
+func TestRepository_AdminPostRoomRules(t *testing.T) {
+	var tests = []struct {
+		name         string
+		roomID       string
+		minNights    string
+		maxNights    string
+		closedDates  string
+		expectedCode int
+	}{
+		{"valid", "2", "2", "14", "2050-12-24\n2050-12-25", http.StatusSeeOther},
+		{"missing room", "1000", "2", "14", "", http.StatusNotFound},
+		{"zero minimum", "2", "0", "14", "", http.StatusOK},
+		{"maximum below minimum", "2", "5", "3", "", http.StatusOK},
+		{"bad closed date", "2", "2", "14", "Christmas", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		values := url.Values{}
+		values.Add("min_nights", e.minNights)
+		values.Add("max_nights", e.maxNights)
+		values.Add("min_lead_days", "1")
+		values.Add("max_advance_days", "0")
+		values.Add("arrival_weekdays", "5")
+		values.Add("arrival_weekdays", "6")
+		values.Add("closed_dates", e.closedDates)
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.roomID+"/rules", strings.NewReader(values.Encode()))
+		req = withURLParams(req.WithContext(getCtx(req)), map[string]string{"id": e.roomID})
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostRoomRules).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: AdminPostRoomRules returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+	}
+
+	stayRules, err := Repo.DB.GetStayRulesForRoom(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stayRules.MinNights != 2 || stayRules.MaxNights != 14 || stayRules.MinLeadDays != 1 ||
+		len(stayRules.ArrivalWeekdays) != 2 || len(stayRules.ClosedDates) != 2 {
+		t.Errorf("rules were not saved as posted: %+v", stayRules)
+	}
+
+	// the saved rules fill in the form
+	req, _ := http.NewRequest("GET", "/admin/rooms/2/rules", nil)
+	req = withURLParams(req.WithContext(getCtx(req)), map[string]string{"id": "2"})
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.AdminRoomRules).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "2050-12-25") {
+		t.Errorf("expected the rules form to show the closed dates, got %d", rr.Code)
+	}
+
+	// put the defaults back for the other tests
+	if err := Repo.DB.SetStayRules(rules.Default(2)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepository_AdminOutbox(t *testing.T) {
+	repo := NewRepo(&app, dbrepo.NewMemoryRepo(&app))
+	_, err := repo.DB.CreateReservation(models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@here.com",
+		StartDate: time.Date(2060, time.March, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2060, time.March, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+	}, models.MailData{To: "jane@here.com", Subject: "Your reservation is confirmed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := repo.DB.ClaimOutboxMessages(time.Now(), 10, time.Minute)
+	if err != nil || len(queued) != 1 {
+		t.Fatalf("expected one queued message, got %d: %v", len(queued), err)
+	}
+	if err := repo.DB.FailOutboxMessage(queued[0].ID, "550 mailbox unavailable"); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/outbox", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(repo.AdminOutbox).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("AdminOutbox returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	for _, want := range []string{"jane@here.com", "Your reservation is confirmed", "550 mailbox unavailable"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected the failed email list to show %q", want)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/booking"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"github.com/tsawler/bookings-app/internal/rules"
+)
+
+// maxAPIBody is the largest request body the API reads
+const maxAPIBody = 1 << 20
+
+// apiRoom is a room as the API shows it
+type apiRoom struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Slug        string   `json:"slug"`
+	Description string   `json:"description"`
+	Capacity    int      `json:"capacity"`
+	Amenities   []string `json:"amenities"`
+	Photos      []string `json:"photos"`
+}
+
+// apiRooms is the list of rooms
+type apiRooms struct {
+	Rooms []apiRoom `json:"rooms"`
+}
+
+// apiQuote is the price of a stay. Amounts are in cents.
+type apiQuote struct {
+	Nights   int `json:"nights"`
+	Subtotal int `json:"subtotal"`
+	Fees     int `json:"fees"`
+	Taxes    int `json:"taxes"`
+	Total    int `json:"total"`
+}
+
+// apiAvailability answers whether a room can be booked for some dates, and what the stay costs if it can
+type apiAvailability struct {
+	RoomID    int       `json:"room_id"`
+	StartDate string    `json:"start_date" format:"date"`
+	EndDate   string    `json:"end_date" format:"date"`
+	Available bool      `json:"available"`
+	Message   string    `json:"message"`
+	Quote     *apiQuote `json:"quote,omitempty"`
+}
+
+// apiReservationRequest is the body partners book a room with. The fields are checked just as the
+// reservation form's are.
+type apiReservationRequest struct {
+	RoomID    int    `json:"room_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email" format:"email"`
+	Phone     string `json:"phone,omitempty"`
+	StartDate string `json:"start_date" format:"date"`
+	EndDate   string `json:"end_date" format:"date"`
+}
+
+// apiReservation is a reservation as the API shows it
+type apiReservation struct {
+	Code      string   `json:"code"`
+	RoomID    int      `json:"room_id"`
+	RoomName  string   `json:"room_name"`
+	FirstName string   `json:"first_name"`
+	LastName  string   `json:"last_name"`
+	Email     string   `json:"email" format:"email"`
+	Phone     string   `json:"phone"`
+	StartDate string   `json:"start_date" format:"date"`
+	EndDate   string   `json:"end_date" format:"date"`
+	Quote     apiQuote `json:"quote"`
+	Status    string   `json:"status"`
+	// Refund is what a cancelled reservation is owed back, in cents
+	Refund int `json:"refund"`
+}
+
+// apiStatusRequest is the body partners move a reservation to another status with
+type apiStatusRequest struct {
+	Status string `json:"status"`
+}
+
+func newAPIRoom(room models.Room) apiRoom {
+	r := apiRoom{
+		ID:          room.ID,
+		Name:        room.RoomName,
+		Slug:        room.Slug,
+		Description: room.Description,
+		Capacity:    room.Capacity,
+		Amenities:   room.Amenities,
+		Photos:      room.Photos,
+	}
+	// lists are always arrays in the JSON, never null
+	if r.Amenities == nil {
+		r.Amenities = []string{}
+	}
+	if r.Photos == nil {
+		r.Photos = []string{}
+	}
+	return r
+}
+
+func newAPIQuote(q models.Quote) apiQuote {
+	return apiQuote{
+		Nights:   q.Nights,
+		Subtotal: q.Subtotal,
+		Fees:     q.Fees,
+		Taxes:    q.Taxes,
+		Total:    q.Total,
+	}
+}
+
+func newAPIReservation(res models.Reservation) apiReservation {
+	return apiReservation{
+		Code:      res.Code,
+		RoomID:    res.RoomID,
+		RoomName:  res.Room.RoomName,
+		FirstName: res.FirstName,
+		LastName:  res.LastName,
+		Email:     res.Email,
+		Phone:     res.Phone,
+		StartDate: res.StartDate.Format(forms.DateLayout),
+		EndDate:   res.EndDate.Format(forms.DateLayout),
+		Quote:     newAPIQuote(res.Quote),
+		Status:    res.Status,
+		Refund:    res.Refund,
+	}
+}
+
+// fieldErrors returns the first error of each of the form's fields, keyed by field
+func fieldErrors(form *forms.Form) map[string]string {
+	fields := make(map[string]string)
+	for field := range form.Errors {
+		fields[field] = form.Errors.Get(field)
+	}
+	return fields
+}
+
+// apiInvalid sends the form's errors as a 422
+func apiInvalid(w http.ResponseWriter, form *forms.Form) {
+	helpers.APIError(w, http.StatusUnprocessableEntity, "invalid_request", "Some fields are missing or invalid", fieldErrors(form))
+}
+
+// APINotFound answers requests for API routes that don't exist
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	helpers.APIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("There is no API route %s", r.URL.Path), nil)
+}
+
+// APIMethodNotAllowed answers requests to API routes with a method they don't support
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	helpers.APIError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Sprintf("%s is not supported on %s", r.Method, r.URL.Path), nil)
+}
+
+// APIRooms lists the rooms
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.APIServerError(w, err)
+		return
+	}
+
+	resp := apiRooms{Rooms: []apiRoom{}}
+	for _, room := range rooms {
+		resp.Rooms = append(resp.Rooms, newAPIRoom(room))
+	}
+
+	writeJSONStatus(w, http.StatusOK, resp)
+}
+
+// APIRoomAvailability reports whether the room in the {id} url parameter can be booked from the start
+// date up to the end date in the query string, and quotes the stay if it can
+func (m *Repository) APIRoomAvailability(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.apiRoom(w, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	form := dateRangeForm(r.URL.Query(), "start", "end")
+	if !form.Valid() {
+		apiInvalid(w, form)
+		return
+	}
+	start, end := form.Date("start"), form.Date("end")
+
+	resp := apiAvailability{
+		RoomID:    room.ID,
+		StartDate: start.Format(forms.DateLayout),
+		EndDate:   end.Format(forms.DateLayout),
+	}
+
+	stayRules, err := m.DB.GetStayRulesForRoom(room.ID)
+	if err != nil {
+		helpers.APIServerError(w, err)
+		return
+	}
+	if v := stayRules.Check(start, end, rules.Today()); len(v) > 0 {
+		resp.Message = v[0].Message
+		writeJSONStatus(w, http.StatusOK, resp)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(start, end, room.ID)
+	if err != nil {
+		helpers.APIServerError(w, err)
+		return
+	}
+	if !available {
+		resp.Message = "Not available for those dates"
+		writeJSONStatus(w, http.StatusOK, resp)
+		return
+	}
+
+	quote, err := pricing.Quote(m.DB, m.App.Pricing, room.ID, start, end)
+	if errors.Is(err, pricing.ErrNoRate) {
+		// just as on the search page, a room without a rate can't be booked
+		m.App.ErrorLog.Println(err)
+		resp.Message = "Not available for those dates"
+		writeJSONStatus(w, http.StatusOK, resp)
+		return
+	}
+	if err != nil {
+		helpers.APIServerError(w, err)
+		return
+	}
+
+	q := newAPIQuote(quote)
+	resp.Available = true
+	resp.Message = "Available!"
+	resp.Quote = &q
+	writeJSONStatus(w, http.StatusOK, resp)
+}
+
+// apiRoom looks up the room with the id, sending a 404 if there isn't one
+func (m *Repository) apiRoom(w http.ResponseWriter, id string) (models.Room, bool) {
+	roomID, err := strconv.Atoi(id)
+	if err != nil {
+		helpers.APIError(w, http.StatusNotFound, "not_found", "No room has that id", nil)
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomByID(roomID)
+	if err == sql.ErrNoRows {
+		helpers.APIError(w, http.StatusNotFound, "not_found", "No room has that id", nil)
+		return room, false
+	}
+	if err != nil {
+		helpers.APIServerError(w, err)
+		return room, false
+	}
+
+	return room, true
+}
+
+// APIPostReservation books a room from a JSON apiReservationRequest, checking it just as the reservation
+// form is checked. The new reservation is sent back, with its confirmation code.
+func (m *Repository) APIPostReservation(w http.ResponseWriter, r *http.Request) {
+	var posted apiReservationRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody)).Decode(&posted)
+	if err != nil {
+		helpers.APIError(w, http.StatusBadRequest, "bad_request", "The body must be a JSON reservation", nil)
+		return
+	}
+
+	form := forms.New(url.Values{
+		"first_name": {posted.FirstName},
+		"last_name":  {posted.LastName},
+		"email":      {posted.Email},
+		"phone":      {posted.Phone},
+		"start_date": {posted.StartDate},
+		"end_date":   {posted.EndDate},
+	})
+
+	room, err := m.DB.GetRoomByID(posted.RoomID)
+	if err == sql.ErrNoRows {
+		form.Errors.Add("room_id", "No room has that id")
+		apiInvalid(w, form)
+		return
+	}
+	if err != nil {
+		helpers.APIServerError(w, err)
+		return
+	}
+
+	reservation, err := m.checkReservation(form, room.ID)
+	if err != nil {
+		helpers.APIServerError(w, err)
+		return
+	}
+	reservation.Room = room
+	reservation.Partner = helpers.APIPartner(r)
+
+	if !form.Valid() {
+		apiInvalid(w, form)
+		return
+	}
+
+	reservation, err = m.bookReservation(form, reservation)
+	if repository.IsRoomUnavailable(err) {
+		helpers.APIError(w, http.StatusConflict, "room_unavailable", "The room is not available for those dates", nil)
+		return
+	}
+	if err != nil {
+		helpers.APIServerError(w, err)
+		return
+	}
+	if !form.Valid() {
+		apiInvalid(w, form)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/reservations/"+reservation.Code)
+	writeJSONStatus(w, http.StatusCreated, newAPIReservation(reservation))
+}
+
+// APIReservation shows the reservation with the confirmation code in the {code} url parameter
+func (m *Repository) APIReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservation(w, chi.URLParam(r, "code"))
+	if !ok {
+		return
+	}
+
+	writeJSONStatus(w, http.StatusOK, newAPIReservation(res))
+}
+
+// apiReservation looks up the reservation with the confirmation code, in any case, sending a 404 if
+// there isn't one
+func (m *Repository) apiReservation(w http.ResponseWriter, code string) (models.Reservation, bool) {
+	res, err := m.DB.GetReservationByCode(strings.ToUpper(strings.TrimSpace(code)))
+	if err == sql.ErrNoRows {
+		helpers.APIError(w, http.StatusNotFound, "not_found", "No reservation has that code", nil)
+		return res, false
+	}
+	if err != nil {
+		helpers.APIServerError(w, err)
+		return res, false
+	}
+
+	return res, true
+}
+
+// APIPostReservationStatus moves the reservation with the confirmation code in the {code} url parameter
+// to the status in a JSON apiStatusRequest, and sends it back. Partners can only cancel the reservations
+// they booked; confirming them and checking guests in and out is left to staff.
+func (m *Repository) APIPostReservationStatus(w http.ResponseWriter, r *http.Request) {
+	var posted apiStatusRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody)).Decode(&posted)
+	if err != nil {
+		helpers.APIError(w, http.StatusBadRequest, "bad_request", "The body must be a JSON status", nil)
+		return
+	}
+
+	res, ok := m.apiReservation(w, chi.URLParam(r, "code"))
+	if !ok {
+		return
+	}
+
+	if !booking.IsStatus(posted.Status) {
+		form := forms.New(nil)
+		form.Errors.Add("status", fmt.Sprintf("Must be one of %s", strings.Join(models.Statuses, ", ")))
+		apiInvalid(w, form)
+		return
+	}
+	if res.Partner == "" || res.Partner != helpers.APIPartner(r) {
+		helpers.APIError(w, http.StatusForbidden, "forbidden", "Only the partner site that booked the reservation can change it", nil)
+		return
+	}
+	if posted.Status != models.StatusCancelled {
+		helpers.APIError(w, http.StatusForbidden, "forbidden", "Partner sites can only cancel reservations", nil)
+		return
+	}
+
+	res, err = m.bookings().Transition(res.ID, posted.Status, time.Now(), m.statusMail("a partner site"))
+	var refused *booking.TransitionError
+	if errors.As(err, &refused) {
+		helpers.APIError(w, http.StatusConflict, "invalid_transition", fmt.Sprintf("The reservation can't be marked %s: %s", posted.Status, refused.Reason), nil)
+		return
+	}
+	if err != nil {
+		helpers.APIServerError(w, err)
+		return
+	}
+
+	writeJSONStatus(w, http.StatusOK, newAPIReservation(res))
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
+)
+
+// apiCall sends a request to the test server's API as partner-a and returns the response with its body
+func apiCall(t *testing.T, ts *httptest.Server, method, path, body string) (*http.Response, []byte) {
+	return apiCallAs(t, ts, "partner-a", method, path, body)
+}
+
+// apiCallAs makes an API request as partner
+func apiCallAs(t *testing.T, ts *httptest.Server, partner, method, path, body string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Partner", partner)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, b
+}
+
+// apiErrorOf parses an API error body, failing the test if it isn't one
+func apiErrorOf(t *testing.T, name string, body []byte) helpers.APIErrorDetail {
+	var e helpers.APIErrorBody
+	if err := json.Unmarshal(body, &e); err != nil || e.Error.Code == "" {
+		t.Fatalf("%s: expected a JSON error, got %s", name, body)
+	}
+	return e.Error
+}
+
+func TestAPI_Rooms(t *testing.T) {
+	ts := httptest.NewTLSServer(getRoutes())
+	defer ts.Close()
+
+	resp, body := apiCall(t, ts, "GET", "/api/v1/rooms", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var rooms apiRooms
+	if err := json.Unmarshal(body, &rooms); err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms.Rooms) != 2 || rooms.Rooms[1].Slug != "majors-suite" {
+		t.Errorf("expected the two rooms, got %+v", rooms.Rooms)
+	}
+	if strings.Contains(string(body), "token") {
+		t.Error("the rooms' calendar tokens must not be shown")
+	}
+
+	rr := httptest.NewRecorder()
+	failing := NewRepo(&app, dbrepo.NewMemoryRepo(&app, dbrepo.Failure{Method: "AllRooms"}))
+	http.HandlerFunc(failing.APIRooms).ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/rooms", nil))
+	if rr.Code != http.StatusInternalServerError || apiErrorOf(t, "database failure", rr.Body.Bytes()).Code != "server_error" {
+		t.Errorf("expected a JSON 500 when the rooms can't be read, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAPI_RoomAvailability(t *testing.T) {
+	ts := httptest.NewTLSServer(getRoutes())
+	defer ts.Close()
+
+	var tests = []struct {
+		name         string
+		path         string
+		expectedCode int
+		available    bool
+		errorField   string
+	}{
+		{"available", "/api/v1/rooms/2/availability?start=2050-05-01&end=2050-05-03", http.StatusOK, true, ""},
+		{"bad date", "/api/v1/rooms/2/availability?start=soon&end=2050-05-03", http.StatusUnprocessableEntity, false, "start"},
+		{"arrival in the past", "/api/v1/rooms/2/availability?start=2000-05-01&end=2050-05-03", http.StatusUnprocessableEntity, false, "start"},
+		{"missing room", "/api/v1/rooms/1000/availability?start=2050-05-01&end=2050-05-03", http.StatusNotFound, false, ""},
+		{"room id not a number", "/api/v1/rooms/two/availability?start=2050-05-01&end=2050-05-03", http.StatusNotFound, false, ""},
+	}
+
+	for _, e := range tests {
+		resp, body := apiCall(t, ts, "GET", e.path, "")
+		if resp.StatusCode != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, resp.StatusCode)
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			apiErr := apiErrorOf(t, e.name, body)
+			if e.errorField != "" && apiErr.Fields[e.errorField] == "" {
+				t.Errorf("%s: expected an error on %s, got %+v", e.name, e.errorField, apiErr)
+			}
+			continue
+		}
+
+		var a apiAvailability
+		if err := json.Unmarshal(body, &a); err != nil {
+			t.Fatal(err)
+		}
+		if a.Available != e.available {
+			t.Errorf("%s: expected available to be %t, got %+v", e.name, e.available, a)
+		}
+		// 2 nights at $129, the $25 fee and 10% tax
+		if a.Quote == nil || a.Quote.Nights != 2 || a.Quote.Total != 31130 {
+			t.Errorf("%s: expected a quote for the stay, got %+v", e.name, a.Quote)
+		}
+	}
+}
+
+func TestAPI_Reservations(t *testing.T) {
+	ts := httptest.NewTLSServer(getRoutes())
+	defer ts.Close()
+
+	valid := `{"room_id": 2, "first_name": "Jane", "last_name": "Doe", "email": "jane@there.com",
+		"phone": "(202) 555-0143", "start_date": "2050-06-01", "end_date": "2050-06-04"}`
+
+	resp, body := apiCall(t, ts, "POST", "/api/v1/reservations", valid)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", resp.StatusCode, body)
+	}
+
+	var booked apiReservation
+	if err := json.Unmarshal(body, &booked); err != nil {
+		t.Fatal(err)
+	}
+	if len(booked.Code) != 8 || booked.RoomName != "Major's Suite" || booked.Phone != "+12025550143" || booked.Quote.Nights != 3 {
+		t.Errorf("unexpected reservation %+v", booked)
+	}
+	if resp.Header.Get("Location") != "/api/v1/reservations/"+booked.Code {
+		t.Errorf("expected the new reservation's location, got %q", resp.Header.Get("Location"))
+	}
+
+	// codes are read without regard to case
+	resp, body = apiCall(t, ts, "GET", "/api/v1/reservations/"+strings.ToLower(booked.Code), "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected to find the reservation, got %d", resp.StatusCode)
+	}
+	var found apiReservation
+	if err := json.Unmarshal(body, &found); err != nil {
+		t.Fatal(err)
+	}
+	if found != booked {
+		t.Errorf("expected %+v, got %+v", booked, found)
+	}
+
+	var tests = []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		expectedCode int
+		errorCode    string
+		errorField   string
+	}{
+		{"room taken", "POST", "/api/v1/reservations", valid, http.StatusConflict, "room_unavailable", ""},
+		{"bad email", "POST", "/api/v1/reservations", strings.Replace(valid, "jane@there.com", "jane", 1), http.StatusUnprocessableEntity, "invalid_request", "email"},
+		{"bad phone", "POST", "/api/v1/reservations", strings.Replace(valid, "(202) 555-0143", "555", 1), http.StatusUnprocessableEntity, "invalid_request", "phone"},
+		{"departure before arrival", "POST", "/api/v1/reservations", strings.Replace(valid, "2050-06-04", "2050-05-04", 1), http.StatusUnprocessableEntity, "invalid_request", "end_date"},
+		{"missing room", "POST", "/api/v1/reservations", strings.Replace(valid, `"room_id": 2`, `"room_id": 1000`, 1), http.StatusUnprocessableEntity, "invalid_request", "room_id"},
+		{"not json", "POST", "/api/v1/reservations", "first_name=Jane", http.StatusBadRequest, "bad_request", ""},
+		{"unknown code", "GET", "/api/v1/reservations/NOTACODE", "", http.StatusNotFound, "not_found", ""},
+		{"unknown route", "GET", "/api/v1/guests", "", http.StatusNotFound, "not_found", ""},
+		{"wrong method", "DELETE", "/api/v1/reservations", "", http.StatusMethodNotAllowed, "method_not_allowed", ""},
+	}
+
+	for _, e := range tests {
+		resp, body := apiCall(t, ts, e.method, e.path, e.body)
+		if resp.StatusCode != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, resp.StatusCode)
+			continue
+		}
+
+		apiErr := apiErrorOf(t, e.name, body)
+		if apiErr.Code != e.errorCode {
+			t.Errorf("%s: expected the error %s, got %s", e.name, e.errorCode, apiErr.Code)
+		}
+		if e.errorField != "" && apiErr.Fields[e.errorField] == "" {
+			t.Errorf("%s: expected an error on %s, got %+v", e.name, e.errorField, apiErr)
+		}
+	}
+}
+
+func TestAPI_ReservationStatus(t *testing.T) {
+	ts := httptest.NewTLSServer(getRoutes())
+	defer ts.Close()
+
+	resp, body := apiCall(t, ts, "POST", "/api/v1/reservations", `{"room_id": 2, "first_name": "Jane", "last_name": "Doe",
+		"email": "jane@here.com", "start_date": "2050-09-01", "end_date": "2050-09-04"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", resp.StatusCode, body)
+	}
+	var res apiReservation
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/v1/reservations/" + res.Code + "/status"
+
+	// a reservation made on our own site belongs to no partner
+	ours := makeTestReservation(t, Repo, 2, time.Date(2050, time.September, 10, 0, 0, 0, 0, time.UTC), 2, perNight(2))
+
+	var tests = []struct {
+		name         string
+		partner      string
+		path         string
+		body         string
+		expectedCode int
+		status       string
+		errorCode    string
+	}{
+		{"confirm", "partner-a", path, `{"status": "confirmed"}`, http.StatusForbidden, "", "forbidden"},
+		{"check in", "partner-a", path, `{"status": "checked-in"}`, http.StatusForbidden, "", "forbidden"},
+		{"no-show", "partner-a", path, `{"status": "no-show"}`, http.StatusForbidden, "", "forbidden"},
+		{"unknown status", "partner-a", path, `{"status": "approved"}`, http.StatusUnprocessableEntity, "", "invalid_request"},
+		{"not json", "partner-a", path, "status=cancelled", http.StatusBadRequest, "", "bad_request"},
+		{"unknown code", "partner-a", "/api/v1/reservations/NOTACODE/status", `{"status": "cancelled"}`, http.StatusNotFound, "", "not_found"},
+		{"another partner's booking", "partner-b", path, `{"status": "cancelled"}`, http.StatusForbidden, "", "forbidden"},
+		{"our own booking", "partner-a", "/api/v1/reservations/" + ours.Code + "/status", `{"status": "cancelled"}`, http.StatusForbidden, "", "forbidden"},
+		{"cancel", "partner-a", strings.ToLower(path), `{"status": "cancelled"}`, http.StatusOK, models.StatusCancelled, ""},
+		{"cancel twice", "partner-a", path, `{"status": "cancelled"}`, http.StatusConflict, "", "invalid_transition"},
+	}
+
+	for _, e := range tests {
+		resp, body := apiCallAs(t, ts, e.partner, "POST", e.path, e.body)
+		if resp.StatusCode != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d: %s", e.name, e.expectedCode, resp.StatusCode, body)
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			if apiErr := apiErrorOf(t, e.name, body); apiErr.Code != e.errorCode {
+				t.Errorf("%s: expected the error %s, got %s", e.name, e.errorCode, apiErr.Code)
+			}
+			continue
+		}
+
+		var moved apiReservation
+		if err := json.Unmarshal(body, &moved); err != nil {
+			t.Fatal(err)
+		}
+		if moved.Code != res.Code || moved.Status != e.status {
+			t.Errorf("%s: expected the reservation to be %s, got %+v", e.name, e.status, moved)
+		}
+		// cancelled months ahead, the stay is refunded in full
+		if moved.Refund != res.Quote.Total {
+			t.Errorf("%s: expected a refund of %d, got %d", e.name, res.Quote.Total, moved.Refund)
+		}
+	}
+
+	if mine, _ := Repo.DB.GetReservationByID(ours.ID); mine.Status != models.StatusPending {
+		t.Errorf("expected a partner not to move our own booking, got %s", mine.Status)
+	}
+
+	queued, err := Repo.DB.ClaimOutboxMessages(time.Now(), 100, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notices int
+	for _, msg := range queued {
+		if msg.Mail.To == app.OwnerEmail && strings.HasSuffix(msg.Mail.Subject, "by a partner site") {
+			notices++
+		}
+	}
+	if notices != 1 {
+		t.Errorf("expected the owner to be told of the cancellation only, got %+v", queued)
+	}
+}
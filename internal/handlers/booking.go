@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/booking"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
+	"github.com/tsawler/bookings-app/internal/render"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"github.com/tsawler/bookings-app/internal/rules"
+)
+
+// bookingLookupForm is the form guests find their booking with
+type bookingLookupForm struct {
+	Code  string `form:"code" validate:"required"`
+	Email string `form:"email" validate:"required,email"`
+}
+
+// bookingDatesForm is the form guests move their stay with
+type bookingDatesForm struct {
+	StartDate time.Time `form:"start_date" validate:"required,notpast"`
+	EndDate   time.Time `form:"end_date" validate:"required,after=start_date"`
+}
+
+// ManageBooking shows the form guests find their booking with
+func (m *Repository) ManageBooking(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "manage-booking.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostManageBooking finds the booking with the posted confirmation code and email, and remembers it in
+// the session so the guest can see and change it
+func (m *Repository) PostManageBooking(w http.ResponseWriter, r *http.Request) {
+	var lookup bookingLookupForm
+	form, err := forms.Bind(r, &lookup)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if form.Valid() {
+		res, err := m.DB.GetReservationByCode(strings.ToUpper(strings.TrimSpace(lookup.Code)))
+		if err != nil && err != sql.ErrNoRows {
+			helpers.ServerError(w, err)
+			return
+		}
+		// a wrong code and a wrong email look the same, so codes can't be checked without the email
+		if err == sql.ErrNoRows || !strings.EqualFold(res.Email, strings.TrimSpace(lookup.Email)) {
+			form.Errors.Add("code", "We couldn't find a booking with that code and email")
+		} else {
+			m.App.Session.Put(r.Context(), "booking_code", res.Code)
+			http.Redirect(w, r, "/manage-booking/details", http.StatusSeeOther)
+			return
+		}
+	}
+
+	render.Template(w, r, "manage-booking.page.tmpl", &models.TemplateData{
+		Form: form,
+	})
+}
+
+// managedBooking returns the booking the guest looked up. Without one, the guest is sent to look it up
+// and ok is false.
+func (m *Repository) managedBooking(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	code := m.App.Session.GetString(r.Context(), "booking_code")
+	if code == "" {
+		m.App.Session.Put(r.Context(), "error", "Please look up your booking first")
+		http.Redirect(w, r, "/manage-booking", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByCode(code)
+	if err == sql.ErrNoRows {
+		m.App.Session.Remove(r.Context(), "booking_code")
+		m.App.Session.Put(r.Context(), "error", "We couldn't find your booking, please look it up again")
+		http.Redirect(w, r, "/manage-booking", http.StatusSeeOther)
+		return res, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+
+	return res, true
+}
+
+// bookings returns the service that moves reservations between statuses
+func (m *Repository) bookings() *booking.Service {
+	return booking.NewService(m.DB, m.App.Cancellation)
+}
+
+// canCancelBooking reports whether guests can still cancel res online, which they can until the day
+// they arrive
+func canCancelBooking(res models.Reservation) bool {
+	return booking.Open(res.Status) && rules.Today().Before(res.StartDate)
+}
+
+// ManageBookingDetails shows the booking the guest looked up, with forms to move or cancel it
+func (m *Repository) ManageBookingDetails(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.managedBooking(w, r)
+	if !ok {
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format(forms.DateLayout)
+	stringMap["end_date"] = res.EndDate.Format(forms.DateLayout)
+
+	m.bookingDetails(w, r, res, forms.New(nil), stringMap)
+}
+
+// bookingDetails renders the manage booking page for res, with the change of dates form filled in from stringMap
+func (m *Repository) bookingDetails(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form, stringMap map[string]string) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["deadline"] = m.App.Cancellation.Deadline(res)
+	data["changeable"] = m.App.Cancellation.CanChange(res, rules.Today())
+	data["cancellable"] = canCancelBooking(res)
+	data["refund"] = m.App.Cancellation.Refund(res, rules.Today())
+
+	render.Template(w, r, "manage-booking-details.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// PostManageBookingDates moves the guest's booking to the posted dates, if the room is free for them and
+// they keep to the room's stay rules. The stay is priced again for the new dates.
+func (m *Repository) PostManageBookingDates(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.managedBooking(w, r)
+	if !ok {
+		return
+	}
+	if !m.App.Cancellation.CanChange(res, rules.Today()) {
+		m.App.Session.Put(r.Context(), "error", "This booking can no longer be changed online, please contact us")
+		http.Redirect(w, r, "/manage-booking/details", http.StatusSeeOther)
+		return
+	}
+
+	var dates bookingDatesForm
+	form, err := forms.Bind(r, &dates)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = form.Get("start_date")
+	stringMap["end_date"] = form.Get("end_date")
+
+	// the room's own rules are only worth checking once the dates make sense
+	if form.Valid() {
+		stayRules, err := m.DB.GetStayRulesForRoom(res.RoomID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		form.StayDates("start_date", "end_date", stayRules, rules.Today())
+	}
+	if !form.Valid() {
+		m.bookingDetails(w, r, res, form, stringMap)
+		return
+	}
+
+	changed := res
+	changed.StartDate = dates.StartDate
+	changed.EndDate = dates.EndDate
+	changed.Quote, err = pricing.Quote(m.DB, m.App.Pricing, res.RoomID, dates.StartDate, dates.EndDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	guest, err := m.guestMail(changed, "reservation-changed", "Your reservation has been changed")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	notice, err := m.changeNotice(changed, "changed", "the guest")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ChangeReservationDates(changed, guest, notice)
+	if repository.IsRoomUnavailable(err) {
+		form.Errors.Add("start_date", "Sorry, the room is not available for those dates")
+		m.bookingDetails(w, r, res, form, stringMap)
+		return
+	}
+	if addRuleErrors(form, err) {
+		m.bookingDetails(w, r, res, form, stringMap)
+		return
+	}
+	if err == sql.ErrNoRows {
+		// cancelled or checked in since the page was shown
+		m.App.Session.Put(r.Context(), "error", "This booking can no longer be changed online, please contact us")
+		http.Redirect(w, r, "/manage-booking/details", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your booking has been changed")
+	http.Redirect(w, r, "/manage-booking/details", http.StatusSeeOther)
+}
+
+// PostManageBookingCancel cancels the guest's booking, freeing the room for its dates. The guest is
+// refunded as much of the booking as the cancellation policy allows.
+func (m *Repository) PostManageBookingCancel(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.managedBooking(w, r)
+	if !ok {
+		return
+	}
+	if !canCancelBooking(res) {
+		m.App.Session.Put(r.Context(), "error", "This booking can no longer be cancelled online, please contact us")
+		http.Redirect(w, r, "/manage-booking/details", http.StatusSeeOther)
+		return
+	}
+
+	_, err := m.bookings().Transition(res.ID, models.StatusCancelled, time.Now(), m.statusMail("the guest"))
+	var refused *booking.TransitionError
+	if errors.As(err, &refused) {
+		m.App.Session.Put(r.Context(), "error", "This booking can no longer be cancelled online, please contact us")
+		http.Redirect(w, r, "/manage-booking/details", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your booking has been cancelled")
+	http.Redirect(w, r, "/manage-booking/details", http.StatusSeeOther)
+}
+
+// guestStatusMail are the email templates and subjects guests are sent when their reservation moves to
+// a status
+var guestStatusMail = map[string]struct{ name, subject string }{
+	models.StatusConfirmed: {"reservation-confirmed", "Your reservation is confirmed"},
+	models.StatusCancelled: {"reservation-cancelled", "Your reservation has been cancelled"},
+}
+
+// statusMail renders the mail about a reservation's move made by someone, such as "the guest". Guests
+// are told when their reservation is confirmed or cancelled, and the owner is told of every move staff
+// didn't make.
+func (m *Repository) statusMail(by string) booking.MailFunc {
+	return func(res models.Reservation) ([]models.MailData, error) {
+		var mail []models.MailData
+
+		if tmpl, ok := guestStatusMail[res.Status]; ok {
+			guest, err := m.guestMail(res, tmpl.name, tmpl.subject)
+			if err != nil {
+				return nil, err
+			}
+			mail = append(mail, guest)
+		}
+
+		if by != "" {
+			notice, err := m.changeNotice(res, "marked "+strings.ToLower(res.StatusName()), by)
+			if err != nil {
+				return nil, err
+			}
+			mail = append(mail, notice)
+		}
+
+		return mail, nil
+	}
+}
+
+// guestMail renders the email template name about res to its guest
+func (m *Repository) guestMail(res models.Reservation, name, subject string) (models.MailData, error) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	content, err := render.Email(name, data)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	return models.MailData{
+		To:      res.Email,
+		Subject: subject,
+		Content: content,
+	}, nil
+}
+
+// changeNotice renders the owner's notice that someone, such as "the guest", made a change to res, such
+// as "changed"
+func (m *Repository) changeNotice(res models.Reservation, change, by string) (models.MailData, error) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["change"] = change
+	data["by"] = by
+
+	content, err := render.Email("reservation-change-notice", data)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	return models.MailData{
+		To:      m.App.OwnerEmail,
+		Subject: fmt.Sprintf("Reservation %s %s by %s", res.Code, change, by),
+		Content: content,
+	}, nil
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
+	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
+	"github.com/tsawler/bookings-app/internal/rules"
+)
+
+// perNight is the quote for a stay of nights at $100 a night
+func perNight(nights int) models.Quote {
+	return models.Quote{Nights: nights, Subtotal: nights * 10000, Total: nights * 10000}
+}
+
+// manageBooking calls handler with values posted, or with no body if values is nil, for a guest who has
+// looked up the booking with code, if any
+func manageBooking(handler http.HandlerFunc, code string, values url.Values) (*httptest.ResponseRecorder, context.Context) {
+	method, body := "GET", ""
+	if values != nil {
+		method, body = "POST", values.Encode()
+	}
+
+	req, _ := http.NewRequest(method, "/manage-booking", strings.NewReader(body))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if code != "" {
+		session.Put(ctx, "booking_code", code)
+	}
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+	return rr, ctx
+}
+
+func TestRepository_PostManageBooking(t *testing.T) {
+	repo := NewRepo(&app, dbrepo.NewMemoryRepo(&app))
+	res := makeTestReservation(t, repo, 1, time.Date(2050, time.July, 1, 0, 0, 0, 0, time.UTC), 2, perNight(2))
+
+	var tests = []struct {
+		name         string
+		repo         *Repository
+		code         string
+		email        string
+		expectedCode int
+	}{
+		{"found", repo, res.Code, "jane@here.com", http.StatusSeeOther},
+		{"code and email in any case", repo, " " + strings.ToLower(res.Code), "Jane@Here.com", http.StatusSeeOther},
+		{"wrong email", repo, res.Code, "john@there.com", http.StatusOK},
+		{"unknown code", repo, "NOTACODE", "jane@here.com", http.StatusOK},
+		{"missing code", repo, "", "jane@here.com", http.StatusOK},
+		{"database failure", NewRepo(&app, dbrepo.NewMemoryRepo(&app, dbrepo.Failure{Method: "GetReservationByCode"})), res.Code, "jane@here.com", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		rr, ctx := manageBooking(e.repo.PostManageBooking, "", url.Values{"code": {e.code}, "email": {e.email}})
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		found := session.GetString(ctx, "booking_code")
+		if (e.expectedCode == http.StatusSeeOther) != (found == res.Code) {
+			t.Errorf("%s: unexpected booking in the session %q", e.name, found)
+		}
+		if e.expectedCode == http.StatusOK && !strings.Contains(rr.Body.String(), "We couldn&#39;t find a booking") && e.code != "" {
+			t.Errorf("%s: expected the guest to be told the booking wasn't found", e.name)
+		}
+	}
+}
+
+func TestRepository_ManageBookingDetails(t *testing.T) {
+	repo := NewRepo(&app, dbrepo.NewMemoryRepo(&app))
+	res := makeTestReservation(t, repo, 1, time.Date(2050, time.July, 1, 0, 0, 0, 0, time.UTC), 2, perNight(2))
+	soon := makeTestReservation(t, repo, 2, rules.Today().AddDate(0, 0, 1), 2, perNight(2))
+	today := makeTestReservation(t, repo, 1, rules.Today(), 1, perNight(1))
+
+	rr, _ := manageBooking(repo.ManageBookingDetails, res.Code, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	for _, want := range []string{res.Code, "General&#39;s Quarters", "Cancel Booking", "2050-06-29"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected the page to show %q", want)
+		}
+	}
+
+	rr, _ = manageBooking(repo.ManageBookingDetails, soon.Code, nil)
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "Change Dates") || !strings.Contains(rr.Body.String(), "will not be refunded") {
+		t.Errorf("expected a booking inside the cancellation window to be cancellable without a refund only, got %d", rr.Code)
+	}
+
+	rr, _ = manageBooking(repo.ManageBookingDetails, today.Code, nil)
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "Cancel Booking") {
+		t.Errorf("expected a booking arriving today to be shown without changes, got %d", rr.Code)
+	}
+
+	for _, code := range []string{"", "NOTACODE"} {
+		rr, _ = manageBooking(repo.ManageBookingDetails, code, nil)
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/manage-booking" {
+			t.Errorf("code %q: expected to be sent to look the booking up, got %d %s", code, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_PostManageBookingDates(t *testing.T) {
+	repo := NewRepo(&app, dbrepo.NewMemoryRepo(&app))
+	start := time.Date(2050, time.July, 4, 0, 0, 0, 0, time.UTC)
+	res := makeTestReservation(t, repo, 2, start, 2, perNight(2))
+	makeTestReservation(t, repo, 2, start.AddDate(0, 0, 10), 2, perNight(2))
+	soon := makeTestReservation(t, repo, 1, rules.Today().AddDate(0, 0, 1), 2, perNight(2))
+
+	var tests = []struct {
+		name         string
+		code         string
+		startDate    string
+		endDate      string
+		expectedCode int
+		moved        bool
+	}{
+		{"onto another booking", res.Code, "2050-07-12", "2050-07-15", http.StatusOK, false},
+		{"departure before arrival", res.Code, "2050-07-12", "2050-07-10", http.StatusOK, false},
+		{"not a date", res.Code, "soon", "2050-07-10", http.StatusOK, false},
+		{"inside the cancellation window", soon.Code, "2050-08-01", "2050-08-03", http.StatusSeeOther, false},
+		{"moved", res.Code, "2050-07-05", "2050-07-08", http.StatusSeeOther, true},
+	}
+
+	for _, e := range tests {
+		rr, _ := manageBooking(repo.PostManageBookingDates, e.code, url.Values{"start_date": {e.startDate}, "end_date": {e.endDate}})
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		booked, _ := repo.DB.GetReservationByCode(e.code)
+		moved := booked.StartDate.Format("2006-01-02") == e.startDate
+		if moved != e.moved {
+			t.Errorf("%s: expected moved to be %t, got %s to %s", e.name, e.moved, booked.StartDate, booked.EndDate)
+		}
+	}
+
+	moved, _ := repo.DB.GetReservationByCode(res.Code)
+	// 3 weekday nights at $129 and a Friday at $149, the $25 fee and 10% tax
+	if moved.Quote.Nights != 3 || moved.Quote.Total != 45320 {
+		t.Errorf("expected the new dates to be priced, got %+v", moved.Quote)
+	}
+	if available, _ := repo.DB.SearchAvailabilityByDatesByRoomID(start, start.AddDate(0, 0, 1), 2); !available {
+		t.Error("expected the night given up to be freed")
+	}
+
+	queued, err := repo.DB.ClaimOutboxMessages(time.Now(), 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 2 || queued[0].Mail.Subject != "Your reservation has been changed" || queued[1].Mail.To != app.OwnerEmail {
+		t.Fatalf("expected the guest and owner to be told of the change, got %+v", queued)
+	}
+	if !strings.Contains(queued[0].Mail.Content, "2050-07-05") {
+		t.Errorf("expected the guest's mail to have the new dates, got:\n%s", queued[0].Mail.Content)
+	}
+}
+
+func TestRepository_PostManageBookingCancel(t *testing.T) {
+	repo := NewRepo(&app, dbrepo.NewMemoryRepo(&app))
+	start := time.Date(2050, time.August, 1, 0, 0, 0, 0, time.UTC)
+	res := makeTestReservation(t, repo, 1, start, 3, perNight(3))
+	soon := makeTestReservation(t, repo, 2, rules.Today().AddDate(0, 0, 1), 2, perNight(2))
+	today := makeTestReservation(t, repo, 1, rules.Today(), 1, perNight(1))
+
+	rr, ctx := manageBooking(repo.PostManageBookingCancel, res.Code, url.Values{})
+	if rr.Code != http.StatusSeeOther || session.GetString(ctx, "flash") != "Your booking has been cancelled" {
+		t.Fatalf("expected the booking to be cancelled, got %d", rr.Code)
+	}
+
+	cancelled, _ := repo.DB.GetReservationByCode(res.Code)
+	if !cancelled.Cancelled() || cancelled.Refund != cancelled.Quote.Total {
+		t.Errorf("expected the reservation to be cancelled with a full refund, got %s refunding %d", cancelled.Status, cancelled.Refund)
+	}
+	if available, _ := repo.DB.SearchAvailabilityByDatesByRoomID(start, start.AddDate(0, 0, 3), 1); !available {
+		t.Error("expected cancelling to free the room")
+	}
+
+	queued, err := repo.DB.ClaimOutboxMessages(time.Now(), 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 2 || queued[0].Mail.Subject != "Your reservation has been cancelled" || !strings.Contains(queued[1].Mail.Subject, res.Code) {
+		t.Fatalf("expected the guest and owner to be told of the cancellation, got %+v", queued)
+	}
+	if !strings.Contains(queued[0].Mail.Content, pricing.FormatAmount(cancelled.Refund)) {
+		t.Errorf("expected the guest's mail to have the refund, got:\n%s", queued[0].Mail.Content)
+	}
+
+	// inside the cancellation window the booking can still be cancelled, but isn't refunded
+	rr, _ = manageBooking(repo.PostManageBookingCancel, soon.Code, url.Values{})
+	if late, _ := repo.DB.GetReservationByCode(soon.Code); rr.Code != http.StatusSeeOther || !late.Cancelled() || late.Refund != 0 {
+		t.Errorf("expected a late cancellation without a refund, got %d refunding %d", rr.Code, late.Refund)
+	}
+
+	for _, code := range []string{res.Code, today.Code} {
+		rr, ctx = manageBooking(repo.PostManageBookingCancel, code, url.Values{})
+		if rr.Code != http.StatusSeeOther || session.GetString(ctx, "error") == "" {
+			t.Errorf("%s: expected the cancellation to be refused, got %d", code, rr.Code)
+		}
+	}
+	if stillBooked, _ := repo.DB.GetReservationByCode(today.Code); stillBooked.Cancelled() {
+		t.Error("expected a booking arriving today to stand")
+	}
+
+	failing := NewRepo(&app, dbrepo.NewMemoryRepo(&app, dbrepo.Failure{Method: "UpdateReservationStatus"}))
+	res = makeTestReservation(t, failing, 1, start, 3, perNight(3))
+	rr, _ = manageBooking(failing.PostManageBookingCancel, res.Code, url.Values{})
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected a database failure to give 500, got %d", rr.Code)
+	}
+}
@@ -1,21 +1,24 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/tsawler/bookings-app/internal/config"
-	"github.com/tsawler/bookings-app/internal/driver"
 	"github.com/tsawler/bookings-app/internal/forms"
 	"github.com/tsawler/bookings-app/internal/helpers"
 	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
 	"github.com/tsawler/bookings-app/internal/render"
 	"github.com/tsawler/bookings-app/internal/repository"
-	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
+	"github.com/tsawler/bookings-app/internal/rules"
 )
 
 // Repo the repository used by the handlers
@@ -28,10 +31,10 @@ type Repository struct {
 }
 
 // NewRepo creates a new repository
-func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
+func NewRepo(a *config.AppConfig, db repository.DatabaseRepo) *Repository {
 	return &Repository{
 		App: a,
-		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
+		DB:  db,
 	}
 }
 
@@ -52,10 +55,24 @@ func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
 
 // Reservation renders the make a reservation page and displays form
 func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
-	var emptyReservation models.Reservation
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok || res.RoomID == 0 {
+		m.App.Session.Put(r.Context(), "error", "Please search for a room first")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(res.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	res.Room = room
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+
 	data := make(map[string]interface{})
-	data["reservation"] = emptyReservation
-	log.Println("We called /make-reservation")
+	data["reservation"] = res
 
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		Form: forms.New(nil),
@@ -63,6 +80,16 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// reservationForm is the form guests book a room with
+type reservationForm struct {
+	FirstName string    `form:"first_name" validate:"required,min=3"`
+	LastName  string    `form:"last_name" validate:"required"`
+	Email     string    `form:"email" validate:"required,email"`
+	Phone     string    `form:"phone"`
+	StartDate time.Time `form:"start_date" validate:"required,notpast"`
+	EndDate   time.Time `form:"end_date" validate:"required,after=start_date"`
+}
+
 // PostReservation handles the posting of a reservation form
 func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
@@ -71,79 +98,233 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	date_layout := "2006-01-02"
-	sd, err := time.Parse(date_layout, r.Form.Get("start_date"))
+	room_id, err := strconv.Atoi(r.Form.Get("room_id"))
 	if err != nil {
 		helpers.ServerError(w, err)
+		return
 	}
-	ed, err := time.Parse(date_layout, r.Form.Get("end_date"))
+
+	form := forms.New(r.PostForm)
+	reservation, err := m.checkReservation(form, room_id)
 	if err != nil {
 		helpers.ServerError(w, err)
+		return
+	}
+
+	if sessionRes, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation); ok && sessionRes.RoomID == reservation.RoomID {
+		reservation.Room = sessionRes.Room
+	}
+
+	if !form.Valid() {
+		m.reservationInvalid(w, r, form, reservation)
+		return
+	}
+
+	reservation, err = m.bookReservation(form, reservation)
+	if repository.IsRoomUnavailable(err) {
+		m.roomTaken(w, r, reservation)
+		return
 	}
-	room_id, err := strconv.Atoi(r.Form.Get("room_id"))
 	if err != nil {
 		helpers.ServerError(w, err)
+		return
+	}
+	if !form.Valid() {
+		m.reservationInvalid(w, r, form, reservation)
+		return
 	}
 
-	reservation := models.Reservation{
-		FirstName: r.Form.Get("first_name"),
-		LastName:  r.Form.Get("last_name"),
-		Email:     r.Form.Get("email"),
-		Phone:     r.Form.Get("phone"),
-		StartDate: sd,
-		EndDate:   ed,
-		RoomID:    int(room_id),
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+// checkReservation reads a reservation of roomID from form, checking it against reservationForm and the
+// room's stay rules. Guests on the site and partners on the API are held to the same checks. The error is
+// only for a form that can't be decoded or rules that can't be read; bad input ends up in form.Errors.
+func (m *Repository) checkReservation(form *forms.Form, roomID int) (models.Reservation, error) {
+	var posted reservationForm
+	if err := form.Decode(&posted); err != nil {
+		return models.Reservation{}, err
+	}
+	form.IsPhone("phone", m.App.PhoneRegion)
+
+	res := models.Reservation{
+		FirstName: posted.FirstName,
+		LastName:  posted.LastName,
+		Email:     posted.Email,
+		Phone:     posted.Phone,
+		StartDate: posted.StartDate,
+		EndDate:   posted.EndDate,
+		RoomID:    roomID,
 	}
 
-	form := forms.New(r.PostForm)
+	// the room's own rules are only worth checking once the dates make sense
+	if form.Errors.Get("start_date") == "" && form.Errors.Get("end_date") == "" {
+		stayRules, err := m.DB.GetStayRulesForRoom(roomID)
+		if err != nil {
+			return res, err
+		}
+		form.StayDates("start_date", "end_date", stayRules, rules.Today())
+	}
 
-	form.Required("first_name", "last_name", "email")
-	form.MinLength("first_name", 3)
-	form.IsEmail("email")
+	if form.Valid() {
+		res.Phone = form.Phone("phone", m.App.PhoneRegion)
+	}
+	return res, nil
+}
 
-	if !form.Valid() {
-		data := make(map[string]interface{})
-		data["reservation"] = reservation
-		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form: form,
-			Data: data,
-		})
-		return
+// bookReservation prices and stores a reservation that passed checkReservation, along with the mail about
+// it. A room someone else booked in the meantime gives a *repository.RoomUnavailableError. Stay rules that
+// changed since the check are added to form.Errors, and the reservation isn't stored.
+func (m *Repository) bookReservation(form *forms.Form, res models.Reservation) (models.Reservation, error) {
+	var err error
+	res.Quote, err = pricing.Quote(m.DB, m.App.Pricing, res.RoomID, res.StartDate, res.EndDate)
+	if err != nil {
+		return res, err
 	}
 
-	newID, err := m.DB.InsertReservation(reservation)
+	// the code is chosen before the reservation is stored, so it doesn't need reading back, and every
+	// reservation is stored pending
+	res.Code = models.NewConfirmationCode()
+	res.Status = models.StatusPending
+
+	// the mail is stored with the reservation, so it goes out if and only if the booking was made
+	mail, err := m.reservationMail(res)
 	if err != nil {
-		helpers.ServerError(w, err)
+		return res, err
 	}
-	reservation.ID = newID
 
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: reservation.ID,
-		RestrictionID: 1, // temp
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+	newID, err := m.DB.CreateReservation(res, mail...)
+	if addRuleErrors(form, err) {
+		// the rules changed between the check and the booking
+		return res, nil
 	}
-	roomRestrictID, err := m.DB.InsertRoomRestriction(restriction)
 	if err != nil {
-		helpers.ServerError(w, err)
+		return res, err
 	}
-	log.Printf("RRID = %d", roomRestrictID)
+	res.ID = newID
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	return res, nil
+}
+
+// addRuleErrors adds the violations of err to form's start_date and end_date fields if err is a
+// *rules.Error, and reports whether it was
+func addRuleErrors(form *forms.Form, err error) bool {
+	var broken *rules.Error
+	if !errors.As(err, &broken) {
+		return false
+	}
+
+	for _, v := range broken.Violations {
+		field := "start_date"
+		if v.Field == rules.Departure {
+			field = "end_date"
+		}
+		form.Errors.Add(field, v.Message)
+	}
+	return true
+}
+
+// reservationMail renders the guest's receipt and the owner's notice of a new reservation. The guest is
+// only told that their stay is confirmed once staff confirm it.
+func (m *Repository) reservationMail(res models.Reservation) ([]models.MailData, error) {
+	if res.Room.ID == 0 {
+		room, err := m.DB.GetRoomByID(res.RoomID)
+		if err != nil {
+			return nil, err
+		}
+		res.Room = room
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	receipt, err := render.Email("reservation-received", data)
+	if err != nil {
+		return nil, err
+	}
+
+	notice, err := render.Email("reservation-notice", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return []models.MailData{
+		{
+			To:      res.Email,
+			Subject: "We have received your reservation",
+			Content: receipt,
+		},
+		{
+			To:      m.App.OwnerEmail,
+			Subject: fmt.Sprintf("New reservation: %s from %s", res.Room.RoomName, res.StartDate.Format("2006-01-02")),
+			Content: notice,
+		},
+	}, nil
 }
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// reservationInvalid shows the reservation form again with its errors
+func (m *Repository) reservationInvalid(w http.ResponseWriter, r *http.Request, form *forms.Form, res models.Reservation) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
 }
 
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+// roomTaken sends the guest back to the search page, with their dates filled in, when someone else
+// booked the room before their reservation could be stored
+func (m *Repository) roomTaken(w http.ResponseWriter, r *http.Request, res models.Reservation) {
+	m.App.Session.Put(r.Context(), "reservation", models.Reservation{
+		StartDate: res.StartDate,
+		EndDate:   res.EndDate,
+	})
+	m.App.Session.Put(r.Context(), "warning", "Sorry, that room was just booked by someone else. Please search again.")
+
+	stringMap := make(map[string]string)
+	stringMap["start"] = res.StartDate.Format("2006-01-02")
+	stringMap["end"] = res.EndDate.Format("2006-01-02")
+
+	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+	})
+}
+
+// Rooms renders the list of rooms
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Room renders the page of the room named by the {slug} url parameter
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if err == sql.ErrNoRows {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
 // Availability renders the search availability page
@@ -151,26 +332,190 @@ func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
 }
 
-// PostAvailability handles post
+// PostAvailability searches for rooms that are free for the posted dates
 func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
-	start := r.Form.Get("start")
-	end := r.Form.Get("end")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := dateRangeForm(r.PostForm, "start", "end")
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["start"] = form.Get("start")
+		stringMap["end"] = form.Get("end")
+
+		render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		})
+		return
+	}
+	start, end := form.Date("start"), form.Date("end")
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(start, end)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// rooms without a rate, or whose stay rules the dates break, can't be booked, so they are left out
+	var priced []models.Room
+	var broken []rules.Violation
+	quotes := make(map[int]models.Quote)
+	today := rules.Today()
+	for _, room := range rooms {
+		stayRules, err := m.DB.GetStayRulesForRoom(room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if v := stayRules.Check(start, end, today); len(v) > 0 {
+			broken = append(broken, v...)
+			continue
+		}
+
+		quote, err := pricing.Quote(m.DB, m.App.Pricing, room.ID, start, end)
+		if errors.Is(err, pricing.ErrNoRate) {
+			m.App.ErrorLog.Println(err)
+			continue
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		priced = append(priced, room)
+		quotes[room.ID] = quote
+	}
+	rooms = priced
+
+	if len(rooms) == 0 {
+		msg := "No availability"
+		if len(broken) > 0 {
+			msg = broken[0].Message
+		}
+		m.App.Session.Put(r.Context(), "error", msg)
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	res := models.Reservation{
+		StartDate: start,
+		EndDate:   end,
+	}
+	m.App.Session.Put(r.Context(), "reservation", res)
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["quotes"] = quotes
+	data["reservation"] = res
+
+	render.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// ChooseRoom stores the chosen room in the session reservation and sends the guest on to book it
+func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Your search has expired, please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
 
-	w.Write([]byte(fmt.Sprintf("start date is %s and end is %s", start, end)))
+	res.RoomID = roomID
+	m.App.Session.Put(r.Context(), "reservation", res)
+
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 }
 
 type jsonResponse struct {
-	OK      bool   `json:"ok"`
-	Message string `json:"message"`
+	OK        bool   `json:"ok"`
+	Message   string `json:"message"`
+	RoomID    string `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Total     string `json:"total,omitempty"`
 }
 
 // AvailabilityJSON handles request for availability and sends JSON response
 func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	resp := jsonResponse{
-		OK:      true,
-		Message: "Available!",
+		RoomID:    r.FormValue("room_id"),
+		StartDate: r.FormValue("start"),
+		EndDate:   r.FormValue("end"),
+	}
+
+	form := dateRangeForm(url.Values{"start": {resp.StartDate}, "end": {resp.EndDate}}, "start", "end")
+	if !form.Valid() {
+		resp.Message = form.Errors.Get("start")
+		if resp.Message == "" {
+			resp.Message = form.Errors.Get("end")
+		}
+		writeJSON(w, resp)
+		return
+	}
+	start, end := form.Date("start"), form.Date("end")
+
+	roomID, err := strconv.Atoi(resp.RoomID)
+	if err != nil {
+		resp.Message = "Invalid room"
+		writeJSON(w, resp)
+		return
 	}
 
+	stayRules, err := m.DB.GetStayRulesForRoom(roomID)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		resp.Message = "Error querying database"
+		writeJSON(w, resp)
+		return
+	}
+	if v := stayRules.Check(start, end, rules.Today()); len(v) > 0 {
+		resp.Message = v[0].Message
+		writeJSON(w, resp)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(start, end, roomID)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		resp.Message = "Error querying database"
+		writeJSON(w, resp)
+		return
+	}
+
+	resp.OK = available
+	if available {
+		resp.Message = "Available!"
+
+		quote, err := pricing.Quote(m.DB, m.App.Pricing, roomID, start, end)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		} else {
+			resp.Total = pricing.FormatAmount(quote.Total)
+		}
+	} else {
+		resp.Message = "Not available for those dates"
+	}
+	writeJSON(w, resp)
+}
+
+// writeJSON sends resp to the client as indented JSON
+func writeJSON(w http.ResponseWriter, resp interface{}) {
+	writeJSONStatus(w, http.StatusOK, resp)
+}
+
+// writeJSONStatus sends resp to the client as indented JSON, with the status code
+func writeJSONStatus(w http.ResponseWriter, status int, resp interface{}) {
 	out, err := json.MarshalIndent(resp, "", "     ")
 	if err != nil {
 		helpers.ServerError(w, err)
@@ -178,9 +523,25 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(out)
 }
 
+// maxSearchNights is the longest stay guests can search for
+const maxSearchNights = 365
+
+// dateRangeForm validates an arrival and departure in DateLayout, making sure the stay is at least
+// one night, doesn't arrive in the past and isn't longer than maxSearchNights
+func dateRangeForm(data url.Values, start, end string) *forms.Form {
+	form := forms.New(data)
+	form.IsDate(start, forms.DateLayout)
+	form.IsDate(end, forms.DateLayout)
+	form.DateAfter(end, start)
+	form.DateNotInPast(start, rules.Today())
+	form.DateRangeMaxDays(start, end, maxSearchNights)
+	return form
+}
+
 // Contact renders the contact page
 func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
@@ -199,6 +560,8 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	helpers.PrintStruct(reservation)
 
 	m.App.Session.Remove(r.Context(), "reservation")
+	// the summary is only shown once, but the guest can come back to the booking through manage booking
+	m.App.Session.Put(r.Context(), "booking_code", reservation.Code)
 
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
@@ -207,3 +570,60 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 		Data: data,
 	})
 }
+
+// ShowLogin shows the login screen
+func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// loginForm is the staff login form
+type loginForm struct {
+	Email    string `form:"email" validate:"required,email"`
+	Password string `form:"password" validate:"required"`
+}
+
+// PostShowLogin handles logging the user in
+func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+	// a fresh session token on every login attempt prevents session fixation
+	_ = m.App.Session.RenewToken(r.Context())
+
+	var login loginForm
+	form, err := forms.Bind(r, &login)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	user, err := m.DB.Authenticate(login.Email, login.Password)
+	if err == repository.ErrInvalidCredentials {
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "user_id", user.ID)
+	m.App.Session.Put(r.Context(), "access_level", user.AccessLevel)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// Logout logs a user out
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
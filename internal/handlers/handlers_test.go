@@ -1,10 +1,21 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
+	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
+	"github.com/tsawler/bookings-app/internal/rules"
 )
 
 type postData struct {
@@ -23,9 +34,30 @@ var theTests = []struct {
 	{"about", "/about", "GET", []postData{}, http.StatusOK},
 	{"generals-quarters", "/generals-quarters", "GET", []postData{}, http.StatusOK},
 	{"majors-suite", "/majors-suite", "GET", []postData{}, http.StatusOK},
+	{"rooms", "/rooms", "GET", []postData{}, http.StatusOK},
+	{"room", "/rooms/majors-suite", "GET", []postData{}, http.StatusOK},
+	{"missing room", "/rooms/no-such-room", "GET", []postData{}, http.StatusNotFound},
 	{"search-availability", "/search-availability", "GET", []postData{}, http.StatusOK},
 	{"contact", "/contact", "GET", []postData{}, http.StatusOK},
+	{"manage booking", "/manage-booking", "GET", []postData{}, http.StatusOK},
 	{"make-res", "/make-reservation", "GET", []postData{}, http.StatusOK},
+	{"choose-room", "/choose-room/1", "GET", []postData{}, http.StatusOK},
+	{"choose-room-bad-id", "/choose-room/abc", "GET", []postData{}, http.StatusBadRequest},
+	{"login", "/user/login", "GET", []postData{}, http.StatusOK},
+	{"logout", "/user/logout", "GET", []postData{}, http.StatusOK},
+	{"dashboard", "/admin/dashboard", "GET", []postData{}, http.StatusOK},
+	{"new reservations", "/admin/reservations-new", "GET", []postData{}, http.StatusOK},
+	{"all reservations", "/admin/reservations-all", "GET", []postData{}, http.StatusOK},
+	{"calendar", "/admin/reservations-calendar", "GET", []postData{}, http.StatusOK},
+	{"calendar for a month", "/admin/reservations-calendar?y=2060&m=1", "GET", []postData{}, http.StatusOK},
+	{"calendar for a bad month", "/admin/reservations-calendar?y=2060&m=13", "GET", []postData{}, http.StatusBadRequest},
+	{"outbox", "/admin/outbox", "GET", []postData{}, http.StatusOK},
+	{"admin rooms", "/admin/rooms", "GET", []postData{}, http.StatusOK},
+	{"admin new room", "/admin/rooms/new", "GET", []postData{}, http.StatusOK},
+	{"admin room rules", "/admin/rooms/1/rules", "GET", []postData{}, http.StatusOK},
+	{"admin rules for a missing room", "/admin/rooms/1000/rules", "GET", []postData{}, http.StatusNotFound},
+	{"admin room calendars", "/admin/rooms/1/calendars", "GET", []postData{}, http.StatusOK},
+	{"admin calendars for a missing room", "/admin/rooms/1000/calendars", "GET", []postData{}, http.StatusNotFound},
 	{"post-search-availability", "/search-availability", "Post", []postData{
 		{key: "start", value: "2020-01-01"},
 		{key: "end", value: "2020-01-02"},
@@ -38,7 +70,10 @@ var theTests = []struct {
 		{key: "first_name", value: "John"},
 		{key: "last_name", value: "Smith"},
 		{key: "email", value: "me@here.com"},
-		{key: "phone", value: "555-555-5555"},
+		{key: "phone", value: "(202) 555-0143"},
+		{key: "start_date", value: "2050-01-01"},
+		{key: "end_date", value: "2050-01-02"},
+		{key: "room_id", value: "1"},
 	}, http.StatusOK},
 }
 
@@ -77,3 +112,562 @@ func TestHandlers(t *testing.T) {
 		}
 	}
 }
+
+func TestRepository_Reservation(t *testing.T) {
+	res := models.Reservation{
+		StartDate: time.Date(2050, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, time.January, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+	}
+
+	req, _ := http.NewRequest("GET", "/make-reservation", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	session.Put(ctx, "reservation", res)
+
+	http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	// reservation is not in session
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	req = req.WithContext(getCtx(req))
+	rr = httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	// room does not exist
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	res.RoomID = 100
+	session.Put(ctx, "reservation", res)
+
+	http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestRepository_PostReservation(t *testing.T) {
+	var tests = []struct {
+		name         string
+		roomID       string
+		startDate    string
+		firstName    string
+		expectedCode int
+	}{
+		{"valid", "1", "2050-02-01", "John", http.StatusSeeOther},
+		{"invalid form", "1", "2050-02-10", "J", http.StatusOK},
+		{"bad start date", "1", "invalid", "John", http.StatusOK},
+		{"start after end", "1", "2050-02-05", "John", http.StatusOK},
+		{"bad room id", "x", "2050-02-01", "John", http.StatusInternalServerError},
+		{"database failure", "1000", "2050-02-01", "John", http.StatusInternalServerError},
+		{"room already taken", "1", "2050-02-01", "John", http.StatusOK},
+		{"arrival in the past", "1", "2000-02-01", "John", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		values := url.Values{}
+		values.Add("first_name", e.firstName)
+		values.Add("last_name", "Smith")
+		values.Add("email", "john@smith.com")
+		values.Add("phone", "(202) 555-0143")
+		values.Add("start_date", e.startDate)
+		values.Add("end_date", "2050-02-03")
+		values.Add("room_id", e.roomID)
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(values.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: PostReservation returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+	}
+}
+
+func TestRepository_PostReservationPhone(t *testing.T) {
+	var tests = []struct {
+		name         string
+		phone        string
+		expectedCode int
+		expected     string
+	}{
+		{"local number", "(202) 555-0143", http.StatusSeeOther, "+12025550143"},
+		{"international number", "+44 20 7946 0958", http.StatusSeeOther, "+442079460958"},
+		{"no number", "", http.StatusSeeOther, ""},
+		{"garbage", "call me maybe", http.StatusOK, ""},
+		{"too short", "555-0143", http.StatusOK, ""},
+	}
+
+	for i, e := range tests {
+		start := time.Date(2050, time.August, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i*3)
+
+		values := url.Values{}
+		values.Add("first_name", "John")
+		values.Add("last_name", "Smith")
+		values.Add("email", "john@smith.com")
+		values.Add("phone", e.phone)
+		values.Add("start_date", start.Format("2006-01-02"))
+		values.Add("end_date", start.AddDate(0, 0, 2).Format("2006-01-02"))
+		values.Add("room_id", "1")
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(values.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: PostReservation returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+			continue
+		}
+		if rr.Code != http.StatusSeeOther {
+			if !strings.Contains(rr.Body.String(), "Invalid phone number") {
+				t.Errorf("%s: expected the form to show the phone error", e.name)
+			}
+			continue
+		}
+
+		res := session.Get(ctx, "reservation").(models.Reservation)
+		stored, err := Repo.DB.GetReservationByID(res.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Phone != e.expected {
+			t.Errorf("%s: expected the phone to be stored as %q, got %q", e.name, e.expected, stored.Phone)
+		}
+	}
+}
+
+func TestRepository_PostReservationMail(t *testing.T) {
+	repo := NewRepo(&app, dbrepo.NewMemoryRepo(&app))
+
+	values := url.Values{}
+	values.Add("first_name", "John")
+	values.Add("last_name", "Smith")
+	values.Add("email", "john@smith.com")
+	values.Add("start_date", "2050-09-01")
+	values.Add("end_date", "2050-09-03")
+	values.Add("room_id", "1")
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(values.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(repo.PostReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("PostReservation returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	queued, err := repo.DB.ClaimOutboxMessages(time.Now(), 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 2 {
+		t.Fatalf("expected two messages in the outbox, got %d", len(queued))
+	}
+
+	receipt, notice := queued[0].Mail, queued[1].Mail
+	if receipt.To != "john@smith.com" || receipt.Subject != "We have received your reservation" {
+		t.Errorf("expected a receipt for the guest, got %+v", receipt)
+	}
+	if notice.To != app.OwnerEmail || !strings.Contains(notice.Subject, "General's Quarters") {
+		t.Errorf("expected a notice for the owner, got %+v", notice)
+	}
+	for _, msg := range queued {
+		if !strings.Contains(msg.Mail.Content, "2050-09-01") || !strings.Contains(msg.Mail.Content, "General&#39;s Quarters") {
+			t.Errorf("expected %q to be rendered with the reservation, got:\n%s", msg.Mail.Subject, msg.Mail.Content)
+		}
+	}
+}
+
+func TestRepository_PostReservationMailFailed(t *testing.T) {
+	// a booking that can't be stored must not send mail about it
+	repo := NewRepo(&app, dbrepo.NewMemoryRepo(&app, dbrepo.Failure{Method: "InsertRoomRestriction", RoomID: 1}))
+
+	values := url.Values{}
+	values.Add("first_name", "John")
+	values.Add("last_name", "Smith")
+	values.Add("email", "john@smith.com")
+	values.Add("start_date", "2050-09-01")
+	values.Add("end_date", "2050-09-03")
+	values.Add("room_id", "1")
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(values.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(repo.PostReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("PostReservation returned wrong response code: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
+	}
+
+	queued, err := repo.DB.ClaimOutboxMessages(time.Now(), 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 0 {
+		t.Errorf("expected nothing in the outbox, got %d messages", len(queued))
+	}
+}
+
+func TestRepository_PostReservationQuote(t *testing.T) {
+	values := url.Values{}
+	values.Add("first_name", "John")
+	values.Add("last_name", "Smith")
+	values.Add("email", "john@smith.com")
+	values.Add("start_date", "2050-05-05")
+	values.Add("end_date", "2050-05-08")
+	values.Add("room_id", "2")
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(values.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("PostReservation returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	res := session.Get(ctx, "reservation").(models.Reservation)
+	start := time.Date(2050, time.May, 5, 0, 0, 0, 0, time.UTC)
+	expected, _ := pricing.Quote(Repo.DB, app.Pricing, 2, start, start.AddDate(0, 0, 3))
+	if res.Quote != expected || res.Quote.Nights != 3 || res.Quote.Total == 0 {
+		t.Errorf("expected the reservation to carry its quote %+v, got %+v", expected, res.Quote)
+	}
+
+	stored, err := Repo.DB.GetReservationByID(res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Quote != expected {
+		t.Errorf("expected the quote to be saved with the reservation, got %+v", stored.Quote)
+	}
+}
+
+func TestRepository_StayRules(t *testing.T) {
+	repo := NewRepo(&app, dbrepo.NewMemoryRepo(&app))
+	stayRules := rules.Default(1)
+	stayRules.MinNights = 3
+	if err := repo.DB.SetStayRules(stayRules); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(handler http.HandlerFunc, path string, values url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(values.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	values := url.Values{}
+	values.Add("first_name", "John")
+	values.Add("last_name", "Smith")
+	values.Add("email", "john@smith.com")
+	values.Add("start_date", "2050-06-01")
+	values.Add("end_date", "2050-06-03")
+	values.Add("room_id", "1")
+
+	rr := post(repo.PostReservation, "/make-reservation", values)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "at least 3 nights") {
+		t.Errorf("expected the form to show the minimum stay, got %d", rr.Code)
+	}
+	if reservations, _ := repo.DB.AllReservations(); len(reservations) != 0 {
+		t.Error("expected a stay that breaks the rules not to be booked")
+	}
+
+	values.Set("end_date", "2050-06-04")
+	if rr := post(repo.PostReservation, "/make-reservation", values); rr.Code != http.StatusSeeOther {
+		t.Errorf("expected a stay that keeps the rules to be booked, got %d", rr.Code)
+	}
+
+	values = url.Values{}
+	values.Add("room_id", "1")
+	values.Add("start", "2050-07-01")
+	values.Add("end", "2050-07-02")
+	var j jsonResponse
+	if err := json.Unmarshal(post(repo.AvailabilityJSON, "/search-availability-json", values).Body.Bytes(), &j); err != nil {
+		t.Fatal(err)
+	}
+	if j.OK || j.Message != "Stays in this room are at least 3 nights" {
+		t.Errorf("expected the minimum stay in the json response, got %+v", j)
+	}
+
+	// room 2 has no rules of its own, so only it is offered for a one night stay
+	values.Del("room_id")
+	rr = post(repo.PostAvailability, "/search-availability", values)
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "/choose-room/1") || !strings.Contains(rr.Body.String(), "/choose-room/2") {
+		t.Errorf("expected only room 2 to be offered, got %d", rr.Code)
+	}
+}
+
+func TestRepository_PostAvailability(t *testing.T) {
+	var tests = []struct {
+		name             string
+		repo             *Repository
+		start            string
+		end              string
+		expectedCode     int
+		expectedLocation string
+		expectedText     string
+	}{
+		{"rooms available", Repo, "2050-03-01", "2050-03-02", http.StatusOK, "", ""},
+		{"bad dates", Repo, "2050-03-02", "2050-03-01", http.StatusOK, "", "This date must be after 2050-03-02"},
+		{"not a date", Repo, "soon", "2050-03-01", http.StatusOK, "", "This field must be a date like 2021-12-31"},
+		{"in the past", Repo, "2000-03-01", "2000-03-02", http.StatusOK, "", "This date can&#39;t be in the past"},
+		{"too long", Repo, "2050-03-01", "2052-03-01", http.StatusOK, "", "The dates can be at most 365 days apart"},
+		{"database failure", NewRepo(&app, dbrepo.NewMemoryRepo(&app, dbrepo.Failure{Method: "SearchAvailabilityForAllRooms"})), "2050-03-01", "2050-03-02", http.StatusInternalServerError, "", ""},
+		{"pricing failure", NewRepo(&app, dbrepo.NewMemoryRepo(&app, dbrepo.Failure{Method: "GetRateOverridesForRoom"})), "2050-03-01", "2050-03-02", http.StatusInternalServerError, "", ""},
+	}
+
+	for _, e := range tests {
+		values := url.Values{}
+		values.Add("start", e.start)
+		values.Add("end", e.end)
+
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(values.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(e.repo.PostAvailability).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: PostAvailability returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if e.expectedText != "" && !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected the page to say %q", e.name, e.expectedText)
+		}
+	}
+}
+
+func TestRepository_AvailabilityJSON(t *testing.T) {
+	var tests = []struct {
+		name       string
+		repo       *Repository
+		roomID     string
+		start      string
+		expectedOK bool
+	}{
+		{"available", Repo, "2", "2050-04-01", true},
+		{"bad date", Repo, "2", "nonsense", false},
+		{"bad room", Repo, "two", "2050-04-01", false},
+		{"database failure", NewRepo(&app, dbrepo.NewMemoryRepo(&app, dbrepo.Failure{Method: "SearchAvailabilityByDatesByRoomID"})), "2", "2050-04-01", false},
+		{"rules failure", NewRepo(&app, dbrepo.NewMemoryRepo(&app, dbrepo.Failure{Method: "GetStayRulesForRoom"})), "2", "2050-04-01", false},
+		{"arrival in the past", Repo, "2", "2000-04-01", false},
+	}
+
+	for _, e := range tests {
+		values := url.Values{}
+		values.Add("start", e.start)
+		values.Add("end", "2050-04-05")
+		values.Add("room_id", e.roomID)
+
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(values.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(e.repo.AvailabilityJSON).ServeHTTP(rr, req)
+
+		var j jsonResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+			t.Fatalf("%s: failed to parse json: %s", e.name, err)
+		}
+		if j.OK != e.expectedOK {
+			t.Errorf("%s: expected ok to be %t but got %t (%s)", e.name, e.expectedOK, j.OK, j.Message)
+		}
+	}
+}
+
+func TestRepository_AvailabilityBooked(t *testing.T) {
+	repo := NewRepo(&app, dbrepo.NewMemoryRepo(&app))
+	// room 2 is booked for the nights of April 10th to 12th 2050
+	arrival := time.Date(2050, time.April, 10, 0, 0, 0, 0, time.UTC)
+	_, err := repo.DB.CreateReservation(models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@here.com",
+		StartDate: arrival,
+		EndDate:   arrival.AddDate(0, 0, 3),
+		RoomID:    2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name       string
+		start      string
+		end        string
+		expectedOK bool
+	}{
+		{"before the stay", "2050-04-01", "2050-04-05", true},
+		{"overlapping the stay", "2050-04-09", "2050-04-11", false},
+		{"inside the stay", "2050-04-11", "2050-04-12", false},
+		{"ending on its arrival day", "2050-04-08", "2050-04-10", true},
+		{"starting on its departure day", "2050-04-13", "2050-04-15", true},
+		{"bad date", "nonsense", "2050-04-15", false},
+	}
+
+	for _, e := range tests {
+		values := url.Values{}
+		values.Add("room_id", "2")
+		values.Add("start", e.start)
+		values.Add("end", e.end)
+
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(values.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(repo.AvailabilityJSON).ServeHTTP(rr, req)
+
+		var j jsonResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+			t.Fatalf("%s: failed to parse json: %s", e.name, err)
+		}
+		if j.OK != e.expectedOK {
+			t.Errorf("%s: expected ok to be %t but got %t (%s)", e.name, e.expectedOK, j.OK, j.Message)
+		}
+
+		if e.name == "bad date" {
+			continue
+		}
+
+		// the search page offers room 2 for the same dates only if it is free
+		values.Del("room_id")
+		req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(values.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr = httptest.NewRecorder()
+
+		http.HandlerFunc(repo.PostAvailability).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "/choose-room/1") {
+			t.Errorf("%s: expected room 1 to be offered, got %d", e.name, rr.Code)
+		}
+		if offered := strings.Contains(rr.Body.String(), "/choose-room/2"); offered != e.expectedOK {
+			t.Errorf("%s: expected room 2 to be offered to be %t", e.name, e.expectedOK)
+		}
+	}
+}
+
+func TestRepository_ChooseRoom(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/choose-room/1", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	session.Put(ctx, "reservation", models.Reservation{})
+
+	http.HandlerFunc(Repo.ChooseRoom).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/make-reservation" {
+		t.Errorf("ChooseRoom should redirect to /make-reservation, got %d to %s", rr.Code, rr.Header().Get("Location"))
+	}
+
+	chosen, _ := session.Get(ctx, "reservation").(models.Reservation)
+	if chosen.RoomID != 1 {
+		t.Errorf("expected room 1 to be stored in the session, got %d", chosen.RoomID)
+	}
+}
+
+func TestRepository_ReservationSummary(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/reservation-summary", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	session.Put(ctx, "reservation", models.Reservation{FirstName: "John"})
+
+	http.HandlerFunc(Repo.ReservationSummary).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("ReservationSummary returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	req, _ = http.NewRequest("GET", "/reservation-summary", nil)
+	req = req.WithContext(getCtx(req))
+	rr = httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.ReservationSummary).ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("ReservationSummary returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+}
+
+func TestRepository_PostShowLogin(t *testing.T) {
+	var tests = []struct {
+		name             string
+		repo             *Repository
+		email            string
+		password         string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"valid credentials", Repo, dbrepo.DemoEmail, dbrepo.DemoPassword, http.StatusSeeOther, "/admin/dashboard"},
+		{"wrong password", Repo, dbrepo.DemoEmail, "wrong", http.StatusSeeOther, "/user/login"},
+		{"invalid form", Repo, "not-an-email", "", http.StatusOK, ""},
+		{"database failure", NewRepo(&app, dbrepo.NewMemoryRepo(&app, dbrepo.Failure{Method: "Authenticate"})), dbrepo.DemoEmail, dbrepo.DemoPassword, http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		values := url.Values{}
+		values.Add("email", e.email)
+		values.Add("password", e.password)
+
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(values.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(e.repo.PostShowLogin).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: PostShowLogin returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		loggedIn := session.Exists(ctx, "user_id")
+		if loggedIn != (e.expectedLocation == "/admin/dashboard") {
+			t.Errorf("%s: unexpected login state %t", e.name, loggedIn)
+		}
+	}
+}
+
+// withURLParams adds chi url parameters to a request, for calling handlers without the router
+func withURLParams(req *http.Request, params map[string]string) *http.Request {
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func getCtx(req *http.Request) context.Context {
+	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+	if err != nil {
+		log.Println(err)
+	}
+	return ctx
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/ical"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/render"
+)
+
+// icalProdID names this site as the maker of its calendars
+const icalProdID = "-//Bookings App//Room Calendar//EN"
+
+// icalUID is the stable id of the event for a room restriction, so other sites see updates to
+// the same stay rather than new ones
+func icalUID(rr models.RoomRestriction) string {
+	return fmt.Sprintf("room-restriction-%d@bookings-app", rr.ID)
+}
+
+// ICalRoom serves a room's calendar, with every night it can't be booked, for other booking sites to
+// sync. The room's token must be given, and a wrong one is treated as a missing room.
+func (m *Repository) ICalRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if room.ICalToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(room.ICalToken)) != 1 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	restrictions, err := m.DB.GetRestrictionsForRoom(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	cal := ical.Calendar{ProdID: icalProdID, Name: room.RoomName}
+	for _, rr := range restrictions {
+		// other sites only need to know the nights are taken, not by whom
+		summary := "Not available"
+		if rr.ReservationID != 0 {
+			summary = "Reserved"
+		}
+		cal.Events = append(cal.Events, ical.Event{
+			UID:     icalUID(rr),
+			Summary: summary,
+			Start:   rr.StartDate,
+			End:     rr.EndDate,
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.ics"`, room.Slug))
+	if err := cal.Write(w, time.Now()); err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// AdminPostRoomICalToken gives a room a new calendar link, so that the old one stops working
+func (m *Repository) AdminPostRoomICalToken(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := m.DB.ResetRoomICalToken(room.ID); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s has a new calendar link", room.RoomName))
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", room.ID), http.StatusSeeOther)
+}
+
+// icalFeedForm is the form for adding another site's calendar to a room
+type icalFeedForm struct {
+	Name string `form:"name" validate:"required"`
+	URL  string `form:"url" validate:"required,url"`
+}
+
+// AdminRoomCalendars shows a room's calendar link, and the calendars of other sites it imports
+func (m *Repository) AdminRoomCalendars(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	m.showRoomCalendars(w, r, room, forms.New(nil))
+}
+
+// showRoomCalendars renders the calendars page for a room
+func (m *Repository) showRoomCalendars(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	feeds, err := m.DB.GetICalFeedsForRoom(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil || m.App.InProduction {
+		scheme = "https"
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["feeds"] = feeds
+	data["export_url"] = fmt.Sprintf("%s://%s/ical/rooms/%d.ics?token=%s", scheme, r.Host, room.ID, room.ICalToken)
+
+	render.Template(w, r, "admin-rooms-calendars.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminPostRoomCalendars adds another site's calendar to a room, and imports it straight away so that
+// any problem with it shows up
+func (m *Repository) AdminPostRoomCalendars(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var posted icalFeedForm
+	form, err := forms.Bind(r, &posted)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		m.showRoomCalendars(w, r, room, form)
+		return
+	}
+
+	feed := models.ICalFeed{RoomID: room.ID, Name: posted.Name, URL: strings.TrimSpace(posted.URL)}
+	feed.ID, err = m.DB.InsertICalFeed(feed)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	importer := &ical.Importer{
+		DB:       m.DB,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		ErrorLog: m.App.ErrorLog,
+	}
+	result, err := importer.Sync(feed)
+	switch {
+	case err != nil:
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("%s was added, but could not be imported: %s", feed.Name, err))
+	case len(result.Conflicts) > 0:
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("%d booking(s) from %s overlap nights already taken here", len(result.Conflicts), feed.Name))
+	default:
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Imported %d booking(s) from %s", result.Added, feed.Name))
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", room.ID), http.StatusSeeOther)
+}
+
+// AdminPostDeleteRoomCalendar stops importing another site's calendar, releasing the nights booked through it
+func (m *Repository) AdminPostDeleteRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	feedID, err := strconv.Atoi(chi.URLParam(r, "feedID"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	feeds, err := m.DB.GetICalFeedsForRoom(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for _, feed := range feeds {
+		if feed.ID != feedID {
+			continue
+		}
+
+		if err := m.DB.DeleteICalFeed(feed.ID); err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s removed", feed.Name))
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", room.ID), http.StatusSeeOther)
+		return
+	}
+
+	helpers.ClientError(w, http.StatusNotFound)
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+func TestRepository_ICalRoom(t *testing.T) {
+	ts := httptest.NewServer(getRoutes())
+	defer ts.Close()
+
+	resID := makeTestReservation(t, Repo, 2, jan2060.AddDate(0, 0, 40), 2, models.Quote{}).ID
+	night := time.Date(2060, time.March, 1, 0, 0, 0, 0, time.UTC)
+	blockID, err := Repo.DB.InsertBlockForRoom(2, models.RestrictionOwnerBlock, night, night.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := Repo.DB.GetRoomByID(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name         string
+		url          string
+		expectedCode int
+	}{
+		{"valid", "/ical/rooms/2.ics?token=" + room.ICalToken, http.StatusOK},
+		{"no token", "/ical/rooms/2.ics", http.StatusNotFound},
+		{"wrong token", "/ical/rooms/2.ics?token=guess", http.StatusNotFound},
+		{"another room's token", "/ical/rooms/1.ics?token=" + room.ICalToken, http.StatusNotFound},
+		{"missing room", "/ical/rooms/1000.ics?token=" + room.ICalToken, http.StatusNotFound},
+	}
+
+	var body string
+	for _, e := range tests {
+		resp, err := http.Get(ts.URL + e.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, resp.StatusCode)
+		}
+		if e.name == "valid" {
+			body = string(b)
+			if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+				t.Errorf("expected a calendar, got %s", ct)
+			}
+		}
+	}
+
+	res, err := Repo.DB.GetReservationByID(resID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"X-WR-CALNAME:Major's Suite\r\n",
+		"DTSTART;VALUE=DATE:" + res.StartDate.Format("20060102") + "\r\nDTEND;VALUE=DATE:" + res.EndDate.Format("20060102") + "\r\nSUMMARY:Reserved\r\n",
+		"UID:room-restriction-" + strconv.Itoa(blockID) + "@bookings-app\r\n",
+		"DTSTART;VALUE=DATE:20600301\r\nDTEND;VALUE=DATE:20600302\r\nSUMMARY:Not available\r\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the calendar to contain %q, got:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "Jane") {
+		t.Error("expected guests' names to be kept out of the calendar")
+	}
+
+	// the same nights keep the same ids
+	resp, err := http.Get(ts.URL + tests[0].url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if uids(body) != uids(string(again)) {
+		t.Error("expected the event ids to be stable")
+	}
+}
+
+// uids returns the UID lines of a calendar
+func uids(cal string) string {
+	var lines []string
+	for _, l := range strings.Split(cal, "\r\n") {
+		if strings.HasPrefix(l, "UID:") {
+			lines = append(lines, l)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestRepository_AdminPostRoomICalToken(t *testing.T) {
+	before, err := Repo.DB.GetRoomByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []struct {
+		roomID       string
+		expectedCode int
+	}{
+		{"1", http.StatusSeeOther},
+		{"1000", http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.roomID+"/ical-token", nil)
+		req = withURLParams(req.WithContext(getCtx(req)), map[string]string{"id": e.roomID})
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostRoomICalToken).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("room %s: AdminPostRoomICalToken returned wrong response code: got %d, wanted %d", e.roomID, rr.Code, e.expectedCode)
+		}
+	}
+
+	after, err := Repo.DB.GetRoomByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.ICalToken == "" || after.ICalToken == before.ICalToken {
+		t.Errorf("expected a new token, got %q", after.ICalToken)
+	}
+}
+
+func TestRepository_AdminPostRoomCalendars(t *testing.T) {
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:ext-1@othersite.com\r\n" +
+			"DTSTART;VALUE=DATE:20600601\r\nDTEND;VALUE=DATE:20600604\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer feed.Close()
+
+	var tests = []struct {
+		name         string
+		roomID       string
+		feedName     string
+		url          string
+		expectedCode int
+		message      string
+	}{
+		{"valid", "2", "Other Site", feed.URL + "/room.ics", http.StatusSeeOther, "Imported 1 booking(s) from Other Site"},
+		{"unreachable", "2", "Gone Site", "http://127.0.0.1:1/room.ics", http.StatusSeeOther, "Gone Site was added, but could not be imported"},
+		{"missing room", "1000", "Other Site", feed.URL, http.StatusNotFound, ""},
+		{"no name", "2", "", feed.URL, http.StatusOK, ""},
+		{"not a web address", "2", "Other Site", "othersite.com/room.ics", http.StatusOK, ""},
+	}
+
+	for _, e := range tests {
+		values := url.Values{}
+		values.Add("name", e.feedName)
+		values.Add("url", e.url)
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.roomID+"/calendars", strings.NewReader(values.Encode()))
+		ctx := getCtx(req)
+		req = withURLParams(req.WithContext(ctx), map[string]string{"id": e.roomID})
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostRoomCalendars).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: AdminPostRoomCalendars returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedCode)
+		}
+		if e.message != "" {
+			got := session.PopString(ctx, "flash") + session.PopString(ctx, "warning")
+			if !strings.HasPrefix(got, e.message) {
+				t.Errorf("%s: expected the message %q, got %q", e.name, e.message, got)
+			}
+		}
+	}
+
+	available, err := Repo.DB.SearchAvailabilityByDatesByRoomID(
+		time.Date(2060, time.June, 2, 0, 0, 0, 0, time.UTC), time.Date(2060, time.June, 3, 0, 0, 0, 0, time.UTC), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available {
+		t.Error("expected the imported booking to take its nights")
+	}
+
+	feeds, err := Repo.DB.GetICalFeedsForRoom(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feeds) != 2 {
+		t.Fatalf("expected two feeds, got %d", len(feeds))
+	}
+
+	// removing the feed releases its nights, but only from its own room
+	for _, e := range []struct {
+		roomID       string
+		feedID       string
+		expectedCode int
+	}{
+		{"1", strconv.Itoa(feeds[0].ID), http.StatusNotFound},
+		{"2", "abc", http.StatusNotFound},
+		{"2", strconv.Itoa(feeds[0].ID), http.StatusSeeOther},
+	} {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.roomID+"/calendars/"+e.feedID+"/delete", nil)
+		req = withURLParams(req.WithContext(getCtx(req)), map[string]string{"id": e.roomID, "feedID": e.feedID})
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AdminPostDeleteRoomCalendar).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("room %s feed %s: AdminPostDeleteRoomCalendar returned wrong response code: got %d, wanted %d", e.roomID, e.feedID, rr.Code, e.expectedCode)
+		}
+	}
+
+	available, err = Repo.DB.SearchAvailabilityByDatesByRoomID(
+		time.Date(2060, time.June, 2, 0, 0, 0, 0, time.UTC), time.Date(2060, time.June, 3, 0, 0, 0, 0, time.UTC), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available {
+		t.Error("expected removing the feed to release its nights")
+	}
+}
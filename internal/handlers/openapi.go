@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+// apiRoute describes a route of the API for the OpenAPI document. Every route under /api/v1 needs one,
+// which the route tests check.
+type apiRoute struct {
+	Method string
+	// Path is the route's chi pattern, without the /api/v1 prefix
+	Path      string
+	ID        string
+	Summary   string
+	Params    []openAPIParameter
+	Body      interface{}
+	Responses []apiRouteResponse
+}
+
+// apiRouteResponse is one of the responses of an apiRoute. Body is a value of the payload type sent, if any.
+type apiRouteResponse struct {
+	Status      int
+	Description string
+	Body        interface{}
+}
+
+// apiSchemas names the payload types the API sends and receives, as they are named in the OpenAPI document
+var apiSchemas = []struct {
+	Name        string
+	Value       interface{}
+	Description string
+}{
+	{"Room", apiRoom{}, "A room guests can book"},
+	{"Rooms", apiRooms{}, "The rooms"},
+	{"Quote", apiQuote{}, "The price of a stay. Amounts are in cents."},
+	{"Availability", apiAvailability{}, "Whether a room can be booked for some dates, with the price of the stay if it can"},
+	{"ReservationRequest", apiReservationRequest{}, "A booking, checked just as the site's reservation form is"},
+	{"Reservation", apiReservation{}, "A reservation, found by its confirmation code"},
+	{"StatusRequest", apiStatusRequest{}, "A status to move a reservation to, one of " + strings.Join(models.Statuses, ", ")},
+	{"Error", helpers.APIErrorBody{}, "The body of every error"},
+	{"ErrorDetail", helpers.APIErrorDetail{}, "An error. Code is a stable name for it; fields holds the problem with each invalid request field."},
+}
+
+var (
+	notFoundResponse     = apiRouteResponse{http.StatusNotFound, "Not found", helpers.APIErrorBody{}}
+	invalidResponse      = apiRouteResponse{http.StatusUnprocessableEntity, "Some fields are missing or invalid", helpers.APIErrorBody{}}
+	unauthorizedResponse = apiRouteResponse{http.StatusUnauthorized, "The API key is missing or wrong", helpers.APIErrorBody{}}
+	serverErrorResponse  = apiRouteResponse{http.StatusInternalServerError, "Something went wrong on our side", helpers.APIErrorBody{}}
+)
+
+// apiRoutes are the routes of the API
+var apiRoutes = []apiRoute{
+	{
+		Method:  "GET",
+		Path:    "/rooms",
+		ID:      "listRooms",
+		Summary: "List the rooms",
+		Responses: []apiRouteResponse{
+			{http.StatusOK, "The rooms", apiRooms{}},
+		},
+	},
+	{
+		Method:  "GET",
+		Path:    "/rooms/{id}/availability",
+		ID:      "getRoomAvailability",
+		Summary: "Check whether a room is free for some dates, and quote the stay",
+		Params: []openAPIParameter{
+			{Name: "id", In: "path", Required: true, Schema: &openAPISchema{Type: "integer"}},
+			{Name: "start", In: "query", Required: true, Description: "The arrival date", Schema: &openAPISchema{Type: "string", Format: "date"}},
+			{Name: "end", In: "query", Required: true, Description: "The departure date", Schema: &openAPISchema{Type: "string", Format: "date"}},
+		},
+		Responses: []apiRouteResponse{
+			{http.StatusOK, "Whether the room is free", apiAvailability{}},
+			notFoundResponse,
+			invalidResponse,
+		},
+	},
+	{
+		Method:  "POST",
+		Path:    "/reservations",
+		ID:      "createReservation",
+		Summary: "Book a room",
+		Body:    apiReservationRequest{},
+		Responses: []apiRouteResponse{
+			{http.StatusCreated, "The new reservation, with its confirmation code", apiReservation{}},
+			{http.StatusBadRequest, "The body isn't a JSON reservation", helpers.APIErrorBody{}},
+			{http.StatusConflict, "The room is not available for those dates", helpers.APIErrorBody{}},
+			invalidResponse,
+		},
+	},
+	{
+		Method:  "GET",
+		Path:    "/reservations/{code}",
+		ID:      "getReservation",
+		Summary: "Show a reservation",
+		Params: []openAPIParameter{
+			{Name: "code", In: "path", Required: true, Description: "The confirmation code, in any case", Schema: &openAPISchema{Type: "string"}},
+		},
+		Responses: []apiRouteResponse{
+			{http.StatusOK, "The reservation", apiReservation{}},
+			notFoundResponse,
+		},
+	},
+	{
+		Method:  "POST",
+		Path:    "/reservations/{code}/status",
+		ID:      "updateReservationStatus",
+		Summary: "Cancel a reservation booked with the same API key",
+		Params: []openAPIParameter{
+			{Name: "code", In: "path", Required: true, Description: "The confirmation code, in any case", Schema: &openAPISchema{Type: "string"}},
+		},
+		Body: apiStatusRequest{},
+		Responses: []apiRouteResponse{
+			{http.StatusOK, "The moved reservation", apiReservation{}},
+			{http.StatusBadRequest, "The body isn't a JSON status", helpers.APIErrorBody{}},
+			{http.StatusForbidden, "The reservation wasn't booked with this API key, or the status isn't cancelled", helpers.APIErrorBody{}},
+			notFoundResponse,
+			{http.StatusConflict, "The reservation can't move to that status from its own", helpers.APIErrorBody{}},
+			invalidResponse,
+		},
+	},
+}
+
+// openAPIDocument is an OpenAPI 3 document, with only the parts the API needs
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Servers    []openAPIServer                         `json:"servers"`
+	Security   []map[string][]string                   `json:"security"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Required    bool           `json:"required"`
+	Description string         `json:"description,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema        `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme"`
+	Description string `json:"description"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+}
+
+// OpenAPI sends the OpenAPI document describing the API
+func (m *Repository) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSONStatus(w, http.StatusOK, newOpenAPIDocument())
+}
+
+// newOpenAPIDocument describes apiRoutes, with the schemas of their payloads worked out from the payload
+// types, so the document can't drift from what the handlers send
+func newOpenAPIDocument() openAPIDocument {
+	doc := openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       "Bookings API",
+			Version:     "1",
+			Description: "Lets partner sites find and book rooms. Dates are yyyy-mm-dd.",
+		},
+		Servers:  []openAPIServer{{URL: "/api/v1"}},
+		Security: []map[string][]string{{"apiKey": {}}},
+		Paths:    make(map[string]map[string]*openAPIOperation),
+		Components: openAPIComponents{
+			Schemas: make(map[string]*openAPISchema),
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"apiKey": {Type: "http", Scheme: "bearer", Description: "One of the keys in API_KEYS"},
+			},
+		},
+	}
+
+	for _, s := range apiSchemas {
+		schema := schemaFor(reflect.TypeOf(s.Value), false)
+		schema.Description = s.Description
+		doc.Components.Schemas[s.Name] = schema
+	}
+
+	for _, route := range apiRoutes {
+		op := &openAPIOperation{
+			OperationID: route.ID,
+			Summary:     route.Summary,
+			Parameters:  route.Params,
+			Responses:   make(map[string]openAPIResponse),
+		}
+		if route.Body != nil {
+			op.RequestBody = &openAPIRequestBody{
+				Required: true,
+				Content:  jsonContent(route.Body),
+			}
+		}
+
+		// every route needs a key, and any of them can fail
+		var responses []apiRouteResponse
+		responses = append(responses, route.Responses...)
+		responses = append(responses, unauthorizedResponse, serverErrorResponse)
+		for _, resp := range responses {
+			r := openAPIResponse{Description: resp.Description}
+			if resp.Body != nil {
+				r.Content = jsonContent(resp.Body)
+			}
+			op.Responses[strconv.Itoa(resp.Status)] = r
+		}
+
+		if doc.Paths[route.Path] == nil {
+			doc.Paths[route.Path] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[route.Path][strings.ToLower(route.Method)] = op
+	}
+
+	return doc
+}
+
+// jsonContent is the content of a request or response whose body is a value of body's type
+func jsonContent(body interface{}) map[string]openAPIMediaType {
+	return map[string]openAPIMediaType{
+		"application/json": {Schema: schemaFor(reflect.TypeOf(body), true)},
+	}
+}
+
+// schemaFor works out the schema of a payload type from its json tags. Structs named in apiSchemas are
+// referred to by name when ref is set. A field is required unless it is omitempty, and a `format` tag
+// gives a string's format, such as date.
+func schemaFor(t reflect.Type, ref bool) *openAPISchema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem(), true)
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Int:
+		return &openAPISchema{Type: "integer"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Slice:
+		return &openAPISchema{Type: "array", Items: schemaFor(t.Elem(), true)}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: schemaFor(t.Elem(), true)}
+	case reflect.Struct:
+		if ref {
+			return &openAPISchema{Ref: "#/components/schemas/" + schemaName(t)}
+		}
+	default:
+		panic(fmt.Sprintf("openapi: no schema for %s", t))
+	}
+
+	schema := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "" || tag[0] == "-" {
+			continue
+		}
+
+		prop := schemaFor(field.Type, true)
+		prop.Format = field.Tag.Get("format")
+		schema.Properties[tag[0]] = prop
+
+		if len(tag) < 2 || tag[1] != "omitempty" {
+			schema.Required = append(schema.Required, tag[0])
+		}
+	}
+	sort.Strings(schema.Required)
+
+	return schema
+}
+
+// schemaName returns the name apiSchemas gives the struct type t
+func schemaName(t reflect.Type) string {
+	for _, s := range apiSchemas {
+		if reflect.TypeOf(s.Value) == t {
+			return s.Name
+		}
+	}
+	panic(fmt.Sprintf("openapi: %s is not in apiSchemas", t))
+}
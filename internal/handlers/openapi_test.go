@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// refsIn collects the $ref values anywhere in a decoded JSON value
+func refsIn(v interface{}, refs *[]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if s, ok := child.(string); ok && k == "$ref" {
+				*refs = append(*refs, s)
+			}
+			refsIn(child, refs)
+		}
+	case []interface{}:
+		for _, child := range v {
+			refsIn(child, refs)
+		}
+	}
+}
+
+func TestRepository_OpenAPI(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/openapi.json", nil)
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.OpenAPI).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a JSON document, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	var refs []string
+	refsIn(raw, &refs)
+	schemas := raw["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for _, ref := range refs {
+		if _, ok := schemas[strings.TrimPrefix(ref, "#/components/schemas/")]; !ok {
+			t.Errorf("%s doesn't refer to a schema", ref)
+		}
+	}
+
+	var doc openAPIDocument
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[string]bool)
+	for path, operations := range doc.Paths {
+		for method, op := range operations {
+			if ids[op.OperationID] {
+				t.Errorf("%s %s: operation id %s is used twice", method, path, op.OperationID)
+			}
+			ids[op.OperationID] = true
+			if _, ok := op.Responses["401"]; !ok {
+				t.Errorf("%s %s: expected the missing key response", method, path)
+			}
+		}
+	}
+
+	reservation := doc.Components.Schemas["Reservation"]
+	if reservation == nil || reservation.Properties["start_date"].Format != "date" || reservation.Properties["quote"].Ref != "#/components/schemas/Quote" {
+		t.Errorf("expected the Reservation schema to follow apiReservation, got %+v", reservation)
+	}
+	request := doc.Components.Schemas["ReservationRequest"]
+	if request == nil || strings.Contains(strings.Join(request.Required, ","), "phone") {
+		t.Errorf("expected the phone number to be optional in a ReservationRequest, got %+v", request)
+	}
+}
@@ -8,22 +8,37 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"testing"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/justinas/nosurf"
+	"github.com/tsawler/bookings-app/internal/booking"
 	"github.com/tsawler/bookings-app/internal/config"
-	driverDef "github.com/tsawler/bookings-app/internal/driver"
+	"github.com/tsawler/bookings-app/internal/helpers"
 	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
 	"github.com/tsawler/bookings-app/internal/render"
+	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
 )
 
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":   render.HumanDate,
+	"formatDate":  render.FormatDate,
+	"hasRole":     models.HasRole,
+	"formatMoney": pricing.FormatAmount,
+}
+
+func TestMain(m *testing.M) {
+	getRoutes()
+
+	os.Exit(m.Run())
+}
 
 func getRoutes() http.Handler {
 	// what am I going to put in the session
@@ -55,13 +70,18 @@ func getRoutes() http.Handler {
 	app.TemplateCache = tc
 	app.UseCache = true
 
-	// @todo add a real DB reference
-	dbRepo := driverDef.DB{}
+	app.Pricing = pricing.Policy{TaxRate: 1000, StayFee: 2500}
+	app.PhoneRegion = "US"
 
-	repo := NewRepo(&app, &dbRepo)
+	app.OwnerEmail = "owner@here.com"
+	app.Cancellation = booking.Policy{FreeDays: 2}
+
+	// bookings for room 1000 fail, so tests can reach the database error branches
+	repo := NewRepo(&app, dbrepo.NewMemoryRepo(&app, dbrepo.Failure{Method: "InsertReservation", RoomID: 1000}))
 	NewHandlers(repo)
 
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app, nil, nil)
 
 	mux := chi.NewRouter()
 
@@ -71,12 +91,15 @@ func getRoutes() http.Handler {
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
 	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
+	mux.Get("/choose-room/{id}", Repo.ChooseRoom)
 
 	mux.Get("/contact", Repo.Contact)
 
@@ -84,12 +107,64 @@ func getRoutes() http.Handler {
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	mux.Get("/manage-booking", Repo.ManageBooking)
+	mux.Post("/manage-booking", Repo.PostManageBooking)
+	mux.Get("/manage-booking/details", Repo.ManageBookingDetails)
+	mux.Post("/manage-booking/dates", Repo.PostManageBookingDates)
+	mux.Post("/manage-booking/cancel", Repo.PostManageBookingCancel)
+
+	mux.Get("/ical/rooms/{id}.ics", Repo.ICalRoom)
+
+	mux.Get("/user/login", Repo.ShowLogin)
+	mux.Post("/user/login", Repo.PostShowLogin)
+	mux.Get("/user/logout", Repo.Logout)
+
+	mux.Get("/admin/dashboard", Repo.AdminDashboard)
+	mux.Get("/admin/reservations-new", Repo.AdminNewReservations)
+	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
+	mux.Get("/admin/reservations/{src}/{id}", Repo.AdminShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}/status", Repo.AdminPostReservationStatus)
+	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
+	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
+	mux.Get("/admin/outbox", Repo.AdminOutbox)
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/new", Repo.AdminNewRoom)
+	mux.Post("/admin/rooms/new", Repo.AdminPostNewRoom)
+	mux.Get("/admin/rooms/{id}/rules", Repo.AdminRoomRules)
+	mux.Post("/admin/rooms/{id}/rules", Repo.AdminPostRoomRules)
+	mux.Post("/admin/rooms/{id}/ical-token", Repo.AdminPostRoomICalToken)
+	mux.Get("/admin/rooms/{id}/calendars", Repo.AdminRoomCalendars)
+	mux.Post("/admin/rooms/{id}/calendars", Repo.AdminPostRoomCalendars)
+	mux.Post("/admin/rooms/{id}/calendars/{feedID}/delete", Repo.AdminPostDeleteRoomCalendar)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
+	mux.Get("/api/openapi.json", Repo.OpenAPI)
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Use(testAPIPartner)
+		mux.NotFound(Repo.APINotFound)
+		mux.MethodNotAllowed(Repo.APIMethodNotAllowed)
+
+		mux.Get("/rooms", Repo.APIRooms)
+		mux.Get("/rooms/{id}/availability", Repo.APIRoomAvailability)
+		mux.Post("/reservations", Repo.APIPostReservation)
+		mux.Get("/reservations/{code}", Repo.APIReservation)
+		mux.Post("/reservations/{code}/status", Repo.APIPostReservationStatus)
+	})
+
 	return mux
 }
 
+// testAPIPartner stands in for the API key check, taking the partner that made a request from its
+// X-Partner header
+func testAPIPartner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, helpers.WithAPIPartner(r, r.Header.Get("X-Partner")))
+	})
+}
+
 // NoSurf is the csrf protection middleware
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
@@ -140,5 +215,25 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 		myCache[name] = ts
 	}
 
+	mails, err := filepath.Glob(fmt.Sprintf("%s/email/*.mail.tmpl", pathToTemplates))
+	if err != nil {
+		return myCache, err
+	}
+
+	for _, mail := range mails {
+		name := filepath.Base(mail)
+		ts, err := template.New(name).Funcs(functions).ParseFiles(mail)
+		if err != nil {
+			return myCache, err
+		}
+
+		ts, err = ts.ParseGlob(fmt.Sprintf("%s/email/*.layout.tmpl", pathToTemplates))
+		if err != nil {
+			return myCache, err
+		}
+
+		myCache[name] = ts
+	}
+
 	return myCache, nil
 }
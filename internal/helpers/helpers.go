@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +11,8 @@ import (
 	"runtime/debug"
 
 	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/render"
 )
 
 var app *config.AppConfig
@@ -34,6 +37,17 @@ func ClientError(w http.ResponseWriter, code int) {
 	http.Error(w, http.StatusText(code), code)
 }
 
+// Forbidden logs a refused request and renders the 403 page
+func Forbidden(w http.ResponseWriter, r *http.Request) {
+	app.InfoLog.Printf("%s STATUS=%d PATH=%s", http.StatusText(http.StatusForbidden), http.StatusForbidden, r.URL.Path)
+
+	w.WriteHeader(http.StatusForbidden)
+	err := render.Template(w, r, "403.page.tmpl", &models.TemplateData{})
+	if err != nil {
+		fmt.Fprintln(w, http.StatusText(http.StatusForbidden))
+	}
+}
+
 // ServerError logs an internal error
 func ServerError(w http.ResponseWriter, err error) {
 	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
@@ -41,8 +55,66 @@ func ServerError(w http.ResponseWriter, err error) {
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
+// APIErrorBody is the JSON body of every error the API returns. Fields holds the message for each
+// request field that failed validation.
+type APIErrorBody struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+// APIErrorDetail describes an API error. Code is a stable, machine readable name for it, such as not_found.
+type APIErrorDetail struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// APIError logs a client error from the API and sends it as JSON
+func APIError(w http.ResponseWriter, status int, code, message string, fields map[string]string) {
+	app.InfoLog.Printf("%s STATUS=%d CODE=%s", http.StatusText(status), status, code)
+	writeAPIError(w, status, APIErrorBody{APIErrorDetail{Code: code, Message: message, Fields: fields}})
+}
+
+// APIServerError logs an internal error behind an API request and sends a JSON error that leaves out the details
+func APIServerError(w http.ResponseWriter, err error) {
+	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
+	app.ErrorLog.Println(trace)
+	writeAPIError(w, http.StatusInternalServerError, APIErrorBody{APIErrorDetail{
+		Code:    "server_error",
+		Message: "Something went wrong on our side, please try again later",
+	}})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, body APIErrorBody) {
+	out, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// contextKey is the type of the keys helpers keeps values in request contexts under
+type contextKey string
+
+// apiPartnerKey is the context key of the partner that made an API request
+const apiPartnerKey contextKey = "api_partner"
+
+// WithAPIPartner returns r as made by partner, identified by the API key it was made with
+func WithAPIPartner(r *http.Request, partner string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), apiPartnerKey, partner))
+}
+
+// APIPartner is the partner that made an API request, or empty if the request has no API key
+func APIPartner(r *http.Request) string {
+	partner, _ := r.Context().Value(apiPartnerKey).(string)
+	return partner
+}
+
 // PrintStruct turns a struct into json and prints it.
 func PrintStruct(item interface{}) {
 	data, _ := json.MarshalIndent(item, "", "    ")
 	fmt.Println(string(data))
 }
+
+// IsAuthenticated reports whether a user is logged in on this session
+func IsAuthenticated(r *http.Request) bool {
+	return app.Session.Exists(r.Context(), "user_id")
+}
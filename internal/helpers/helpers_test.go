@@ -2,6 +2,7 @@ package helpers
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -12,6 +13,7 @@ import (
 	"testing"
 
 	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/render"
 )
 
 // @see https://stackoverflow.com/questions/10473800/in-go-how-do-i-capture-stdout-of-a-function-into-a-string
@@ -119,3 +121,60 @@ func TestSubstituteBufferForErrors(t *testing.T) {
 		t.Error("error entry did not have expected error txt in its buffer")
 	}
 }
+
+func TestForbidden(t *testing.T) {
+	buf := new(bytes.Buffer)
+	recorder := httptest.NewRecorder()
+	appObj := config.AppConfig{}
+	NewHelpers(&appObj, buf, nil)
+	render.NewRenderer(&appObj)
+
+	req := httptest.NewRequest("GET", "/admin/secret", nil)
+	Forbidden(recorder, req)
+
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expected status %d but got %d", http.StatusForbidden, recorder.Code)
+	}
+	if !strings.Contains(buf.String(), "/admin/secret") {
+		t.Error("expected the refused path to be logged")
+	}
+	// there are no templates here, so we should get the plain text fallback
+	if !strings.Contains(recorder.Body.String(), http.StatusText(http.StatusForbidden)) {
+		t.Error("expected Forbidden in the body")
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	appObj := config.AppConfig{}
+	NewHelpers(&appObj, new(bytes.Buffer), nil)
+
+	APIError(recorder, http.StatusUnprocessableEntity, "invalid_request", "Some fields are invalid", map[string]string{"email": "Invalid email address"})
+
+	if recorder.Code != http.StatusUnprocessableEntity || recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected a 422 JSON response, got %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	var body APIErrorBody
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != "invalid_request" || body.Error.Fields["email"] != "Invalid email address" {
+		t.Errorf("unexpected error body %+v", body)
+	}
+}
+
+func TestAPIServerError(t *testing.T) {
+	buf := new(bytes.Buffer)
+	recorder := httptest.NewRecorder()
+	appObj := config.AppConfig{}
+	NewHelpers(&appObj, nil, buf)
+
+	APIServerError(recorder, errors.New("connection refused"))
+
+	if !strings.Contains(buf.String(), "connection refused") {
+		t.Error("expected the error to be logged")
+	}
+	if recorder.Code != http.StatusInternalServerError || strings.Contains(recorder.Body.String(), "connection refused") {
+		t.Errorf("expected a 500 that keeps the error to itself, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
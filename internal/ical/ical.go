@@ -0,0 +1,95 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// dateLayout is how all-day dates are written, as in DTSTART;VALUE=DATE:20211231
+const dateLayout = "20060102"
+
+// stampLayout is how moments in UTC are written, as in DTSTAMP:20211231T120000Z
+const stampLayout = "20060102T150405Z"
+
+// maxLine is the longest a line may be, in octets, before it is folded
+const maxLine = 75
+
+// Event is an all-day event, covering the nights from Start up to, but not including, End
+type Event struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+}
+
+// Calendar is a named list of events
+type Calendar struct {
+	ProdID string
+	Name   string
+	Events []Event
+}
+
+// Write writes the calendar in iCalendar form, stamping each event with stamp
+func (c Calendar) Write(w io.Writer, stamp time.Time) error {
+	b := bufio.NewWriter(w)
+
+	line := func(name, value string) {
+		writeLine(b, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", c.ProdID)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME", escape(c.Name))
+	}
+
+	for _, e := range c.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", e.UID)
+		line("DTSTAMP", stamp.UTC().Format(stampLayout))
+		line("DTSTART;VALUE=DATE", e.Start.Format(dateLayout))
+		line("DTEND;VALUE=DATE", e.End.Format(dateLayout))
+		line("SUMMARY", escape(e.Summary))
+		line("TRANSP", "OPAQUE")
+		line("END", "VEVENT")
+	}
+
+	line("END", "VCALENDAR")
+
+	return b.Flush()
+}
+
+// writeLine ends a content line with CRLF, folding it so that no line is longer than maxLine octets
+func writeLine(b *bufio.Writer, s string) {
+	limit := maxLine
+	for len(s) > limit {
+		// don't split a multi-byte character
+		cut := limit
+		for cut > 0 && !startsRune(s[cut]) {
+			cut--
+		}
+		fmt.Fprintf(b, "%s\r\n ", s[:cut])
+		s = s[cut:]
+		// continuation lines start with a space, which counts towards their length
+		limit = maxLine - 1
+	}
+	fmt.Fprintf(b, "%s\r\n", s)
+}
+
+// startsRune reports whether c is the first byte of a UTF-8 character
+func startsRune(c byte) bool {
+	return c&0xC0 != 0x80
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// escape escapes a TEXT value
+func escape(s string) string {
+	return escaper.Replace(s)
+}
@@ -0,0 +1,75 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendar_Write(t *testing.T) {
+	c := Calendar{
+		ProdID: "-//Test//Test//EN",
+		Name:   "Major's Suite, upstairs",
+		Events: []Event{
+			{
+				UID:     "room-restriction-7@bookings",
+				Summary: "Reserved",
+				Start:   time.Date(2050, time.December, 31, 0, 0, 0, 0, time.UTC),
+				End:     time.Date(2051, time.January, 2, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	stamp := time.Date(2021, time.September, 20, 8, 30, 0, 0, time.FixedZone("EST", -5*3600))
+	if err := c.Write(&buf, stamp); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//Test//EN\r\n",
+		"X-WR-CALNAME:Major's Suite\\, upstairs\r\n",
+		"BEGIN:VEVENT\r\nUID:room-restriction-7@bookings\r\n",
+		"DTSTAMP:20210920T133000Z\r\n",
+		"DTSTART;VALUE=DATE:20501231\r\n",
+		"DTEND;VALUE=DATE:20510102\r\n",
+		"SUMMARY:Reserved\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected the calendar to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriteLine(t *testing.T) {
+	var buf bytes.Buffer
+	long := "SUMMARY:" + strings.Repeat("é", 60)
+	c := Calendar{Events: []Event{{Summary: strings.TrimPrefix(long, "SUMMARY:")}}}
+	if err := c.Write(&buf, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	var unfolded strings.Builder
+	for i, l := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(l) > maxLine {
+			t.Errorf("line %d is %d octets long", i, len(l))
+		}
+		if strings.HasPrefix(l, " ") {
+			unfolded.WriteString(l[1:])
+		} else {
+			unfolded.WriteString("\n" + l)
+		}
+	}
+	if !strings.Contains(unfolded.String(), "\n"+long+"\n") {
+		t.Errorf("expected the folded summary to unfold to the original, got:\n%s", unfolded.String())
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if got := escape("a,b;c\\d\ne"); got != `a\,b\;c\\d\ne` {
+		t.Errorf("unexpected escaping %q", got)
+	}
+}
@@ -0,0 +1,125 @@
+package ical
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// maxFeedSize is the most that is read of an external calendar
+const maxFeedSize = 10 << 20
+
+// Importer keeps the external bookings of each room in step with the calendars of the other sites
+// the room is listed on
+type Importer struct {
+	DB       repository.DatabaseRepo
+	Client   *http.Client
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Interval time.Duration
+}
+
+// Run imports every feed each Interval until stop is closed
+func (im *Importer) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(im.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := im.SyncAll(); err != nil {
+			im.ErrorLog.Println(err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncAll imports every feed. A feed that fails doesn't hold up the others; its error is logged and
+// kept with the feed.
+func (im *Importer) SyncAll() error {
+	feeds, err := im.DB.AllICalFeeds()
+	if err != nil {
+		return err
+	}
+
+	for _, feed := range feeds {
+		if _, err := im.Sync(feed); err != nil {
+			im.ErrorLog.Printf("importing calendar %d for room %d: %s", feed.ID, feed.RoomID, err)
+		}
+	}
+
+	return nil
+}
+
+// Sync imports a feed, blocking the nights of its bookings and releasing those of bookings it no longer has
+func (im *Importer) Sync(feed models.ICalFeed) (models.ICalSyncResult, error) {
+	bookings, err := im.fetch(feed.URL)
+	if err != nil {
+		if recordErr := im.DB.RecordICalFeedError(feed.ID, err.Error()); recordErr != nil {
+			im.ErrorLog.Println(recordErr)
+		}
+		return models.ICalSyncResult{}, err
+	}
+
+	result, err := im.DB.SyncICalFeed(feed.ID, bookings)
+	if err != nil {
+		return result, err
+	}
+
+	if len(result.Conflicts) > 0 {
+		msg := fmt.Sprintf("%d booking(s) overlap nights already taken here: %s", len(result.Conflicts), strings.Join(result.Conflicts, ", "))
+		if err := im.DB.RecordICalFeedError(feed.ID, msg); err != nil {
+			return result, err
+		}
+	}
+
+	if im.InfoLog != nil && (result.Added > 0 || result.Updated > 0 || result.Removed > 0) {
+		im.InfoLog.Printf("calendar %d for room %d: %d added, %d updated, %d removed", feed.ID, feed.RoomID, result.Added, result.Updated, result.Removed)
+	}
+
+	return result, nil
+}
+
+// fetch downloads and parses a feed
+func (im *Importer) fetch(url string) ([]models.ExternalBooking, error) {
+	client := im.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	events, err := Parse(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var bookings []models.ExternalBooking
+	for _, e := range events {
+		bookings = append(bookings, models.ExternalBooking{
+			UID:       e.UID,
+			Summary:   e.Summary,
+			StartDate: e.Start,
+			EndDate:   e.End,
+		})
+	}
+
+	return bookings, nil
+}
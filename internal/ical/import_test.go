@@ -0,0 +1,251 @@
+package ical
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
+)
+
+// fixtureFeed serves a fixture from testdata, which can be switched while it is running
+type fixtureFeed struct {
+	mu     sync.Mutex
+	status int
+	body   []byte
+}
+
+func (f *fixtureFeed) serve(t *testing.T, status int, fixture string) {
+	body := []byte(fixture)
+	if strings.HasSuffix(fixture, ".ics") {
+		var err error
+		if body, err = ioutil.ReadFile("testdata/" + fixture); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+	f.body = body
+}
+
+func (f *fixtureFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "text/calendar")
+	w.WriteHeader(f.status)
+	w.Write(f.body)
+}
+
+// externalBookings returns the nights of room 1 imported from other sites
+func externalBookings(t *testing.T, db repository.DatabaseRepo) []models.RoomRestriction {
+	restrictions, err := db.GetRestrictionsForRoom(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var external []models.RoomRestriction
+	for _, rr := range restrictions {
+		if rr.RestrictionID == models.RestrictionExternal {
+			external = append(external, rr)
+		}
+	}
+	return external
+}
+
+func newImporter(t *testing.T) (*Importer, *fixtureFeed, models.ICalFeed, func()) {
+	feed := &fixtureFeed{}
+	feed.serve(t, http.StatusOK, "feed.ics")
+	ts := httptest.NewServer(feed)
+
+	var logged bytes.Buffer
+	im := &Importer{
+		DB:       dbrepo.NewMemoryRepo(&config.AppConfig{}),
+		Client:   ts.Client(),
+		ErrorLog: log.New(&logged, "", 0),
+		Interval: time.Minute,
+	}
+
+	f := models.ICalFeed{RoomID: 1, Name: "Other Site", URL: ts.URL + "/calendar.ics"}
+	id, err := im.DB.InsertICalFeed(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.ID = id
+
+	return im, feed, f, ts.Close
+}
+
+func TestImporter_Sync(t *testing.T) {
+	im, feed, f, closeFeed := newImporter(t)
+	defer closeFeed()
+
+	result, err := im.Sync(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 3 || result.Updated != 0 || result.Removed != 0 {
+		t.Errorf("expected 3 bookings to be added, got %+v", result)
+	}
+
+	external := externalBookings(t, im.DB)
+	if len(external) != 3 {
+		t.Fatalf("expected 3 external bookings, got %d", len(external))
+	}
+	first := external[0]
+	if first.ExternalUID != "booking-1@othersite.com" || !first.StartDate.Equal(date(2050, time.March, 10)) ||
+		!first.EndDate.Equal(date(2050, time.March, 13)) || first.Restriction.RestrictionName != "External booking" {
+		t.Errorf("unexpected external booking %+v", first)
+	}
+
+	available, err := im.DB.SearchAvailabilityByDatesByRoomID(date(2050, time.March, 21), date(2050, time.March, 22), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available {
+		t.Error("expected the imported nights to be taken")
+	}
+
+	// importing the same feed again changes nothing
+	result, err = im.Sync(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 0 || result.Updated != 0 || result.Removed != 0 {
+		t.Errorf("expected no changes, got %+v", result)
+	}
+
+	// the first booking moves a night later, the second is deleted, the third is unchanged
+	feed.serve(t, http.StatusOK, "feed-updated.ics")
+	result, err = im.Sync(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 0 || result.Updated != 1 || result.Removed != 1 {
+		t.Errorf("expected one booking to move and one to go, got %+v", result)
+	}
+
+	external = externalBookings(t, im.DB)
+	if len(external) != 2 || external[0].ID != first.ID || !external[0].StartDate.Equal(date(2050, time.March, 11)) {
+		t.Errorf("expected the moved booking to keep its row, got %+v", external)
+	}
+
+	available, err = im.DB.SearchAvailabilityByDatesByRoomID(date(2050, time.March, 21), date(2050, time.March, 22), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available {
+		t.Error("expected the deleted booking's nights to be released")
+	}
+
+	feeds, err := im.DB.GetICalFeedsForRoom(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feeds) != 1 || feeds[0].LastSyncedAt.IsZero() || feeds[0].LastError != "" {
+		t.Errorf("expected the feed to be marked as synced, got %+v", feeds)
+	}
+}
+
+func TestImporter_SyncConflict(t *testing.T) {
+	im, _, f, closeFeed := newImporter(t)
+	defer closeFeed()
+
+	// a guest booked here first
+	_, err := im.DB.CreateReservation(models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@here.com",
+		RoomID:    1,
+		StartDate: date(2050, time.March, 12),
+		EndDate:   date(2050, time.March, 14),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := im.Sync(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 2 || len(result.Conflicts) != 1 || result.Conflicts[0] != "booking-1@othersite.com" {
+		t.Errorf("expected the overlapping booking to be reported, got %+v", result)
+	}
+
+	feeds, _ := im.DB.GetICalFeedsForRoom(1)
+	if !strings.Contains(feeds[0].LastError, "booking-1@othersite.com") {
+		t.Errorf("expected the conflict to be kept with the feed, got %q", feeds[0].LastError)
+	}
+}
+
+func TestImporter_SyncErrors(t *testing.T) {
+	im, feed, f, closeFeed := newImporter(t)
+	defer closeFeed()
+
+	if _, err := im.Sync(f); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"server error", http.StatusInternalServerError, "oops"},
+		{"not a calendar", http.StatusOK, "<html>Please log in</html>"},
+		{"cut off", http.StatusOK, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"},
+	}
+
+	for _, e := range tests {
+		feed.serve(t, e.status, e.body)
+		if _, err := im.Sync(f); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+
+		// a feed that can't be read must not release the nights it booked
+		if n := len(externalBookings(t, im.DB)); n != 3 {
+			t.Errorf("%s: expected the bookings to be kept, got %d", e.name, n)
+		}
+		feeds, _ := im.DB.GetICalFeedsForRoom(1)
+		if feeds[0].LastError == "" {
+			t.Errorf("%s: expected the error to be kept with the feed", e.name)
+		}
+	}
+}
+
+func TestImporter_Run(t *testing.T) {
+	im, _, _, closeFeed := newImporter(t)
+	defer closeFeed()
+
+	// a feed that can't be reached doesn't stop the others
+	if _, err := im.DB.InsertICalFeed(models.ICalFeed{RoomID: 2, Name: "Gone", URL: "http://127.0.0.1:1/gone.ics"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		im.Run(stop)
+		close(stopped)
+	}()
+	close(stop)
+
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the importer to stop")
+	}
+	if n := len(externalBookings(t, im.DB)); n != 3 {
+		t.Errorf("expected the feed to be imported before stopping, got %d bookings", n)
+	}
+}
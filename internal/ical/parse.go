@@ -0,0 +1,231 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotCalendar is returned when asked to parse something that isn't an iCalendar, such as an error page
+var ErrNotCalendar = errors.New("not an iCalendar file")
+
+// property is a content line, split into its name, parameters and value
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse reads the events of a calendar as all-day events. Times are dropped, so an event from 15:00 on
+// one day to 11:00 on another covers the nights in between. Cancelled events and events without a UID
+// are left out.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, ErrNotCalendar
+	}
+
+	var events []Event
+	var props []property
+	inEvent := false
+	ended := false
+
+	for n, line := range lines {
+		p, err := parseProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+
+		switch {
+		case p.name == "BEGIN" && strings.EqualFold(p.value, "VEVENT"):
+			inEvent = true
+			props = nil
+		case p.name == "END" && strings.EqualFold(p.value, "VEVENT"):
+			if !inEvent {
+				return nil, fmt.Errorf("line %d: END:VEVENT without BEGIN:VEVENT", n+1)
+			}
+			inEvent = false
+			e, ok, err := eventFrom(props)
+			if err != nil {
+				return nil, fmt.Errorf("event ending on line %d: %w", n+1, err)
+			}
+			if ok {
+				events = append(events, e)
+			}
+		case p.name == "END" && strings.EqualFold(p.value, "VCALENDAR"):
+			ended = true
+		case inEvent:
+			props = append(props, p)
+		}
+	}
+
+	// a calendar that was cut off might be missing events, which would be taken as cancelled
+	if !ended || inEvent {
+		return nil, errors.New("calendar is incomplete")
+	}
+
+	return events, nil
+}
+
+// unfold reads the content lines of a calendar, joining folded lines back together
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) == 0 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseProperty splits a content line such as DTSTART;VALUE=DATE:20211231
+func parseProperty(line string) (property, error) {
+	// the value starts after the first colon that isn't inside a quoted parameter
+	colon := -1
+	quoted := false
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		}
+		if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return property{}, fmt.Errorf("no value in %q", line)
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	p := property{
+		name:   strings.ToUpper(parts[0]),
+		params: make(map[string]string),
+		value:  line[colon+1:],
+	}
+	for _, param := range parts[1:] {
+		if i := strings.Index(param, "="); i > 0 {
+			p.params[strings.ToUpper(param[:i])] = strings.Trim(param[i+1:], `"`)
+		}
+	}
+
+	return p, nil
+}
+
+// eventFrom makes an event of a VEVENT's properties, reporting false for events to leave out
+func eventFrom(props []property) (Event, bool, error) {
+	var e Event
+	var duration string
+	cancelled := false
+
+	for _, p := range props {
+		var err error
+		switch p.name {
+		case "UID":
+			e.UID = p.value
+		case "SUMMARY":
+			e.Summary = unescape(p.value)
+		case "STATUS":
+			cancelled = strings.EqualFold(p.value, "CANCELLED")
+		case "DTSTART":
+			e.Start, err = parseDate(p.value)
+		case "DTEND":
+			e.End, err = parseDate(p.value)
+		case "DURATION":
+			duration = p.value
+		}
+		if err != nil {
+			return e, false, fmt.Errorf("%s: %w", p.name, err)
+		}
+	}
+
+	if cancelled || e.UID == "" {
+		return e, false, nil
+	}
+	if e.Start.IsZero() {
+		return e, false, errors.New("no DTSTART")
+	}
+
+	if e.End.IsZero() && duration != "" {
+		days, err := parseDays(duration)
+		if err != nil {
+			return e, false, fmt.Errorf("DURATION: %w", err)
+		}
+		e.End = e.Start.AddDate(0, 0, days)
+	}
+
+	// an event without an end, or ending on the day it starts, still takes its night
+	if !e.End.After(e.Start) {
+		e.End = e.Start.AddDate(0, 0, 1)
+	}
+
+	return e, true, nil
+}
+
+// parseDate reads a DATE or DATE-TIME value as the date it falls on, at midnight UTC
+func parseDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("bad date %q", value)
+	}
+	return time.Parse(dateLayout, value[:8])
+}
+
+// parseDays reads a DURATION, such as P3D or P1W, in whole days
+func parseDays(value string) (int, error) {
+	v := strings.TrimPrefix(strings.ToUpper(value), "+")
+	if !strings.HasPrefix(v, "P") {
+		return 0, fmt.Errorf("bad duration %q", value)
+	}
+	v = v[1:]
+
+	// any hours or minutes make no difference to the nights
+	if i := strings.Index(v, "T"); i >= 0 {
+		v = v[:i]
+	}
+
+	days := 0
+	for v != "" {
+		i := strings.IndexAny(v, "DW")
+		if i <= 0 {
+			return 0, fmt.Errorf("bad duration %q", value)
+		}
+		n, err := strconv.Atoi(v[:i])
+		if err != nil {
+			return 0, fmt.Errorf("bad duration %q", value)
+		}
+		if v[i] == 'W' {
+			n *= 7
+		}
+		days += n
+		v = v[i+1:]
+	}
+
+	return days, nil
+}
+
+var unescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+// unescape reverses escape
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
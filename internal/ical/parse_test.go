@@ -0,0 +1,121 @@
+package ical
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	f, err := os.Open("testdata/feed.ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	events, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Event{
+		{UID: "booking-1@othersite.com", Summary: "Reserved", Start: date(2050, time.March, 10), End: date(2050, time.March, 13)},
+		{UID: "booking-2@othersite.com", Summary: "Not available, owner stay", Start: date(2050, time.March, 20), End: date(2050, time.March, 22)},
+		{UID: "booking-3@othersite.com", Start: date(2050, time.April, 1), End: date(2050, time.April, 8)},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i, e := range expected {
+		got := events[i]
+		if got.UID != e.UID || got.Summary != e.Summary || !got.Start.Equal(e.Start) || !got.End.Equal(e.End) {
+			t.Errorf("event %d: expected %+v, got %+v", i, e, got)
+		}
+	}
+}
+
+func TestParse_Written(t *testing.T) {
+	// a calendar this site writes can be read back
+	c := Calendar{ProdID: "-//Test//Test//EN", Events: []Event{
+		{UID: "a", Summary: strings.Repeat("Reserved; ", 10), Start: date(2050, time.June, 1), End: date(2050, time.June, 4)},
+	}}
+	var b strings.Builder
+	if err := c.Write(&b, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := Parse(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0] != c.Events[0] {
+		t.Errorf("expected %+v back, got %+v", c.Events, events)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	var tests = []struct {
+		name string
+		feed string
+	}{
+		{"empty", ""},
+		{"html", "<html><body>Not found</body></html>"},
+		{"cut off", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nDTSTART;VALUE=DATE:20500101\r\n"},
+		{"no end of calendar", "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"},
+		{"bad date", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nDTSTART:soon\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"no start", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"bad duration", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nDTSTART;VALUE=DATE:20500101\r\nDURATION:P1Y\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"line without value", "BEGIN:VCALENDAR\r\nnonsense\r\nEND:VCALENDAR\r\n"},
+	}
+
+	for _, e := range tests {
+		if _, err := Parse(strings.NewReader(e.feed)); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
+
+func TestParse_Lenient(t *testing.T) {
+	feed := "\ufeffBEGIN:VCALENDAR\n" +
+		"BEGIN:VEVENT\nDTSTART;VALUE=DATE:20500101\nEND:VEVENT\n" +
+		"BEGIN:VEVENT\nUID:same-day\nDTSTART:20500102T100000Z\nDTEND:20500102T120000Z\nEND:VEVENT\n" +
+		"BEGIN:VEVENT\nUID:no-end\nDTSTART;VALUE=DATE:20500105\nEND:VEVENT\n" +
+		"END:VCALENDAR\n"
+
+	events, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected the event without a UID to be left out, got %+v", events)
+	}
+	for _, e := range events {
+		if !e.End.Equal(e.Start.AddDate(0, 0, 1)) {
+			t.Errorf("%s: expected a single night, got %s to %s", e.UID, e.Start, e.End)
+		}
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	var tests = []struct {
+		value string
+		days  int
+	}{
+		{"P1D", 1},
+		{"P2W", 14},
+		{"P1W3D", 10},
+		{"+P3DT12H", 3},
+	}
+
+	for _, e := range tests {
+		days, err := parseDays(e.value)
+		if err != nil || days != e.days {
+			t.Errorf("%s: expected %d days, got %d: %v", e.value, e.days, days, err)
+		}
+	}
+}
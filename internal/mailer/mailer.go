@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+// Mailer sends the site's email through an SMTP server
+type Mailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is used for messages that don't have a sender of their own
+	From string
+}
+
+// Send hands a message to the SMTP server
+func (m *Mailer) Send(msg models.MailData) error {
+	from := msg.From
+	if from == "" {
+		from = m.From
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	return smtp.SendMail(addr, auth, from, []string{msg.To}, message(from, msg.To, msg.Subject, msg.Content))
+}
+
+// message builds an HTML email with its headers
+func message(from, to, subject, body string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(body)
+	return b.Bytes()
+}
@@ -0,0 +1,171 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+// received is a message as the fake SMTP server got it
+type received struct {
+	From string
+	To   []string
+	Auth string
+	Data string
+}
+
+// fakeSMTP is an SMTP server that keeps what it is sent, speaking just enough of the protocol for net/smtp
+type fakeSMTP struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages []received
+	done     chan struct{}
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeSMTP{listener: l, done: make(chan struct{}, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	var msg received
+	reply("220 localhost fake SMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH"):
+			msg.Auth = line
+			reply("235 accepted")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg.From = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.To = append(msg.To, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data bytes.Buffer
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			msg.Data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			s.done <- struct{}{}
+			msg = received{}
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *fakeSMTP) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) received() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]received(nil), s.messages...)
+}
+
+func TestMailer_Send(t *testing.T) {
+	server := startFakeSMTP(t)
+	defer server.listener.Close()
+
+	m := &Mailer{Host: "127.0.0.1", Port: server.port(), From: "bookings@here.com"}
+	err := m.Send(models.MailData{
+		To:      "guest@there.com",
+		Subject: "Your reservation is confirmed",
+		Content: "<p>See you soon</p>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := server.received()
+	if len(messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(messages))
+	}
+	msg := messages[0]
+	if msg.From != "bookings@here.com" || len(msg.To) != 1 || msg.To[0] != "guest@there.com" {
+		t.Errorf("message went from %s to %v", msg.From, msg.To)
+	}
+	for _, want := range []string{
+		"From: bookings@here.com\r\n",
+		"To: guest@there.com\r\n",
+		"Subject: Your reservation is confirmed\r\n",
+		"Content-Type: text/html; charset=UTF-8\r\n",
+		"\r\n\r\n<p>See you soon</p>",
+	} {
+		if !strings.Contains(msg.Data, want) {
+			t.Errorf("expected the message to contain %q, got:\n%s", want, msg.Data)
+		}
+	}
+}
+
+func TestMailer_SendWithAuth(t *testing.T) {
+	server := startFakeSMTP(t)
+	defer server.listener.Close()
+
+	m := &Mailer{Host: "127.0.0.1", Port: server.port(), Username: "user", Password: "secret"}
+	err := m.Send(models.MailData{To: "owner@here.com", From: "guest@there.com", Subject: "Hello", Content: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := server.received()[0]
+	if !strings.HasPrefix(msg.Auth, "AUTH PLAIN") {
+		t.Errorf("expected the mailer to log in, got %q", msg.Auth)
+	}
+	if msg.From != "guest@there.com" {
+		t.Errorf("expected the message's own sender to be used, got %s", msg.From)
+	}
+}
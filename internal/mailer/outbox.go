@@ -0,0 +1,89 @@
+package mailer
+
+import (
+	"log"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// maxBackoff is the longest the outbox waits before trying a message again
+const maxBackoff = 24 * time.Hour
+
+// Sender sends a single message
+type Sender interface {
+	Send(msg models.MailData) error
+}
+
+// Outbox delivers the mail queued in the database. A message that can't be sent is tried again
+// after a delay that doubles each time, until MaxAttempts have failed and it is given up on.
+type Outbox struct {
+	DB          repository.DatabaseRepo
+	Mailer      Sender
+	ErrorLog    *log.Logger
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	BaseDelay   time.Duration
+}
+
+// Run delivers the mail that is due every Interval until stop is closed
+func (o *Outbox) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := o.DeliverDue(time.Now()); err != nil {
+			o.ErrorLog.Println(err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue sends the messages that are due at now and returns how many went
+func (o *Outbox) DeliverDue(now time.Time) (int, error) {
+	// a message is leased for long enough that a slow send isn't picked up twice
+	messages, err := o.DB.ClaimOutboxMessages(now, o.BatchSize, o.Interval+time.Minute)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, msg := range messages {
+		sendErr := o.Mailer.Send(msg.Mail)
+		switch {
+		case sendErr == nil:
+			sent++
+			err = o.DB.MarkOutboxSent(msg.ID)
+		case msg.Attempts+1 >= o.MaxAttempts:
+			o.ErrorLog.Printf("giving up sending %q to %s: %s", msg.Mail.Subject, msg.Mail.To, sendErr)
+			err = o.DB.FailOutboxMessage(msg.ID, sendErr.Error())
+		default:
+			err = o.DB.RetryOutboxMessage(msg.ID, sendErr.Error(), now.Add(Backoff(o.BaseDelay, msg.Attempts+1)))
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+// Backoff is how long to wait after a message has failed attempts times: base, then twice as long
+// after each further failure, up to a day
+func Backoff(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,183 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
+)
+
+// flakySender fails the first failures sends and records the rest
+type flakySender struct {
+	failures int
+	sent     []models.MailData
+}
+
+func (s *flakySender) Send(msg models.MailData) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("connection refused")
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+// queue stores a reservation with mail about it, the way a booking does
+func queue(t *testing.T, o *Outbox, mail ...models.MailData) {
+	_, err := o.DB.CreateReservation(models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@here.com",
+		RoomID:    1,
+		StartDate: time.Date(2055, time.March, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2055, time.March, 3, 0, 0, 0, 0, time.UTC),
+	}, mail...)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newOutbox(sender Sender, logged *bytes.Buffer) *Outbox {
+	return &Outbox{
+		DB:          dbrepo.NewMemoryRepo(nil),
+		Mailer:      sender,
+		ErrorLog:    log.New(logged, "", 0),
+		Interval:    time.Second,
+		BatchSize:   10,
+		MaxAttempts: 3,
+		BaseDelay:   time.Minute,
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var tests = []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{5, 16 * time.Minute},
+		{11, 1024 * time.Minute},
+		{12, 24 * time.Hour},
+		{100, 24 * time.Hour},
+	}
+
+	for _, e := range tests {
+		if got := Backoff(time.Minute, e.attempts); got != e.expected {
+			t.Errorf("after %d attempts expected to wait %s, got %s", e.attempts, e.expected, got)
+		}
+	}
+}
+
+func TestOutbox_DeliverDue(t *testing.T) {
+	sender := &flakySender{}
+	var logged bytes.Buffer
+	o := newOutbox(sender, &logged)
+	queue(t, o, models.MailData{To: "jane@here.com", Subject: "Confirmed"}, models.MailData{To: "owner@here.com", Subject: "Notice"})
+
+	sent, err := o.DeliverDue(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 2 || len(sender.sent) != 2 || sender.sent[0].Subject != "Confirmed" {
+		t.Fatalf("expected both messages to be sent in order, got %+v", sender.sent)
+	}
+
+	// nothing is sent twice
+	sent, err = o.DeliverDue(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 0 {
+		t.Errorf("expected nothing left to send, sent %d", sent)
+	}
+}
+
+func TestOutbox_Retry(t *testing.T) {
+	sender := &flakySender{failures: 1}
+	var logged bytes.Buffer
+	o := newOutbox(sender, &logged)
+	queue(t, o, models.MailData{To: "jane@here.com", Subject: "Confirmed"})
+
+	now := time.Now()
+	if sent, err := o.DeliverDue(now); err != nil || sent != 0 {
+		t.Fatalf("expected the first attempt to fail, sent %d: %v", sent, err)
+	}
+
+	// the message waits out its backoff before it is tried again
+	if sent, _ := o.DeliverDue(now.Add(59 * time.Second)); sent != 0 {
+		t.Error("expected the message to be held back until its backoff is over")
+	}
+	if sent, _ := o.DeliverDue(now.Add(time.Minute)); sent != 1 {
+		t.Fatal("expected the message to be sent once its backoff is over")
+	}
+
+	failed, err := o.DB.FailedOutboxMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("expected no failed messages, got %d", len(failed))
+	}
+}
+
+func TestOutbox_DeadLetter(t *testing.T) {
+	sender := &flakySender{failures: 10}
+	var logged bytes.Buffer
+	o := newOutbox(sender, &logged)
+	queue(t, o, models.MailData{To: "jane@here.com", Subject: "Confirmed"})
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := o.DeliverDue(now); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(24 * time.Hour)
+	}
+
+	if sender.failures != 7 {
+		t.Errorf("expected 3 attempts before giving up, got %d", 10-sender.failures)
+	}
+
+	failed, err := o.DB.FailedOutboxMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 {
+		t.Fatalf("expected the message to be dead-lettered, got %d failed", len(failed))
+	}
+	if failed[0].Attempts != 3 || failed[0].LastError != "connection refused" || failed[0].Status != models.OutboxFailed {
+		t.Errorf("unexpected failed message %+v", failed[0])
+	}
+	if !bytes.Contains(logged.Bytes(), []byte(`giving up sending "Confirmed" to jane@here.com`)) {
+		t.Errorf("expected giving up to be logged, got %q", logged.String())
+	}
+}
+
+func TestOutbox_Run(t *testing.T) {
+	sender := &flakySender{}
+	var logged bytes.Buffer
+	o := newOutbox(sender, &logged)
+	queue(t, o, models.MailData{To: "jane@here.com", Subject: "Confirmed"})
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		o.Run(stop)
+		close(stopped)
+	}()
+	close(stop)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the outbox to stop")
+	}
+	if len(sender.sent) != 1 {
+		t.Errorf("expected the due mail to be sent before stopping, got %d", len(sender.sent))
+	}
+}
@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
 
 // User is the user model
 type User struct {
@@ -14,10 +18,42 @@ type User struct {
 	UpdatedAt   time.Time
 }
 
-// Room is the room model
+// Access levels for User.AccessLevel. Each level can do everything the levels below it can.
+const (
+	AccessGuest     = 1
+	AccessFrontDesk = 2
+	AccessManager   = 3
+	AccessAdmin     = 4
+)
+
+// AccessLevels maps the role names used in templates to access levels
+var AccessLevels = map[string]int{
+	"guest":      AccessGuest,
+	"front-desk": AccessFrontDesk,
+	"manager":    AccessManager,
+	"admin":      AccessAdmin,
+}
+
+// HasRole reports whether accessLevel is at least the level of the named role
+func HasRole(accessLevel int, role string) bool {
+	required, ok := AccessLevels[role]
+	if !ok {
+		return false
+	}
+	return accessLevel >= required
+}
+
+// Room is the room model. Slug names the room's page at /rooms/{slug}.
 type Room struct {
-	ID        int
-	RoomName  string
+	ID          int
+	RoomName    string
+	Slug        string
+	Description string
+	Capacity    int
+	Amenities   []string
+	Photos      []string
+	// ICalToken is the secret that lets other booking sites read the room's calendar
+	ICalToken string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
@@ -30,9 +66,21 @@ type Restriction struct {
 	UpdatedAt       time.Time
 }
 
-// Reservation is the reservation model
+// Seeded rows of the restrictions table. A room restriction of any type makes the room unavailable
+// for its dates; only RestrictionReservation is tied to a reservation, the rest are blocks.
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionMaintenance = 3
+	RestrictionCleaning    = 4
+	RestrictionExternal    = 5
+)
+
+// Reservation is the reservation model. Code is the confirmation code guests and partner sites look the
+// reservation up by, so unlike ID it can't be guessed.
 type Reservation struct {
 	ID        int
+	Code      string
 	FirstName string
 	LastName  string
 	Email     string
@@ -43,7 +91,145 @@ type Reservation struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Room      Room
-	Processed int
+	Quote     Quote
+	// Status is where the reservation is in its lifecycle, such as StatusConfirmed. The booking package
+	// moves reservations between statuses.
+	Status string
+	// When the reservation moved to each status, or zero if it hasn't
+	ConfirmedAt  time.Time
+	CheckedInAt  time.Time
+	CheckedOutAt time.Time
+	CancelledAt  time.Time
+	NoShowAt     time.Time
+	// Refund is what a cancelled reservation was owed back under the cancellation policy, in cents
+	Refund int
+	// Partner identifies the API key of the partner site that booked the reservation, which alone may
+	// change it through the API. It is empty for reservations made on our own site.
+	Partner string
+}
+
+// The statuses of a reservation. Reservations are made pending, and end checked out, cancelled or as a no-show.
+const (
+	StatusPending    = "pending"
+	StatusConfirmed  = "confirmed"
+	StatusCheckedIn  = "checked-in"
+	StatusCheckedOut = "checked-out"
+	StatusCancelled  = "cancelled"
+	StatusNoShow     = "no-show"
+)
+
+// Statuses lists the statuses in the order reservations move through them
+var Statuses = []string{StatusPending, StatusConfirmed, StatusCheckedIn, StatusCheckedOut, StatusCancelled, StatusNoShow}
+
+// StatusNames are the statuses as staff and guests see them
+var StatusNames = map[string]string{
+	StatusPending:    "New",
+	StatusConfirmed:  "Confirmed",
+	StatusCheckedIn:  "Checked in",
+	StatusCheckedOut: "Checked out",
+	StatusCancelled:  "Cancelled",
+	StatusNoShow:     "No-show",
+}
+
+// StatusName is the reservation's status as staff and guests see it
+func (r Reservation) StatusName() string {
+	return StatusNames[r.Status]
+}
+
+// Cancelled reports whether the reservation has been cancelled
+func (r Reservation) Cancelled() bool {
+	return r.Status == StatusCancelled
+}
+
+// HoldsRoom reports whether a reservation with the status keeps its room restriction. Cancelled
+// reservations and no-shows give their nights up.
+func HoldsRoom(status string) bool {
+	return status != StatusCancelled && status != StatusNoShow
+}
+
+// StatusTime is when the reservation moved to status, or zero if it hasn't. Every reservation was pending
+// from when it was made.
+func (r Reservation) StatusTime(status string) time.Time {
+	switch status {
+	case StatusPending:
+		return r.CreatedAt
+	case StatusConfirmed:
+		return r.ConfirmedAt
+	case StatusCheckedIn:
+		return r.CheckedInAt
+	case StatusCheckedOut:
+		return r.CheckedOutAt
+	case StatusCancelled:
+		return r.CancelledAt
+	case StatusNoShow:
+		return r.NoShowAt
+	}
+	return time.Time{}
+}
+
+// SetStatusTime records when the reservation moved to status
+func (r *Reservation) SetStatusTime(status string, t time.Time) {
+	switch status {
+	case StatusConfirmed:
+		r.ConfirmedAt = t
+	case StatusCheckedIn:
+		r.CheckedInAt = t
+	case StatusCheckedOut:
+		r.CheckedOutAt = t
+	case StatusCancelled:
+		r.CancelledAt = t
+	case StatusNoShow:
+		r.NoShowAt = t
+	}
+}
+
+// confirmationAlphabet leaves out the letters and digits that are easily mistaken for each other
+const confirmationAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewConfirmationCode returns a random eight character code for a reservation, such as K7MQ2XRD
+func NewConfirmationCode() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %s", err))
+	}
+	for i := range b {
+		// the alphabet has 32 characters, so every byte maps onto it evenly
+		b[i] = confirmationAlphabet[int(b[i])%len(confirmationAlphabet)]
+	}
+	return string(b)
+}
+
+// Quote is the price of a stay. Amounts are in cents.
+type Quote struct {
+	Nights   int
+	Subtotal int
+	Fees     int
+	Taxes    int
+	Total    int
+}
+
+// RoomRate is a room's base nightly rate, in cents
+type RoomRate struct {
+	ID          int
+	RoomID      int
+	NightlyRate int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// RateOverride replaces a room's base rate for some nights. A seasonal override applies to the nights
+// from StartDate up to EndDate; an override without dates applies all year. A weekend override only
+// applies to Friday and Saturday nights.
+type RateOverride struct {
+	ID           int
+	RoomID       int
+	Name         string
+	StartDate    time.Time
+	EndDate      time.Time
+	WeekendsOnly bool
+	NightlyRate  int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
 }
 
 // RoomRestriction is the room restriction model
@@ -54,10 +240,70 @@ type RoomRestriction struct {
 	RoomID        int
 	ReservationID int
 	RestrictionID int
+	// ICalFeedID and ExternalUID identify the event an external booking was imported from
+	ICalFeedID  int
+	ExternalUID string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+	Reservation Reservation
+	Restriction Restriction
+}
+
+// ICalFeed is another booking site's calendar for a room, whose bookings are imported as blocks
+type ICalFeed struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string
+	LastSyncedAt time.Time
+	LastError    string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// ExternalBooking is a stay booked on another site, as read from its calendar
+type ExternalBooking struct {
+	UID       string
+	Summary   string
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// ICalSyncResult counts the changes made by importing a feed. Conflicts are the UIDs of bookings
+// that could not be imported because their nights are already taken here.
+type ICalSyncResult struct {
+	Added     int
+	Updated   int
+	Removed   int
+	Conflicts []string
+}
+
+// MailData is an email to send, with its HTML body already rendered
+type MailData struct {
+	To      string
+	From    string
+	Subject string
+	Content string
+}
+
+// The states of an outbox message. A message that can't be sent is retried until it runs out of
+// attempts and fails for good.
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboxMessage is an email stored in the outbox table until it is sent
+type OutboxMessage struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	SentAt        time.Time
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
-	Room          Room
-	Reservation   Reservation
-	Restriction   Restriction
 }
-
@@ -0,0 +1,163 @@
+package pricing
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// Policy holds the taxes and fees added to the nightly rates of every stay
+type Policy struct {
+	// TaxRate is charged on the rates and fees, in basis points, so 1250 is 12.5%
+	TaxRate int
+	// StayFee is a flat fee per stay, in cents
+	StayFee int
+}
+
+// ErrNoRate is returned when a room has no base rate, and so cannot be booked
+var ErrNoRate = errors.New("room has no nightly rate")
+
+// ErrNoNights is returned when asked to price a stay that does not end after it starts
+var ErrNoNights = errors.New("a stay must be at least one night long")
+
+// Quote prices a stay in a room, using its rates from the repository
+func Quote(db repository.DatabaseRepo, p Policy, roomID int, start, end time.Time) (models.Quote, error) {
+	rate, err := db.GetRoomRate(roomID)
+	if err == sql.ErrNoRows {
+		return models.Quote{}, fmt.Errorf("room %d: %w", roomID, ErrNoRate)
+	}
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	overrides, err := db.GetRateOverridesForRoom(roomID, start, end)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	return Price(p, rate, overrides, start, end)
+}
+
+// Price prices a stay from start to end given the room's base rate and overrides
+func Price(p Policy, rate models.RoomRate, overrides []models.RateOverride, start, end time.Time) (models.Quote, error) {
+	var q models.Quote
+
+	if !end.After(start) {
+		return q, ErrNoNights
+	}
+
+	for night := start; night.Before(end); night = night.AddDate(0, 0, 1) {
+		q.Nights++
+		q.Subtotal += NightlyRate(rate, overrides, night)
+	}
+
+	q.Fees = p.StayFee
+	q.Taxes = percentOf(q.Subtotal+q.Fees, p.TaxRate)
+	q.Total = q.Subtotal + q.Fees + q.Taxes
+
+	return q, nil
+}
+
+// NightlyRate returns the rate for the night starting on night. The most specific override wins:
+// a seasonal weekend override beats a seasonal one, which beats a weekend one. Among equally
+// specific overrides the most recently added wins. With no override the base rate applies.
+func NightlyRate(rate models.RoomRate, overrides []models.RateOverride, night time.Time) int {
+	best, bestRank := -1, -1
+
+	for i, o := range overrides {
+		seasonal := !o.StartDate.IsZero()
+		if seasonal && (night.Before(o.StartDate) || !night.Before(o.EndDate)) {
+			continue
+		}
+		if o.WeekendsOnly && !IsWeekendNight(night) {
+			continue
+		}
+
+		rank := 0
+		if seasonal {
+			rank += 2
+		}
+		if o.WeekendsOnly {
+			rank++
+		}
+
+		if rank > bestRank || (rank == bestRank && o.ID > overrides[best].ID) {
+			best, bestRank = i, rank
+		}
+	}
+
+	if best < 0 {
+		return rate.NightlyRate
+	}
+	return overrides[best].NightlyRate
+}
+
+// IsWeekendNight reports whether the night starting on day is a Friday or Saturday night
+func IsWeekendNight(day time.Time) bool {
+	return day.Weekday() == time.Friday || day.Weekday() == time.Saturday
+}
+
+// percentOf returns basisPoints of amount, rounded to the nearest cent
+func percentOf(amount, basisPoints int) int {
+	return (amount*basisPoints + 5000) / 10000
+}
+
+// FormatAmount formats an amount in cents as dollars, like $1,234.50
+func FormatAmount(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	dollars := strconv.Itoa(cents / 100)
+	for i := len(dollars) - 3; i > 0; i -= 3 {
+		dollars = dollars[:i] + "," + dollars[i:]
+	}
+
+	return fmt.Sprintf("%s$%s.%02d", sign, dollars, cents%100)
+}
+
+// ParseAmount parses dollars, like 129 or 129.50, into cents
+func ParseAmount(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "$")
+
+	parts := strings.SplitN(s, ".", 2)
+	if !isDigits(parts[0]) || (len(parts) == 2 && (!isDigits(parts[1]) || len(parts[1]) > 2)) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	dollars, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	cents := 0
+	if len(parts) == 2 {
+		cents, _ = strconv.Atoi(parts[1])
+		if len(parts[1]) == 1 {
+			cents *= 10
+		}
+	}
+
+	return dollars*100 + cents, nil
+}
+
+// isDigits reports whether s is a non-empty run of ASCII digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+// Thursday the 4th of July 2030
+var thursday = time.Date(2030, time.July, 4, 0, 0, 0, 0, time.UTC)
+
+func TestNightlyRate(t *testing.T) {
+	base := models.RoomRate{RoomID: 1, NightlyRate: 10000}
+	summer := models.RateOverride{ID: 1, StartDate: thursday.AddDate(0, 0, -10), EndDate: thursday.AddDate(0, 0, 10), NightlyRate: 15000}
+	weekends := models.RateOverride{ID: 2, WeekendsOnly: true, NightlyRate: 12000}
+	summerWeekends := models.RateOverride{ID: 3, StartDate: summer.StartDate, EndDate: summer.EndDate, WeekendsOnly: true, NightlyRate: 18000}
+	newerSummer := models.RateOverride{ID: 4, StartDate: thursday, EndDate: thursday.AddDate(0, 0, 1), NightlyRate: 16000}
+
+	var tests = []struct {
+		name      string
+		overrides []models.RateOverride
+		night     time.Time
+		expected  int
+	}{
+		{"base rate", nil, thursday, 10000},
+		{"weekend override on a weekday", []models.RateOverride{weekends}, thursday, 10000},
+		{"weekend override on a friday", []models.RateOverride{weekends}, thursday.AddDate(0, 0, 1), 12000},
+		{"weekend override on a sunday", []models.RateOverride{weekends}, thursday.AddDate(0, 0, 3), 10000},
+		{"season beats weekend", []models.RateOverride{weekends, summer}, thursday.AddDate(0, 0, 1), 15000},
+		{"seasonal weekend beats season", []models.RateOverride{summer, summerWeekends, weekends}, thursday.AddDate(0, 0, 2), 18000},
+		{"season ends on its end date", []models.RateOverride{summer}, summer.EndDate, 10000},
+		{"newer override wins a tie", []models.RateOverride{newerSummer, summer}, thursday, 16000},
+	}
+
+	for _, e := range tests {
+		if rate := NightlyRate(base, e.overrides, e.night); rate != e.expected {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expected, rate)
+		}
+	}
+}
+
+func TestPrice(t *testing.T) {
+	p := Policy{TaxRate: 1250, StayFee: 2500}
+	base := models.RoomRate{RoomID: 1, NightlyRate: 10000}
+	weekends := []models.RateOverride{{ID: 1, WeekendsOnly: true, NightlyRate: 12000}}
+
+	// Thursday, Friday and Saturday nights
+	q, err := Price(p, base, weekends, thursday, thursday.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := models.Quote{
+		Nights:   3,
+		Subtotal: 34000,
+		Fees:     2500,
+		Taxes:    4563, // 12.5% of 365.00 is 45.625, rounded up
+		Total:    41063,
+	}
+	if q != expected {
+		t.Errorf("expected %+v but got %+v", expected, q)
+	}
+
+	if _, err := Price(p, base, nil, thursday, thursday); err != ErrNoNights {
+		t.Errorf("expected ErrNoNights for an empty stay, got %v", err)
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	var tests = []struct {
+		cents    int
+		expected string
+	}{
+		{0, "$0.00"},
+		{5, "$0.05"},
+		{12900, "$129.00"},
+		{123456789, "$1,234,567.89"},
+		{-2550, "-$25.50"},
+	}
+
+	for _, e := range tests {
+		if s := FormatAmount(e.cents); s != e.expected {
+			t.Errorf("%d: expected %s but got %s", e.cents, e.expected, s)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	var tests = []struct {
+		s     string
+		cents int
+		valid bool
+	}{
+		{"129", 12900, true},
+		{"129.5", 12950, true},
+		{"$129.05", 12905, true},
+		{" 0.99 ", 99, true},
+		{"", 0, false},
+		{"12.345", 0, false},
+		{"-5", 0, false},
+		{"1,000", 0, false},
+		{"12.", 0, false},
+		{"free", 0, false},
+	}
+
+	for _, e := range tests {
+		cents, err := ParseAmount(e.s)
+		if (err == nil) != e.valid {
+			t.Errorf("%q: expected valid to be %t, got error %v", e.s, e.valid, err)
+			continue
+		}
+		if cents != e.cents {
+			t.Errorf("%q: expected %d cents but got %d", e.s, e.cents, cents)
+		}
+	}
+}
@@ -8,13 +8,20 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"time"
 
 	"github.com/justinas/nosurf"
 	"github.com/tsawler/bookings-app/internal/config"
 	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
 )
 
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":   HumanDate,
+	"formatDate":  FormatDate,
+	"hasRole":     models.HasRole,
+	"formatMoney": pricing.FormatAmount,
+}
 
 var app *config.AppConfig
 var pathToTemplates = "./templates"
@@ -24,12 +31,24 @@ func NewRenderer(a *config.AppConfig) {
 	app = a
 }
 
+// HumanDate returns a date in yyyy-mm-dd form
+func HumanDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// FormatDate returns a date formatted with the given layout
+func FormatDate(t time.Time, layout string) string {
+	return t.Format(layout)
+}
+
 // AddDefaultData adds data for all templates
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
 	td.Flash = app.Session.PopString(r.Context(), "flash")
 	td.Warning = app.Session.PopString(r.Context(), "warning")
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.CSRFToken = nosurf.Token(r)
+	td.IsAuthenticated = app.Session.Exists(r.Context(), "user_id")
+	td.AccessLevel = app.Session.GetInt(r.Context(), "access_level")
 	return td
 }
 
@@ -103,5 +122,61 @@ func CreateTemplateCache() (map[string]*template.Template, error) {
 		myCache[name] = ts
 	}
 
+	// emails live in their own directory, with their own layout
+	mails, err := filepath.Glob(fmt.Sprintf("%s/email/*.mail.tmpl", pathToTemplates))
+	if err != nil {
+		return myCache, err
+	}
+
+	for _, mail := range mails {
+		name := filepath.Base(mail)
+		ts, err := template.New(name).Funcs(functions).ParseFiles(mail)
+		if err != nil {
+			return myCache, err
+		}
+
+		matches, err := filepath.Glob(fmt.Sprintf("%s/email/*.layout.tmpl", pathToTemplates))
+		if err != nil {
+			return myCache, err
+		}
+
+		if len(matches) > 0 {
+			ts, err = ts.ParseGlob(fmt.Sprintf("%s/email/*.layout.tmpl", pathToTemplates))
+			if err != nil {
+				return myCache, err
+			}
+		}
+
+		myCache[name] = ts
+	}
+
 	return myCache, nil
 }
+
+// Email renders the email template name, from the email directory under the templates, with data
+func Email(name string, data interface{}) (string, error) {
+	var tc map[string]*template.Template
+
+	if app.UseCache {
+		tc = app.TemplateCache
+	} else {
+		var err error
+		tc, err = CreateTemplateCache()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	t, ok := tc[name+".mail.tmpl"]
+	if !ok {
+		return "", fmt.Errorf("could not get email template %s from cache", name)
+	}
+
+	buf := new(bytes.Buffer)
+	err := t.Execute(buf, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -2,7 +2,9 @@ package render
 
 import (
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/tsawler/bookings-app/internal/models"
 )
@@ -64,6 +66,18 @@ func getSession() (*http.Request, error) {
 	return r, nil
 }
 
+func TestHumanDate(t *testing.T) {
+	d := time.Date(2021, time.September, 5, 14, 30, 0, 0, time.UTC)
+
+	if HumanDate(d) != "2021-09-05" {
+		t.Errorf("expected 2021-09-05 but got %s", HumanDate(d))
+	}
+
+	if FormatDate(d, "01/02/2006") != "09/05/2021" {
+		t.Errorf("expected 09/05/2021 but got %s", FormatDate(d, "01/02/2006"))
+	}
+}
+
 func TestNewTemplates(t *testing.T) {
 	NewRenderer(app)
 }
@@ -76,3 +90,35 @@ func TestCreateTemplateCache(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestEmail(t *testing.T) {
+	pathToTemplates = "./../../templates"
+
+	res := models.Reservation{
+		ID:        7,
+		FirstName: "Jane",
+		LastName:  "Doe",
+		StartDate: time.Date(2050, time.June, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, time.June, 3, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "Major's Suite"},
+		Quote:     models.Quote{Nights: 2, Total: 31380},
+	}
+	data := map[string]interface{}{"reservation": res}
+
+	for _, name := range []string{"reservation-received", "reservation-confirmed", "reservation-notice"} {
+		body, err := Email(name, data)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		for _, want := range []string{"Major&#39;s Suite", "2050-06-01", "$313.80", "<html"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: expected the email to contain %q", name, want)
+			}
+		}
+	}
+
+	if _, err := Email("no-such-template", data); err == nil {
+		t.Error("rendered an email template that does not exist")
+	}
+}
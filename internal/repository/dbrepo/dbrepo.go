@@ -1,9 +1,21 @@
 package dbrepo
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
 	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/models"
 	"github.com/tsawler/bookings-app/internal/repository"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type postgresDBRepo struct {
@@ -17,3 +29,140 @@ func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo
 		DB:  conn,
 	}
 }
+
+// queryer is satisfied by both *sql.DB and *sql.Tx, so statements can run inside or outside a transaction
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// nullInt turns a zero id into NULL, for optional foreign keys
+func nullInt(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// nullDate turns a zero time into NULL, for optional dates
+func nullDate(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// exclusionViolation is the SQLSTATE Postgres raises when a row breaks an exclusion constraint,
+// such as room_restrictions_no_overlap
+const exclusionViolation = "23P01"
+
+// uniqueViolation is the SQLSTATE Postgres raises when a row breaks a unique index, such as rooms_slug_idx
+const uniqueViolation = "23505"
+
+// roomRestrictionError turns an overlap caught by the database into a *repository.RoomUnavailableError,
+// and returns any other error unchanged
+func roomRestrictionError(err error, rr models.RoomRestriction) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == exclusionViolation {
+		return &repository.RoomUnavailableError{
+			RoomID:    rr.RoomID,
+			StartDate: rr.StartDate,
+			EndDate:   rr.EndDate,
+			Err:       err,
+		}
+	}
+	return err
+}
+
+// roomsSlugIndex is the unique index on rooms.slug
+const roomsSlugIndex = "rooms_slug_idx"
+
+// roomError turns a duplicate slug caught by the database into repository.ErrDuplicateSlug,
+// and returns any other error unchanged
+func roomError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == roomsSlugIndex {
+		return repository.ErrDuplicateSlug
+	}
+	return err
+}
+
+// splitLines turns a newline separated column, as used for room amenities and photos, into a slice
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// formatWeekdays stores arrival weekdays as a comma separated list of numbers, Sunday being 0
+func formatWeekdays(days []time.Weekday) string {
+	var s []string
+	for _, d := range days {
+		s = append(s, strconv.Itoa(int(d)))
+	}
+	return strings.Join(s, ",")
+}
+
+// parseWeekdays reads arrival weekdays stored by formatWeekdays, skipping anything that isn't a weekday
+func parseWeekdays(s string) []time.Weekday {
+	var days []time.Weekday
+	for _, f := range strings.Split(s, ",") {
+		d, err := strconv.Atoi(strings.TrimSpace(f))
+		if err == nil && d >= int(time.Sunday) && d <= int(time.Saturday) {
+			days = append(days, time.Weekday(d))
+		}
+	}
+	return days
+}
+
+// uniqueExternalBookings drops all but the last of the bookings with the same UID, keeping the feed's order
+func uniqueExternalBookings(bookings []models.ExternalBooking) []models.ExternalBooking {
+	last := make(map[string]int)
+	for i, b := range bookings {
+		last[b.UID] = i
+	}
+
+	var unique []models.ExternalBooking
+	for i, b := range bookings {
+		if last[b.UID] == i {
+			unique = append(unique, b)
+		}
+	}
+	return unique
+}
+
+// newToken returns a random, unguessable token, such as a room's calendar token
+func newToken() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %s", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// checkPassword compares a password with a bcrypt hash, returning repository.ErrInvalidCredentials if they don't match
+func checkPassword(hash, testPassword string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return repository.ErrInvalidCredentials
+	}
+	return err
+}
+
+// checkBlock returns repository.ErrInvalidBlock unless the restriction type and dates make a valid block
+func checkBlock(restrictionID int, start, end time.Time) error {
+	if restrictionID == models.RestrictionReservation || restrictionID == models.RestrictionExternal || !end.After(start) {
+		return repository.ErrInvalidBlock
+	}
+	return nil
+}
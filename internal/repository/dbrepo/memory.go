@@ -0,0 +1,1206 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"github.com/tsawler/bookings-app/internal/rules"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The staff account a memory repository is seeded with
+const (
+	DemoEmail    = "admin@here.com"
+	DemoPassword = "password"
+)
+
+// ErrInjected is returned by a memory repository for any call matching one of its failures
+var ErrInjected = errors.New("injected failure")
+
+// Failure tells a memory repository to fail calls to Method with ErrInjected. The failure applies
+// to calls for RoomID only, or to every call when RoomID is zero.
+type Failure struct {
+	Method string
+	RoomID int
+}
+
+// memoryDBRepo keeps everything in maps guarded by a mutex. It has the same availability
+// semantics as postgresDBRepo, and is used by tests and by demo mode.
+type memoryDBRepo struct {
+	App *config.AppConfig
+
+	mu               sync.Mutex
+	failures         []Failure
+	lastID           int
+	users            map[int]models.User
+	rooms            map[int]models.Room
+	restrictions     map[int]models.Restriction
+	rates            map[int]models.RoomRate
+	overrides        map[int]models.RateOverride
+	stayRules        map[int]rules.StayRules
+	outbox           map[int]models.OutboxMessage
+	icalFeeds        map[int]models.ICalFeed
+	reservations     map[int]models.Reservation
+	roomRestrictions map[int]models.RoomRestriction
+}
+
+// NewMemoryRepo returns an in-memory repository seeded with the inn's rooms
+func NewMemoryRepo(a *config.AppConfig, failures ...Failure) repository.DatabaseRepo {
+	m := &memoryDBRepo{
+		App:              a,
+		failures:         failures,
+		users:            make(map[int]models.User),
+		rooms:            make(map[int]models.Room),
+		restrictions:     make(map[int]models.Restriction),
+		rates:            make(map[int]models.RoomRate),
+		overrides:        make(map[int]models.RateOverride),
+		stayRules:        make(map[int]rules.StayRules),
+		outbox:           make(map[int]models.OutboxMessage),
+		icalFeeds:        make(map[int]models.ICalFeed),
+		reservations:     make(map[int]models.Reservation),
+		roomRestrictions: make(map[int]models.RoomRestriction),
+	}
+
+	now := time.Now()
+	m.rooms[1] = models.Room{
+		ID:          1,
+		RoomName:    "General's Quarters",
+		Slug:        "generals-quarters",
+		Description: "Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.",
+		Capacity:    2,
+		Amenities:   []string{"Ocean view", "Queen bed", "Private bathroom"},
+		Photos:      []string{"/static/images/generals-quarters.png"},
+		ICalToken:   newToken(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	m.rooms[2] = models.Room{
+		ID:          2,
+		RoomName:    "Major's Suite",
+		Slug:        "majors-suite",
+		Description: "Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.",
+		Capacity:    2,
+		Amenities:   []string{"Ocean view", "King bed", "Sitting room", "Private bathroom"},
+		Photos:      []string{"/static/images/marjors-suite.png"},
+		ICalToken:   newToken(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	m.rates[1] = models.RoomRate{ID: 1, RoomID: 1, NightlyRate: 8900, CreatedAt: now, UpdatedAt: now}
+	m.rates[2] = models.RoomRate{ID: 2, RoomID: 2, NightlyRate: 12900, CreatedAt: now, UpdatedAt: now}
+	m.overrides[1] = models.RateOverride{ID: 1, RoomID: 1, Name: "Weekends", WeekendsOnly: true, NightlyRate: 10900, CreatedAt: now, UpdatedAt: now}
+	m.overrides[2] = models.RateOverride{ID: 2, RoomID: 2, Name: "Weekends", WeekendsOnly: true, NightlyRate: 14900, CreatedAt: now, UpdatedAt: now}
+
+	for id, name := range map[int]string{
+		models.RestrictionReservation: "Reservation",
+		models.RestrictionOwnerBlock:  "Owner Block",
+		models.RestrictionMaintenance: "Maintenance",
+		models.RestrictionCleaning:    "Cleaning buffer",
+		models.RestrictionExternal:    "External booking",
+	} {
+		m.restrictions[id] = models.Restriction{ID: id, RestrictionName: name, CreatedAt: now, UpdatedAt: now}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(DemoPassword), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	m.users[3] = models.User{
+		ID:          3,
+		FirstName:   "Demo",
+		LastName:    "Admin",
+		Email:       DemoEmail,
+		Password:    string(hash),
+		AccessLevel: models.AccessAdmin,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	m.lastID = 3
+
+	return m
+}
+
+// fail returns ErrInjected if a failure was registered for method and roomID
+func (m *memoryDBRepo) fail(method string, roomID int) error {
+	for _, f := range m.failures {
+		if f.Method == method && (f.RoomID == 0 || f.RoomID == roomID) {
+			return fmt.Errorf("%s for room %d: %w", method, roomID, ErrInjected)
+		}
+	}
+	return nil
+}
+
+// nextID hands out ids; they are unique across tables, which is all callers rely on
+func (m *memoryDBRepo) nextID() int {
+	m.lastID++
+	return m.lastID
+}
+
+// overlaps reports whether any restriction on roomID overlaps the start and end dates
+func (m *memoryDBRepo) overlaps(roomID int, start, end time.Time) bool {
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == roomID && start.Before(rr.EndDate) && end.After(rr.StartDate) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryDBRepo) AllUsers() ([]models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var users []models.User
+
+	if err := m.fail("AllUsers", 0); err != nil {
+		return users, err
+	}
+
+	for _, u := range m.users {
+		u.Password = ""
+		users = append(users, u)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].LastName != users[j].LastName {
+			return users[i].LastName < users[j].LastName
+		}
+		return users[i].FirstName < users[j].FirstName
+	})
+
+	return users, nil
+}
+
+func (m *memoryDBRepo) GetUserByID(id int) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetUserByID", 0); err != nil {
+		return models.User{}, err
+	}
+
+	u, ok := m.users[id]
+	if !ok {
+		return u, sql.ErrNoRows
+	}
+
+	return u, nil
+}
+
+func (m *memoryDBRepo) Authenticate(email, testPassword string) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("Authenticate", 0); err != nil {
+		return models.User{}, err
+	}
+
+	for _, u := range m.users {
+		if u.Email == email {
+			if err := checkPassword(u.Password, testPassword); err != nil {
+				return models.User{}, err
+			}
+			return u, nil
+		}
+	}
+
+	return models.User{}, repository.ErrInvalidCredentials
+}
+
+func (m *memoryDBRepo) InsertReservation(res models.Reservation) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertReservation", res.RoomID); err != nil {
+		return 0, err
+	}
+
+	return m.insertReservation(res)
+}
+
+func (m *memoryDBRepo) insertReservation(res models.Reservation) (int, error) {
+	if _, ok := m.rooms[res.RoomID]; !ok {
+		return 0, fmt.Errorf("room %d does not exist", res.RoomID)
+	}
+
+	if res.Code == "" {
+		res.Code = models.NewConfirmationCode()
+	}
+
+	now := time.Now()
+	res.ID = m.nextID()
+	res.Status = models.StatusPending
+	res.CreatedAt = now
+	res.UpdatedAt = now
+	m.reservations[res.ID] = res
+
+	return res.ID, nil
+}
+
+func (m *memoryDBRepo) InsertRoomRestriction(res models.RoomRestriction) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertRoomRestriction", res.RoomID); err != nil {
+		return 0, err
+	}
+
+	return m.insertRoomRestriction(res)
+}
+
+// insertRoomRestriction refuses overlapping restrictions, just as the exclusion constraint does in postgres
+func (m *memoryDBRepo) insertRoomRestriction(res models.RoomRestriction) (int, error) {
+	if _, ok := m.rooms[res.RoomID]; !ok {
+		return 0, fmt.Errorf("room %d does not exist", res.RoomID)
+	}
+	if _, ok := m.restrictions[res.RestrictionID]; !ok {
+		return 0, fmt.Errorf("restriction %d does not exist", res.RestrictionID)
+	}
+
+	if m.overlaps(res.RoomID, res.StartDate, res.EndDate) {
+		return 0, &repository.RoomUnavailableError{
+			RoomID:    res.RoomID,
+			StartDate: res.StartDate,
+			EndDate:   res.EndDate,
+		}
+	}
+
+	now := time.Now()
+	res.ID = m.nextID()
+	res.CreatedAt = now
+	res.UpdatedAt = now
+	m.roomRestrictions[res.ID] = res
+
+	return res.ID, nil
+}
+
+// CreateReservation stores a reservation, its room restriction and any mail about it atomically. Failures
+// registered for InsertReservation and InsertRoomRestriction apply here too, since it does the work of both.
+func (m *memoryDBRepo) CreateReservation(res models.Reservation, mail ...models.MailData) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, method := range []string{"CreateReservation", "InsertReservation", "InsertRoomRestriction"} {
+		if err := m.fail(method, res.RoomID); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, ok := m.rooms[res.RoomID]; !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	if violations := m.rulesFor(res.RoomID).Check(res.StartDate, res.EndDate, rules.Today()); len(violations) > 0 {
+		return 0, &rules.Error{RoomID: res.RoomID, Violations: violations}
+	}
+
+	if m.overlaps(res.RoomID, res.StartDate, res.EndDate) {
+		return 0, &repository.RoomUnavailableError{
+			RoomID:    res.RoomID,
+			StartDate: res.StartDate,
+			EndDate:   res.EndDate,
+		}
+	}
+
+	newID, err := m.insertReservation(res)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = m.insertRoomRestriction(models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		ReservationID: newID,
+		RestrictionID: models.RestrictionReservation,
+	})
+	if err != nil {
+		delete(m.reservations, newID)
+		return 0, err
+	}
+
+	m.queueMail(mail)
+
+	return newID, nil
+}
+
+// queueMail adds mail to the outbox, to be sent straight away
+func (m *memoryDBRepo) queueMail(mail []models.MailData) {
+	now := time.Now()
+	for _, msg := range mail {
+		id := m.nextID()
+		m.outbox[id] = models.OutboxMessage{
+			ID:            id,
+			Mail:          msg,
+			Status:        models.OutboxPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+	}
+}
+
+func (m *memoryDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("SearchAvailabilityByDatesByRoomID", roomID); err != nil {
+		return false, err
+	}
+
+	return !m.overlaps(roomID, start, end), nil
+}
+
+func (m *memoryDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rooms []models.Room
+
+	if err := m.fail("SearchAvailabilityForAllRooms", 0); err != nil {
+		return rooms, err
+	}
+
+	for _, room := range m.rooms {
+		if !m.overlaps(room.ID, start, end) {
+			rooms = append(rooms, room)
+		}
+	}
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+
+	return rooms, nil
+}
+
+func (m *memoryDBRepo) GetRoomByID(id int) (models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetRoomByID", id); err != nil {
+		return models.Room{}, err
+	}
+
+	room, ok := m.rooms[id]
+	if !ok {
+		return room, sql.ErrNoRows
+	}
+
+	return room, nil
+}
+
+func (m *memoryDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetRoomBySlug", 0); err != nil {
+		return models.Room{}, err
+	}
+
+	for _, room := range m.rooms {
+		if room.Slug == slug {
+			return room, nil
+		}
+	}
+
+	return models.Room{}, sql.ErrNoRows
+}
+
+// InsertRoom adds a room along with its rate. Failures registered for SetRoomRate apply here too, and
+// leave no room behind.
+func (m *memoryDBRepo) InsertRoom(room models.Room, nightlyRate int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, method := range []string{"InsertRoom", "SetRoomRate"} {
+		if err := m.fail(method, 0); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, r := range m.rooms {
+		if r.Slug == room.Slug {
+			return 0, repository.ErrDuplicateSlug
+		}
+	}
+
+	now := time.Now()
+	room.ID = m.nextID()
+	room.ICalToken = newToken()
+	room.CreatedAt = now
+	room.UpdatedAt = now
+	m.rooms[room.ID] = room
+	m.setRoomRate(room.ID, nightlyRate)
+
+	return room.ID, nil
+}
+
+func (m *memoryDBRepo) ResetRoomICalToken(roomID int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("ResetRoomICalToken", roomID); err != nil {
+		return "", err
+	}
+
+	room, ok := m.rooms[roomID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+
+	room.ICalToken = newToken()
+	room.UpdatedAt = time.Now()
+	m.rooms[roomID] = room
+
+	return room.ICalToken, nil
+}
+
+func (m *memoryDBRepo) GetRoomRate(roomID int) (models.RoomRate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetRoomRate", roomID); err != nil {
+		return models.RoomRate{}, err
+	}
+
+	rate, ok := m.rates[roomID]
+	if !ok {
+		return rate, sql.ErrNoRows
+	}
+
+	return rate, nil
+}
+
+func (m *memoryDBRepo) SetRoomRate(roomID, nightlyRate int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("SetRoomRate", roomID); err != nil {
+		return err
+	}
+
+	if _, ok := m.rooms[roomID]; !ok {
+		return fmt.Errorf("room %d does not exist", roomID)
+	}
+
+	m.setRoomRate(roomID, nightlyRate)
+
+	return nil
+}
+
+func (m *memoryDBRepo) setRoomRate(roomID, nightlyRate int) {
+	now := time.Now()
+	rate, ok := m.rates[roomID]
+	if !ok {
+		rate = models.RoomRate{ID: m.nextID(), RoomID: roomID, CreatedAt: now}
+	}
+	rate.NightlyRate = nightlyRate
+	rate.UpdatedAt = now
+	m.rates[roomID] = rate
+}
+
+func (m *memoryDBRepo) GetRateOverridesForRoom(roomID int, start, end time.Time) ([]models.RateOverride, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var overrides []models.RateOverride
+
+	if err := m.fail("GetRateOverridesForRoom", roomID); err != nil {
+		return overrides, err
+	}
+
+	for _, o := range m.overrides {
+		if o.RoomID == roomID && (o.StartDate.IsZero() || (start.Before(o.EndDate) && end.After(o.StartDate))) {
+			overrides = append(overrides, o)
+		}
+	}
+
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].ID < overrides[j].ID })
+
+	return overrides, nil
+}
+
+func (m *memoryDBRepo) InsertRateOverride(o models.RateOverride) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertRateOverride", o.RoomID); err != nil {
+		return 0, err
+	}
+
+	if _, ok := m.rooms[o.RoomID]; !ok {
+		return 0, fmt.Errorf("room %d does not exist", o.RoomID)
+	}
+
+	now := time.Now()
+	o.ID = m.nextID()
+	o.CreatedAt = now
+	o.UpdatedAt = now
+	m.overrides[o.ID] = o
+
+	return o.ID, nil
+}
+
+// rulesFor returns a room's stay rules, or the default rules if it has none of its own
+func (m *memoryDBRepo) rulesFor(roomID int) rules.StayRules {
+	if r, ok := m.stayRules[roomID]; ok {
+		return r
+	}
+	return rules.Default(roomID)
+}
+
+func (m *memoryDBRepo) GetStayRulesForRoom(roomID int) (rules.StayRules, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetStayRulesForRoom", roomID); err != nil {
+		return rules.StayRules{}, err
+	}
+
+	return m.rulesFor(roomID), nil
+}
+
+func (m *memoryDBRepo) SetStayRules(r rules.StayRules) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("SetStayRules", r.RoomID); err != nil {
+		return err
+	}
+
+	if _, ok := m.rooms[r.RoomID]; !ok {
+		return fmt.Errorf("room %d does not exist", r.RoomID)
+	}
+
+	m.stayRules[r.RoomID] = r
+
+	return nil
+}
+
+// reservationsWhere returns copies of the reservations matching keep, with their rooms, ordered by arrival
+func (m *memoryDBRepo) reservationsWhere(keep func(models.Reservation) bool) []models.Reservation {
+	var reservations []models.Reservation
+
+	for _, res := range m.reservations {
+		if keep(res) {
+			res.Room = m.rooms[res.RoomID]
+			reservations = append(reservations, res)
+		}
+	}
+
+	sort.Slice(reservations, func(i, j int) bool {
+		if !reservations[i].StartDate.Equal(reservations[j].StartDate) {
+			return reservations[i].StartDate.Before(reservations[j].StartDate)
+		}
+		return reservations[i].ID < reservations[j].ID
+	})
+
+	return reservations
+}
+
+func (m *memoryDBRepo) AllReservations() ([]models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("AllReservations", 0); err != nil {
+		return nil, err
+	}
+
+	return m.reservationsWhere(func(models.Reservation) bool { return true }), nil
+}
+
+func (m *memoryDBRepo) AllNewReservations() ([]models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("AllNewReservations", 0); err != nil {
+		return nil, err
+	}
+
+	return m.reservationsWhere(func(res models.Reservation) bool { return res.Status == models.StatusPending }), nil
+}
+
+func (m *memoryDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetReservationByID", 0); err != nil {
+		return models.Reservation{}, err
+	}
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return res, sql.ErrNoRows
+	}
+	res.Room = m.rooms[res.RoomID]
+
+	return res, nil
+}
+
+func (m *memoryDBRepo) GetReservationByCode(code string) (models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetReservationByCode", 0); err != nil {
+		return models.Reservation{}, err
+	}
+
+	for _, res := range m.reservations {
+		if res.Code == code {
+			res.Room = m.rooms[res.RoomID]
+			return res, nil
+		}
+	}
+
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+func (m *memoryDBRepo) UpdateReservation(u models.Reservation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("UpdateReservation", u.RoomID); err != nil {
+		return err
+	}
+
+	res, ok := m.reservations[u.ID]
+	if !ok {
+		return nil
+	}
+
+	res.FirstName = u.FirstName
+	res.LastName = u.LastName
+	res.Email = u.Email
+	res.Phone = u.Phone
+	res.UpdatedAt = time.Now()
+	m.reservations[res.ID] = res
+
+	return nil
+}
+
+// ChangeReservationDates moves a reservation and its room restriction to new dates, checking them just as
+// CreateReservation does but ignoring the nights the reservation already holds
+func (m *memoryDBRepo) ChangeReservationDates(u models.Reservation, mail ...models.MailData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("ChangeReservationDates", u.RoomID); err != nil {
+		return err
+	}
+
+	res, ok := m.reservations[u.ID]
+	open := res.Status == models.StatusPending || res.Status == models.StatusConfirmed
+	if !ok || !open || res.RoomID != u.RoomID {
+		return sql.ErrNoRows
+	}
+
+	if violations := m.rulesFor(u.RoomID).Check(u.StartDate, u.EndDate, rules.Today()); len(violations) > 0 {
+		return &rules.Error{RoomID: u.RoomID, Violations: violations}
+	}
+
+	var rrID int
+	for id, rr := range m.roomRestrictions {
+		if rr.ReservationID == u.ID {
+			rrID = id
+		}
+	}
+	if m.overlapsExcept(u.RoomID, u.StartDate, u.EndDate, rrID) {
+		return &repository.RoomUnavailableError{
+			RoomID:    u.RoomID,
+			StartDate: u.StartDate,
+			EndDate:   u.EndDate,
+		}
+	}
+
+	now := time.Now()
+	res.StartDate = u.StartDate
+	res.EndDate = u.EndDate
+	res.Quote = u.Quote
+	res.UpdatedAt = now
+	m.reservations[res.ID] = res
+
+	if rr, ok := m.roomRestrictions[rrID]; ok {
+		rr.StartDate = u.StartDate
+		rr.EndDate = u.EndDate
+		rr.UpdatedAt = now
+		m.roomRestrictions[rrID] = rr
+	}
+
+	m.queueMail(mail)
+
+	return nil
+}
+
+func (m *memoryDBRepo) UpdateReservationStatus(u models.Reservation, from string, mail ...models.MailData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("UpdateReservationStatus", 0); err != nil {
+		return err
+	}
+
+	res, ok := m.reservations[u.ID]
+	if !ok || res.Status != from {
+		return sql.ErrNoRows
+	}
+
+	res.Status = u.Status
+	res.ConfirmedAt = u.ConfirmedAt
+	res.CheckedInAt = u.CheckedInAt
+	res.CheckedOutAt = u.CheckedOutAt
+	res.CancelledAt = u.CancelledAt
+	res.NoShowAt = u.NoShowAt
+	res.Refund = u.Refund
+	res.UpdatedAt = time.Now()
+	m.reservations[res.ID] = res
+
+	if !models.HoldsRoom(res.Status) {
+		for rrID, rr := range m.roomRestrictions {
+			if rr.ReservationID == res.ID {
+				delete(m.roomRestrictions, rrID)
+			}
+		}
+	}
+
+	m.queueMail(mail)
+
+	return nil
+}
+
+func (m *memoryDBRepo) AllRooms() ([]models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rooms []models.Room
+
+	if err := m.fail("AllRooms", 0); err != nil {
+		return rooms, err
+	}
+
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].RoomName < rooms[j].RoomName })
+
+	return rooms, nil
+}
+
+func (m *memoryDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetRestrictionsForRoomByDate", roomID); err != nil {
+		return nil, err
+	}
+
+	return m.restrictionsWhere(roomID, start, end, func(models.RoomRestriction) bool { return true }), nil
+}
+
+func (m *memoryDBRepo) GetRestrictionsForRoom(roomID int) ([]models.RoomRestriction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetRestrictionsForRoom", roomID); err != nil {
+		return nil, err
+	}
+
+	var restrictions []models.RoomRestriction
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == roomID {
+			rr.Restriction = m.restrictions[rr.RestrictionID]
+			restrictions = append(restrictions, rr)
+		}
+	}
+
+	sort.Slice(restrictions, func(i, j int) bool { return restrictions[i].StartDate.Before(restrictions[j].StartDate) })
+
+	return restrictions, nil
+}
+
+func (m *memoryDBRepo) GetBlocksForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetBlocksForRoomByDate", roomID); err != nil {
+		return nil, err
+	}
+
+	return m.restrictionsWhere(roomID, start, end, func(rr models.RoomRestriction) bool { return rr.ReservationID == 0 }), nil
+}
+
+// restrictionsWhere returns the restrictions on roomID overlapping the dates that keep accepts,
+// with their restriction types, ordered by start date
+func (m *memoryDBRepo) restrictionsWhere(roomID int, start, end time.Time, keep func(models.RoomRestriction) bool) []models.RoomRestriction {
+	var restrictions []models.RoomRestriction
+
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == roomID && start.Before(rr.EndDate) && end.After(rr.StartDate) && keep(rr) {
+			rr.Restriction = m.restrictions[rr.RestrictionID]
+			restrictions = append(restrictions, rr)
+		}
+	}
+
+	sort.Slice(restrictions, func(i, j int) bool { return restrictions[i].StartDate.Before(restrictions[j].StartDate) })
+
+	return restrictions
+}
+
+func (m *memoryDBRepo) AllRestrictions() ([]models.Restriction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var restrictions []models.Restriction
+
+	if err := m.fail("AllRestrictions", 0); err != nil {
+		return restrictions, err
+	}
+
+	for _, r := range m.restrictions {
+		restrictions = append(restrictions, r)
+	}
+
+	sort.Slice(restrictions, func(i, j int) bool { return restrictions[i].ID < restrictions[j].ID })
+
+	return restrictions, nil
+}
+
+func (m *memoryDBRepo) InsertBlockForRoom(roomID, restrictionID int, start, end time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertBlockForRoom", roomID); err != nil {
+		return 0, err
+	}
+
+	if err := checkBlock(restrictionID, start, end); err != nil {
+		return 0, err
+	}
+
+	return m.insertRoomRestriction(models.RoomRestriction{
+		StartDate:     start,
+		EndDate:       end,
+		RoomID:        roomID,
+		RestrictionID: restrictionID,
+	})
+}
+
+func (m *memoryDBRepo) DeleteBlockByID(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("DeleteBlockByID", 0); err != nil {
+		return err
+	}
+
+	rr, ok := m.roomRestrictions[id]
+	if ok && rr.ReservationID == 0 && rr.RestrictionID != models.RestrictionExternal && rr.ICalFeedID == 0 {
+		delete(m.roomRestrictions, id)
+	}
+
+	return nil
+}
+
+// overlapsExcept is overlaps, ignoring the room restriction id
+func (m *memoryDBRepo) overlapsExcept(roomID int, start, end time.Time, id int) bool {
+	for _, rr := range m.roomRestrictions {
+		if rr.ID != id && rr.RoomID == roomID && start.Before(rr.EndDate) && end.After(rr.StartDate) {
+			return true
+		}
+	}
+	return false
+}
+
+// icalFeedsWhere returns the feeds that keep accepts, ordered by room and id
+func (m *memoryDBRepo) icalFeedsWhere(keep func(models.ICalFeed) bool) []models.ICalFeed {
+	var feeds []models.ICalFeed
+	for _, f := range m.icalFeeds {
+		if keep(f) {
+			feeds = append(feeds, f)
+		}
+	}
+
+	sort.Slice(feeds, func(i, j int) bool {
+		if feeds[i].RoomID != feeds[j].RoomID {
+			return feeds[i].RoomID < feeds[j].RoomID
+		}
+		return feeds[i].ID < feeds[j].ID
+	})
+
+	return feeds
+}
+
+func (m *memoryDBRepo) AllICalFeeds() ([]models.ICalFeed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("AllICalFeeds", 0); err != nil {
+		return nil, err
+	}
+
+	return m.icalFeedsWhere(func(models.ICalFeed) bool { return true }), nil
+}
+
+func (m *memoryDBRepo) GetICalFeedsForRoom(roomID int) ([]models.ICalFeed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetICalFeedsForRoom", roomID); err != nil {
+		return nil, err
+	}
+
+	return m.icalFeedsWhere(func(f models.ICalFeed) bool { return f.RoomID == roomID }), nil
+}
+
+func (m *memoryDBRepo) InsertICalFeed(f models.ICalFeed) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertICalFeed", f.RoomID); err != nil {
+		return 0, err
+	}
+
+	if _, ok := m.rooms[f.RoomID]; !ok {
+		return 0, fmt.Errorf("room %d does not exist", f.RoomID)
+	}
+
+	now := time.Now()
+	f.ID = m.nextID()
+	f.LastSyncedAt = time.Time{}
+	f.LastError = ""
+	f.CreatedAt = now
+	f.UpdatedAt = now
+	m.icalFeeds[f.ID] = f
+
+	return f.ID, nil
+}
+
+func (m *memoryDBRepo) DeleteICalFeed(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("DeleteICalFeed", 0); err != nil {
+		return err
+	}
+
+	delete(m.icalFeeds, id)
+	for rrID, rr := range m.roomRestrictions {
+		if rr.ICalFeedID == id {
+			delete(m.roomRestrictions, rrID)
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryDBRepo) SyncICalFeed(feedID int, bookings []models.ExternalBooking) (models.ICalSyncResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result models.ICalSyncResult
+
+	feed, ok := m.icalFeeds[feedID]
+	if !ok {
+		return result, sql.ErrNoRows
+	}
+
+	if err := m.fail("SyncICalFeed", feed.RoomID); err != nil {
+		return result, err
+	}
+
+	existing := make(map[string]models.RoomRestriction)
+	for _, rr := range m.roomRestrictions {
+		if rr.ICalFeedID == feedID {
+			existing[rr.ExternalUID] = rr
+		}
+	}
+
+	bookings = uniqueExternalBookings(bookings)
+	wanted := make(map[string]bool)
+	for _, b := range bookings {
+		wanted[b.UID] = true
+	}
+
+	for uid, rr := range existing {
+		if !wanted[uid] {
+			delete(m.roomRestrictions, rr.ID)
+			result.Removed++
+		}
+	}
+
+	now := time.Now()
+	for _, b := range bookings {
+		rr, found := existing[b.UID]
+		if found && rr.StartDate.Equal(b.StartDate) && rr.EndDate.Equal(b.EndDate) {
+			continue
+		}
+
+		if m.overlapsExcept(feed.RoomID, b.StartDate, b.EndDate, rr.ID) {
+			result.Conflicts = append(result.Conflicts, b.UID)
+			continue
+		}
+
+		if found {
+			rr.StartDate = b.StartDate
+			rr.EndDate = b.EndDate
+			rr.UpdatedAt = now
+			m.roomRestrictions[rr.ID] = rr
+			result.Updated++
+			continue
+		}
+
+		id := m.nextID()
+		m.roomRestrictions[id] = models.RoomRestriction{
+			ID:            id,
+			StartDate:     b.StartDate,
+			EndDate:       b.EndDate,
+			RoomID:        feed.RoomID,
+			RestrictionID: models.RestrictionExternal,
+			ICalFeedID:    feedID,
+			ExternalUID:   b.UID,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		result.Added++
+	}
+
+	feed.LastSyncedAt = now
+	feed.LastError = ""
+	feed.UpdatedAt = now
+	m.icalFeeds[feedID] = feed
+
+	return result, nil
+}
+
+func (m *memoryDBRepo) RecordICalFeedError(feedID int, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("RecordICalFeedError", 0); err != nil {
+		return err
+	}
+
+	feed, ok := m.icalFeeds[feedID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	feed.LastError = message
+	feed.UpdatedAt = time.Now()
+	m.icalFeeds[feedID] = feed
+
+	return nil
+}
+
+// outboxWhere returns copies of the outbox messages matching keep, ordered by less
+func (m *memoryDBRepo) outboxWhere(keep func(models.OutboxMessage) bool, less func(a, b models.OutboxMessage) bool) []models.OutboxMessage {
+	var messages []models.OutboxMessage
+	for _, msg := range m.outbox {
+		if keep(msg) {
+			messages = append(messages, msg)
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return less(messages[i], messages[j])
+	})
+
+	return messages
+}
+
+func (m *memoryDBRepo) ClaimOutboxMessages(now time.Time, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("ClaimOutboxMessages", 0); err != nil {
+		return nil, err
+	}
+
+	due := m.outboxWhere(func(msg models.OutboxMessage) bool {
+		return msg.Status == models.OutboxPending && !msg.NextAttemptAt.After(now)
+	}, func(a, b models.OutboxMessage) bool {
+		if a.NextAttemptAt.Equal(b.NextAttemptAt) {
+			return a.ID < b.ID
+		}
+		return a.NextAttemptAt.Before(b.NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for i := range due {
+		due[i].NextAttemptAt = now.Add(lease)
+		due[i].UpdatedAt = time.Now()
+		m.outbox[due[i].ID] = due[i]
+	}
+
+	return due, nil
+}
+
+// updateOutbox applies change to the message id, counting an attempt to send it
+func (m *memoryDBRepo) updateOutbox(method string, id int, change func(msg *models.OutboxMessage)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail(method, 0); err != nil {
+		return err
+	}
+
+	msg, ok := m.outbox[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	msg.Attempts++
+	msg.UpdatedAt = time.Now()
+	change(&msg)
+	m.outbox[id] = msg
+
+	return nil
+}
+
+func (m *memoryDBRepo) MarkOutboxSent(id int) error {
+	return m.updateOutbox("MarkOutboxSent", id, func(msg *models.OutboxMessage) {
+		msg.Status = models.OutboxSent
+		msg.SentAt = msg.UpdatedAt
+	})
+}
+
+func (m *memoryDBRepo) RetryOutboxMessage(id int, lastError string, at time.Time) error {
+	return m.updateOutbox("RetryOutboxMessage", id, func(msg *models.OutboxMessage) {
+		msg.LastError = lastError
+		msg.NextAttemptAt = at
+	})
+}
+
+func (m *memoryDBRepo) FailOutboxMessage(id int, lastError string) error {
+	return m.updateOutbox("FailOutboxMessage", id, func(msg *models.OutboxMessage) {
+		msg.Status = models.OutboxFailed
+		msg.LastError = lastError
+	})
+}
+
+func (m *memoryDBRepo) FailedOutboxMessages() ([]models.OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("FailedOutboxMessages", 0); err != nil {
+		return nil, err
+	}
+
+	return m.outboxWhere(func(msg models.OutboxMessage) bool {
+		return msg.Status == models.OutboxFailed
+	}, func(a, b models.OutboxMessage) bool {
+		if a.UpdatedAt.Equal(b.UpdatedAt) {
+			return a.ID > b.ID
+		}
+		return a.UpdatedAt.After(b.UpdatedAt)
+	}), nil
+}
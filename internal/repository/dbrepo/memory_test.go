@@ -0,0 +1,443 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"github.com/tsawler/bookings-app/internal/rules"
+)
+
+func TestMemoryAvailability(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	start := time.Date(2030, time.May, 10, 0, 0, 0, 0, time.UTC)
+	_, err := repo.CreateReservation(models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@here.com",
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, 3),
+		RoomID:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name      string
+		start     time.Time
+		end       time.Time
+		available bool
+	}{
+		{"same dates", start, start.AddDate(0, 0, 3), false},
+		{"inside", start.AddDate(0, 0, 1), start.AddDate(0, 0, 2), false},
+		{"overlaps arrival", start.AddDate(0, 0, -2), start.AddDate(0, 0, 1), false},
+		{"overlaps departure", start.AddDate(0, 0, 2), start.AddDate(0, 0, 5), false},
+		{"ends on arrival day", start.AddDate(0, 0, -2), start, true},
+		{"starts on departure day", start.AddDate(0, 0, 3), start.AddDate(0, 0, 5), true},
+	}
+
+	for _, e := range tests {
+		available, err := repo.SearchAvailabilityByDatesByRoomID(e.start, e.end, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if available != e.available {
+			t.Errorf("%s: expected available to be %t but got %t", e.name, e.available, available)
+		}
+
+		rooms, err := repo.SearchAvailabilityForAllRooms(e.start, e.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := 1
+		if e.available {
+			expected = 2
+		}
+		if len(rooms) != expected {
+			t.Errorf("%s: expected %d free rooms but got %d", e.name, expected, len(rooms))
+		}
+	}
+}
+
+func TestMemoryInsertRoomRestrictionOverlap(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	start := time.Date(2030, time.June, 1, 0, 0, 0, 0, time.UTC)
+	rr := models.RoomRestriction{StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomID: 2, RestrictionID: models.RestrictionMaintenance}
+	if _, err := repo.InsertRoomRestriction(rr); err != nil {
+		t.Fatal(err)
+	}
+
+	rr.StartDate = start.AddDate(0, 0, 1)
+	rr.EndDate = start.AddDate(0, 0, 4)
+	_, err := repo.InsertRoomRestriction(rr)
+	if !repository.IsRoomUnavailable(err) {
+		t.Errorf("expected overlapping restriction to be refused, got %v", err)
+	}
+}
+
+func TestMemoryCreateReservationConcurrent(t *testing.T) {
+	testConcurrentBookings(t, NewMemoryRepo(&config.AppConfig{}), 1)
+}
+
+func TestMemoryFailures(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{},
+		Failure{Method: "InsertReservation", RoomID: 2},
+		Failure{Method: "SearchAvailabilityForAllRooms"},
+	)
+
+	start := time.Date(2030, time.July, 1, 0, 0, 0, 0, time.UTC)
+	res := models.Reservation{StartDate: start, EndDate: start.AddDate(0, 0, 1), RoomID: 2}
+
+	if _, err := repo.InsertReservation(res); !errors.Is(err, ErrInjected) {
+		t.Errorf("expected InsertReservation for room 2 to fail, got %v", err)
+	}
+
+	if _, err := repo.CreateReservation(res); !errors.Is(err, ErrInjected) {
+		t.Errorf("expected CreateReservation for room 2 to fail, got %v", err)
+	}
+
+	res.RoomID = 1
+	if _, err := repo.CreateReservation(res); err != nil {
+		t.Errorf("expected CreateReservation for room 1 to succeed, got %v", err)
+	}
+
+	if _, err := repo.SearchAvailabilityForAllRooms(start, start.AddDate(0, 0, 1)); !errors.Is(err, ErrInjected) {
+		t.Errorf("expected SearchAvailabilityForAllRooms to fail, got %v", err)
+	}
+
+	if _, err := repo.GetRoomByID(99); err == nil {
+		t.Error("expected error getting a room that does not exist")
+	}
+}
+
+func TestMemoryAuthenticate(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	u, err := repo.Authenticate(DemoEmail, DemoPassword)
+	if err != nil {
+		t.Fatalf("expected demo credentials to work, got %s", err)
+	}
+	if u.Email != DemoEmail {
+		t.Errorf("expected user %s but got %s", DemoEmail, u.Email)
+	}
+
+	if _, err := repo.Authenticate(DemoEmail, "wrong"); err != repository.ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials for a wrong password, got %v", err)
+	}
+
+	if _, err := repo.Authenticate("nobody@here.com", DemoPassword); err != repository.ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials for an unknown email, got %v", err)
+	}
+}
+
+func TestMemoryReservationAdmin(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	start := time.Date(2030, time.August, 1, 0, 0, 0, 0, time.UTC)
+	id, err := repo.CreateReservation(models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@here.com",
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, 2),
+		RoomID:    2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Room.RoomName != "Major's Suite" {
+		t.Errorf("expected reservation to come with its room, got %q", res.Room.RoomName)
+	}
+	if len(res.Code) != 8 {
+		t.Errorf("expected the reservation to be given a confirmation code, got %q", res.Code)
+	}
+
+	byCode, err := repo.GetReservationByCode(res.Code)
+	if err != nil || byCode.ID != id {
+		t.Errorf("expected to find the reservation by its code, got %d, %v", byCode.ID, err)
+	}
+	if _, err := repo.GetReservationByCode("NOTACODE"); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for an unknown code, got %v", err)
+	}
+
+	res.FirstName = "Janet"
+	if err := repo.UpdateReservation(res); err != nil {
+		t.Fatal(err)
+	}
+	res, _ = repo.GetReservationByID(id)
+	if res.FirstName != "Janet" {
+		t.Errorf("expected first name to be updated, got %s", res.FirstName)
+	}
+
+	newRes, _ := repo.AllNewReservations()
+	if len(newRes) != 1 {
+		t.Fatalf("expected 1 new reservation but got %d", len(newRes))
+	}
+
+	res.Status = models.StatusConfirmed
+	res.ConfirmedAt = time.Now()
+	if err := repo.UpdateReservationStatus(res, models.StatusPending); err != nil {
+		t.Fatal(err)
+	}
+	newRes, _ = repo.AllNewReservations()
+	allRes, _ := repo.AllReservations()
+	if len(newRes) != 0 || len(allRes) != 1 {
+		t.Errorf("expected 0 new and 1 total reservations, got %d and %d", len(newRes), len(allRes))
+	}
+}
+
+func TestMemoryBlocks(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	day := time.Date(2030, time.September, 10, 0, 0, 0, 0, time.UTC)
+	if _, err := repo.InsertBlockForRoom(1, models.RestrictionOwnerBlock, day, day.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	resID, err := repo.CreateReservation(models.Reservation{StartDate: day.AddDate(0, 0, 1), EndDate: day.AddDate(0, 0, 3), RoomID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.InsertBlockForRoom(1, models.RestrictionOwnerBlock, day.AddDate(0, 0, 2), day.AddDate(0, 0, 3)); !repository.IsRoomUnavailable(err) {
+		t.Errorf("expected blocking a reserved night to fail, got %v", err)
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(1, day.AddDate(0, 0, -5), day.AddDate(0, 0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 2 {
+		t.Fatalf("expected a block and a reservation, got %d restrictions", len(restrictions))
+	}
+	block, reservation := restrictions[0], restrictions[1]
+	if block.ReservationID != 0 || reservation.ReservationID != resID {
+		t.Errorf("restrictions came back in the wrong order or with the wrong reservations: %+v", restrictions)
+	}
+
+	// deleting a reservation's restriction as if it were a block does nothing
+	if err := repo.DeleteBlockByID(reservation.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteBlockByID(block.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	restrictions, _ = repo.GetRestrictionsForRoomByDate(1, day.AddDate(0, 0, -5), day.AddDate(0, 0, 5))
+	if len(restrictions) != 1 || restrictions[0].ReservationID != resID {
+		t.Errorf("expected only the reservation to remain, got %+v", restrictions)
+	}
+}
+
+func TestMemoryTypedBlocks(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	types, err := repo.AllRestrictions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 5 || types[models.RestrictionCleaning-1].RestrictionName != "Cleaning buffer" {
+		t.Errorf("expected the five seeded restriction types, got %+v", types)
+	}
+
+	start := time.Date(2030, time.October, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 3)
+
+	var invalid = []struct {
+		name          string
+		restrictionID int
+		end           time.Time
+	}{
+		{"reservation type", models.RestrictionReservation, end},
+		{"external booking type", models.RestrictionExternal, end},
+		{"empty range", models.RestrictionMaintenance, start},
+	}
+	for _, e := range invalid {
+		if _, err := repo.InsertBlockForRoom(2, e.restrictionID, start, e.end); err != repository.ErrInvalidBlock {
+			t.Errorf("%s: expected ErrInvalidBlock, got %v", e.name, err)
+		}
+	}
+
+	id, err := repo.InsertBlockForRoom(2, models.RestrictionMaintenance, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.InsertBlockForRoom(2, models.RestrictionCleaning, end, end.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateReservation(models.Reservation{StartDate: end.AddDate(0, 0, 1), EndDate: end.AddDate(0, 0, 2), RoomID: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every type of restriction keeps the room off the market
+	for d := start; d.Before(end.AddDate(0, 0, 2)); d = d.AddDate(0, 0, 1) {
+		available, _ := repo.SearchAvailabilityByDatesByRoomID(d, d.AddDate(0, 0, 1), 2)
+		if available {
+			t.Errorf("expected room 2 to be unavailable on %s", d.Format("2006-01-02"))
+		}
+	}
+
+	blocks, err := repo.GetBlocksForRoomByDate(2, start, end.AddDate(0, 0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Restriction.RestrictionName != "Maintenance" || blocks[1].Restriction.RestrictionName != "Cleaning buffer" {
+		t.Fatalf("expected the maintenance and cleaning blocks but not the reservation, got %+v", blocks)
+	}
+
+	if err := repo.DeleteBlockByID(id); err != nil {
+		t.Fatal(err)
+	}
+	available, _ := repo.SearchAvailabilityByDatesByRoomID(start, end, 2)
+	if !available {
+		t.Error("expected deleting the maintenance block to free the room")
+	}
+}
+
+func TestMemoryStayRules(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	stayRules, err := repo.GetStayRulesForRoom(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stayRules.MinNights != 1 || stayRules.MaxNights != 0 {
+		t.Errorf("expected the default rules, got %+v", stayRules)
+	}
+
+	start := time.Date(2030, time.November, 1, 0, 0, 0, 0, time.UTC)
+	stayRules.MinNights = 2
+	stayRules.ClosedDates = []time.Time{start.AddDate(0, 0, 10)}
+	if err := repo.SetStayRules(stayRules); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetStayRules(rules.Default(1000)); err == nil {
+		t.Error("expected rules for a missing room to be refused")
+	}
+
+	var tests = []struct {
+		name  string
+		start time.Time
+		end   time.Time
+		valid bool
+	}{
+		{"too short", start, start.AddDate(0, 0, 1), false},
+		{"over a closed night", start.AddDate(0, 0, 9), start.AddDate(0, 0, 11), false},
+		{"keeps the rules", start, start.AddDate(0, 0, 2), true},
+	}
+
+	for _, e := range tests {
+		_, err := repo.CreateReservation(models.Reservation{StartDate: e.start, EndDate: e.end, RoomID: 1})
+		var broken *rules.Error
+		if errors.As(err, &broken) == e.valid {
+			t.Errorf("%s: expected valid to be %t, got %v", e.name, e.valid, err)
+		}
+		if !e.valid && (broken.RoomID != 1 || len(broken.Violations) != 1) {
+			t.Errorf("%s: unexpected error %v", e.name, broken)
+		}
+	}
+
+	// other rooms keep the default rules
+	if _, err := repo.CreateReservation(models.Reservation{StartDate: start, EndDate: start.AddDate(0, 0, 1), RoomID: 2}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryOutbox(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	testOutbox(t, repo, 1, "Your reservation is confirmed")
+
+	if err := repo.MarkOutboxSent(1000); err == nil {
+		t.Error("expected marking a missing message to fail")
+	}
+}
+
+func TestMemoryChangeAndCancel(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	testChangeAndCancel(t, repo, 1)
+}
+
+func TestMemoryICalToken(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	room, err := repo.GetRoomByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(room.ICalToken) != 40 {
+		t.Errorf("expected the room to have a token, got %q", room.ICalToken)
+	}
+
+	token, err := repo.ResetRoomICalToken(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == room.ICalToken {
+		t.Error("expected a new token")
+	}
+	if room, _ = repo.GetRoomByID(1); room.ICalToken != token {
+		t.Errorf("expected the new token to be stored, got %q", room.ICalToken)
+	}
+	if _, err := repo.ResetRoomICalToken(1000); err == nil {
+		t.Error("expected a missing room to be refused")
+	}
+}
+
+func TestMemoryDeleteBlockKeepsImportedBookings(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	night := time.Date(2030, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	feedID, err := repo.InsertICalFeed(models.ICalFeed{RoomID: 1, Name: "Elsewhere", URL: "https://elsewhere.example/room.ics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.SyncICalFeed(feedID, []models.ExternalBooking{{UID: "abc", StartDate: night, EndDate: night.AddDate(0, 0, 2)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restrictions, _ := repo.GetRestrictionsForRoomByDate(1, night, night.AddDate(0, 0, 2))
+	if len(restrictions) != 1 {
+		t.Fatalf("expected the imported booking, got %+v", restrictions)
+	}
+	if err := repo.DeleteBlockByID(restrictions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if restrictions, _ = repo.GetRestrictionsForRoomByDate(1, night, night.AddDate(0, 0, 2)); len(restrictions) != 1 {
+		t.Error("expected an imported booking not to be deleted as a block")
+	}
+}
+
+func TestMemoryGetRestrictionsForRoom(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	later := time.Date(2031, time.January, 1, 0, 0, 0, 0, time.UTC)
+	earlier := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for _, start := range []time.Time{later, earlier} {
+		if _, err := repo.InsertBlockForRoom(1, models.RestrictionMaintenance, start, start.AddDate(0, 0, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.InsertBlockForRoom(2, models.RestrictionMaintenance, earlier, earlier.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoom(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 2 || !restrictions[0].StartDate.Equal(earlier) || restrictions[0].Restriction.RestrictionName == "" {
+		t.Errorf("expected both of room 1's blocks in date order, got %+v", restrictions)
+	}
+}
@@ -2,39 +2,244 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"strings"
 	"time"
 
 	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"github.com/tsawler/bookings-app/internal/rules"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
-	return true
+// AllUsers returns all users, ordered by last name
+func (m *postgresDBRepo) AllUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var users []models.User
+
+	query := `
+	  select id, first_name, last_name, email, access_level, created_at, updated_at
+	  from users
+	  order by last_name, first_name
+	`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.AccessLevel,
+			&u.CreatedAt,
+			&u.UpdatedAt,
+		)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
+// GetUserByID returns a user by id
+func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var u models.User
+
+	query := `
+	  select id, first_name, last_name, email, password, access_level, created_at, updated_at
+	  from users
+	  where id = $1
+	`
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+// Authenticate checks testPassword against the bcrypt hash stored for email, and returns the user if they match
+func (m *postgresDBRepo) Authenticate(email, testPassword string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var u models.User
+
+	query := `
+	  select id, first_name, last_name, email, password, access_level, created_at, updated_at
+	  from users
+	  where email = $1
+	`
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return models.User{}, repository.ErrInvalidCredentials
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if err = checkPassword(u.Password, testPassword); err != nil {
+		return models.User{}, err
+	}
+
+	return u, nil
 }
 
 func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
+
+	return insertReservation(ctx, m.DB, res)
+}
+
+func (m *postgresDBRepo) InsertRoomRestriction(res models.RoomRestriction) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	return insertRoomRestriction(ctx, m.DB, res)
+}
+
+// CreateReservation stores a reservation, the restriction that blocks its room and any mail about it
+// in a single transaction. The room row is locked first, so concurrent bookings for the same room are
+// serialized and only one of any overlapping set can win; the losers get a *repository.RoomUnavailableError.
+// A stay that breaks the room's stay rules gets a *rules.Error.
+func (m *postgresDBRepo) CreateReservation(res models.Reservation, mail ...models.MailData) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	stayRules, err := getStayRules(ctx, tx, res.RoomID)
+	if err != nil {
+		return 0, err
+	}
+	if violations := stayRules.Check(res.StartDate, res.EndDate, rules.Today()); len(violations) > 0 {
+		return 0, &rules.Error{RoomID: res.RoomID, Violations: violations}
+	}
+
+	var numRows int
+	query := `
+	  select count(id)
+	  from room_restrictions
+	  where room_id = $1
+	    and $2 < end_date and $3 > start_date
+	`
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, &repository.RoomUnavailableError{
+			RoomID:    res.RoomID,
+			StartDate: res.StartDate,
+			EndDate:   res.EndDate,
+		}
+	}
+
+	newID, err := insertReservation(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = insertRoomRestriction(ctx, tx, models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		ReservationID: newID,
+		RestrictionID: models.RestrictionReservation,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range mail {
+		if _, err = insertOutboxMessage(ctx, tx, msg); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+func insertReservation(ctx context.Context, q queryer, res models.Reservation) (int, error) {
 	var newID int
 
+	if res.Code == "" {
+		res.Code = models.NewConfirmationCode()
+	}
+
 	stmt := `
 	  insert into reservations(
-		  first_name, last_name, email, phone,
+		  code, first_name, last_name, email, phone,
 		  start_date, end_date, room_id,
-		  created_at, updated_at
+		  nights, subtotal, fees, taxes, total,
+		  status, partner, created_at, updated_at
 	  )
 	  values (
-		  $1, $2, $3, $4,
-		  $5, $6, $7,
-		  now(), now()
+		  $1, $2, $3, $4, $5,
+		  $6, $7, $8,
+		  $9, $10, $11, $12, $13,
+		  $14, $15, now(), now()
 	  )
 	  returning id
 	`
-	err := m.DB.QueryRowContext(
+	err := q.QueryRowContext(
 		ctx,
 		stmt,
-		res.FirstName, res.LastName, res.Email, res.Phone,
+		res.Code, res.FirstName, res.LastName, res.Email, res.Phone,
 		res.StartDate, res.EndDate, res.RoomID,
+		res.Quote.Nights, res.Quote.Subtotal, res.Quote.Fees, res.Quote.Taxes, res.Quote.Total,
+		models.StatusPending, res.Partner,
 	).Scan(&newID)
 
 	if err != nil {
@@ -44,10 +249,7 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 	return newID, nil
 }
 
-func (m *postgresDBRepo) InsertRoomRestriction(res models.RoomRestriction) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-
-	defer cancel()
+func insertRoomRestriction(ctx context.Context, q queryer, res models.RoomRestriction) (int, error) {
 	var newID int
 
 	stmt := `
@@ -63,16 +265,1293 @@ func (m *postgresDBRepo) InsertRoomRestriction(res models.RoomRestriction) (int,
 	  )
 	  returning id
 	`
-	err := m.DB.QueryRowContext(
+	err := q.QueryRowContext(
 		ctx,
 		stmt,
 		res.StartDate, res.EndDate, res.RoomID,
-		res.ReservationID, res.RestrictionID,
+		nullInt(res.ReservationID), res.RestrictionID,
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, roomRestrictionError(err, res)
+	}
+
+	return newID, nil
+}
+
+// SearchAvailabilityByDatesByRoomID returns true if the room has no restrictions overlapping the dates
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var numRows int
+
+	query := `
+	  select count(id)
+	  from room_restrictions
+	  where room_id = $1
+	    and $2 < end_date and $3 > start_date
+	`
+	err := m.DB.QueryRowContext(ctx, query, roomID, start, end).Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+
+	return numRows == 0, nil
+}
+
+// SearchAvailabilityForAllRooms returns the rooms that are free for the whole date range
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var rooms []models.Room
+
+	query := `
+	  select ` + roomColumns + `
+	  from rooms r
+	  where r.id not in (
+		  select rr.room_id
+		  from room_restrictions rr
+		  where $1 < rr.end_date and $2 > rr.start_date
+	  )
+	  order by r.id
+	`
+	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// roomColumns are the columns scanRoom expects, from rooms r
+const roomColumns = `
+	  r.id, r.room_name, r.slug, r.description, r.capacity, r.amenities, r.photos,
+	  r.ical_token, r.created_at, r.updated_at
+`
+
+func scanRoom(row rowScanner) (models.Room, error) {
+	var room models.Room
+	var amenities, photos string
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&amenities,
+		&photos,
+		&room.ICalToken,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+	room.Amenities = splitLines(amenities)
+	room.Photos = splitLines(photos)
+	return room, err
+}
+
+// GetRoomByID gets a room by id
+func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms r where r.id = $1`
+	return scanRoom(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetRoomBySlug gets a room by its slug
+func (m *postgresDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms r where r.slug = $1`
+	return scanRoom(m.DB.QueryRowContext(ctx, query, slug))
+}
+
+// InsertRoom adds a room to the catalogue along with its base nightly rate, returning
+// repository.ErrDuplicateSlug if its slug is taken. The room is only added if its rate is too.
+func (m *postgresDBRepo) InsertRoom(room models.Room, nightlyRate int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var newID int
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+	  insert into rooms (room_name, slug, description, capacity, amenities, photos, ical_token, created_at, updated_at)
+	  values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	  returning id
+	`
+	err = tx.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		strings.Join(room.Amenities, "\n"),
+		strings.Join(room.Photos, "\n"),
+		newToken(),
+		time.Now(),
+		time.Now(),
 	).Scan(&newID)
+	if err != nil {
+		return 0, roomError(err)
+	}
+
+	if err = setRoomRate(ctx, tx, newID, nightlyRate); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// ResetRoomICalToken gives a room a new calendar token, so that links with the old one stop working
+func (m *postgresDBRepo) ResetRoomICalToken(roomID int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	token := newToken()
+	result, err := m.DB.ExecContext(ctx, `update rooms set ical_token = $1, updated_at = $2 where id = $3`, token, time.Now(), roomID)
+	if err != nil {
+		return "", err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	return token, nil
+}
+
+// GetRoomRate gets a room's base nightly rate
+func (m *postgresDBRepo) GetRoomRate(roomID int) (models.RoomRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var rate models.RoomRate
+
+	query := `
+	  select id, room_id, nightly_rate, created_at, updated_at
+	  from room_rates
+	  where room_id = $1
+	`
+	err := m.DB.QueryRowContext(ctx, query, roomID).Scan(
+		&rate.ID,
+		&rate.RoomID,
+		&rate.NightlyRate,
+		&rate.CreatedAt,
+		&rate.UpdatedAt,
+	)
+	if err != nil {
+		return rate, err
+	}
+
+	return rate, nil
+}
+
+// SetRoomRate sets a room's base nightly rate, adding it if the room has none yet
+func (m *postgresDBRepo) SetRoomRate(roomID, nightlyRate int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	return setRoomRate(ctx, m.DB, roomID, nightlyRate)
+}
 
+func setRoomRate(ctx context.Context, q queryer, roomID, nightlyRate int) error {
+	stmt := `
+	  insert into room_rates (room_id, nightly_rate, created_at, updated_at)
+	  values ($1, $2, now(), now())
+	  on conflict (room_id) do update set nightly_rate = excluded.nightly_rate, updated_at = now()
+	`
+	_, err := q.ExecContext(ctx, stmt, roomID, nightlyRate)
+	return err
+}
+
+// GetRateOverridesForRoom returns the overrides of a room's rate that could apply between the dates:
+// those without dates and the seasonal ones overlapping the dates
+func (m *postgresDBRepo) GetRateOverridesForRoom(roomID int, start, end time.Time) ([]models.RateOverride, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var overrides []models.RateOverride
+
+	query := `
+	  select id, room_id, name, start_date, end_date, weekends_only, nightly_rate, created_at, updated_at
+	  from rate_overrides
+	  where room_id = $1
+	    and (start_date is null or ($2 < end_date and $3 > start_date))
+	  order by id
+	`
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end)
+	if err != nil {
+		return overrides, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o models.RateOverride
+		var startDate, endDate sql.NullTime
+		err := rows.Scan(
+			&o.ID,
+			&o.RoomID,
+			&o.Name,
+			&startDate,
+			&endDate,
+			&o.WeekendsOnly,
+			&o.NightlyRate,
+			&o.CreatedAt,
+			&o.UpdatedAt,
+		)
+		if err != nil {
+			return overrides, err
+		}
+		o.StartDate = startDate.Time
+		o.EndDate = endDate.Time
+		overrides = append(overrides, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return overrides, err
+	}
+
+	return overrides, nil
+}
+
+// InsertRateOverride adds an override of a room's rate, returning its id. Leave the dates zero
+// for an override that applies all year.
+func (m *postgresDBRepo) InsertRateOverride(o models.RateOverride) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var newID int
+
+	stmt := `
+	  insert into rate_overrides (room_id, name, start_date, end_date, weekends_only, nightly_rate, created_at, updated_at)
+	  values ($1, $2, $3, $4, $5, $6, now(), now())
+	  returning id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		o.RoomID,
+		o.Name,
+		nullDate(o.StartDate),
+		nullDate(o.EndDate),
+		o.WeekendsOnly,
+		o.NightlyRate,
+	).Scan(&newID)
 	if err != nil {
 		return 0, err
 	}
 
 	return newID, nil
 }
+
+// GetStayRulesForRoom returns a room's stay rules, or the default rules if it has none of its own
+func (m *postgresDBRepo) GetStayRulesForRoom(roomID int) (rules.StayRules, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	return getStayRules(ctx, m.DB, roomID)
+}
+
+func getStayRules(ctx context.Context, q queryer, roomID int) (rules.StayRules, error) {
+	r := rules.Default(roomID)
+	var weekdays string
+
+	query := `
+	  select min_nights, max_nights, min_lead_days, max_advance_days, arrival_weekdays
+	  from stay_rules
+	  where room_id = $1
+	`
+	err := q.QueryRowContext(ctx, query, roomID).Scan(
+		&r.MinNights,
+		&r.MaxNights,
+		&r.MinLeadDays,
+		&r.MaxAdvanceDays,
+		&weekdays,
+	)
+	if err != nil && err != sql.ErrNoRows {
+		return r, err
+	}
+	r.ArrivalWeekdays = parseWeekdays(weekdays)
+
+	query = `
+	  select closed_date
+	  from room_closed_dates
+	  where room_id = $1
+	  order by closed_date
+	`
+	rows, err := q.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var closed time.Time
+		if err := rows.Scan(&closed); err != nil {
+			return r, err
+		}
+		r.ClosedDates = append(r.ClosedDates, closed)
+	}
+
+	if err = rows.Err(); err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+// SetStayRules replaces a room's stay rules and closed dates
+func (m *postgresDBRepo) SetStayRules(r rules.StayRules) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+	  insert into stay_rules (
+		  room_id, min_nights, max_nights, min_lead_days, max_advance_days, arrival_weekdays,
+		  created_at, updated_at
+	  )
+	  values ($1, $2, $3, $4, $5, $6, now(), now())
+	  on conflict (room_id) do update set
+		  min_nights = excluded.min_nights,
+		  max_nights = excluded.max_nights,
+		  min_lead_days = excluded.min_lead_days,
+		  max_advance_days = excluded.max_advance_days,
+		  arrival_weekdays = excluded.arrival_weekdays,
+		  updated_at = now()
+	`
+	_, err = tx.ExecContext(ctx, stmt,
+		r.RoomID,
+		r.MinNights,
+		r.MaxNights,
+		r.MinLeadDays,
+		r.MaxAdvanceDays,
+		formatWeekdays(r.ArrivalWeekdays),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_closed_dates where room_id = $1`, r.RoomID)
+	if err != nil {
+		return err
+	}
+
+	for _, closed := range r.ClosedDates {
+		stmt = `
+		  insert into room_closed_dates (room_id, closed_date, created_at, updated_at)
+		  values ($1, $2, now(), now())
+		  on conflict do nothing
+		`
+		_, err = tx.ExecContext(ctx, stmt, r.RoomID, closed)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// reservationColumns are the columns scanReservation expects, from reservations r joined to rooms rm
+const reservationColumns = `
+	  r.id, r.code, r.first_name, r.last_name, r.email, r.phone,
+	  r.start_date, r.end_date, r.room_id,
+	  r.nights, r.subtotal, r.fees, r.taxes, r.total,
+	  r.status, r.confirmed_at, r.checked_in_at, r.checked_out_at, r.cancelled_at, r.no_show_at, r.refund,
+	  r.partner,
+	  r.created_at, r.updated_at,
+	  rm.id, rm.room_name
+`
+
+func scanReservation(row rowScanner) (models.Reservation, error) {
+	var res models.Reservation
+	var confirmedAt, checkedInAt, checkedOutAt, cancelledAt, noShowAt sql.NullTime
+
+	err := row.Scan(
+		&res.ID,
+		&res.Code,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.Quote.Nights,
+		&res.Quote.Subtotal,
+		&res.Quote.Fees,
+		&res.Quote.Taxes,
+		&res.Quote.Total,
+		&res.Status,
+		&confirmedAt,
+		&checkedInAt,
+		&checkedOutAt,
+		&cancelledAt,
+		&noShowAt,
+		&res.Refund,
+		&res.Partner,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	)
+	res.ConfirmedAt = confirmedAt.Time
+	res.CheckedInAt = checkedInAt.Time
+	res.CheckedOutAt = checkedOutAt.Time
+	res.CancelledAt = cancelledAt.Time
+	res.NoShowAt = noShowAt.Time
+
+	return res, err
+}
+
+// queryReservations runs a query selecting reservationColumns and collects the results
+func (m *postgresDBRepo) queryReservations(query string, args ...interface{}) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var reservations []models.Reservation
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		res, err := scanReservation(rows)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, res)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// AllReservations returns every reservation, with its room
+func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
+	query := `
+	  select ` + reservationColumns + `
+	  from reservations r
+	  join rooms rm on (r.room_id = rm.id)
+	  order by r.start_date asc
+	`
+	return m.queryReservations(query)
+}
+
+// AllNewReservations returns the reservations nobody has confirmed yet, with their rooms
+func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
+	query := `
+	  select ` + reservationColumns + `
+	  from reservations r
+	  join rooms rm on (r.room_id = rm.id)
+	  where r.status = $1
+	  order by r.start_date asc
+	`
+	return m.queryReservations(query, models.StatusPending)
+}
+
+// GetReservationByID returns one reservation, with its room
+func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `
+	  select ` + reservationColumns + `
+	  from reservations r
+	  join rooms rm on (r.room_id = rm.id)
+	  where r.id = $1
+	`
+	return scanReservation(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetReservationByCode returns the reservation with a confirmation code, with its room
+func (m *postgresDBRepo) GetReservationByCode(code string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `
+	  select ` + reservationColumns + `
+	  from reservations r
+	  join rooms rm on (r.room_id = rm.id)
+	  where r.code = $1
+	`
+	return scanReservation(m.DB.QueryRowContext(ctx, query, code))
+}
+
+// UpdateReservation updates the guest details of a reservation
+func (m *postgresDBRepo) UpdateReservation(res models.Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `
+	  update reservations
+	  set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = now()
+	  where id = $5
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, res.FirstName, res.LastName, res.Email, res.Phone, res.ID)
+	return err
+}
+
+// ChangeReservationDates moves a reservation, and the restriction that blocks its room, to res.StartDate
+// and res.EndDate, storing res.Quote as its new price along with any mail about the change. Like
+// CreateReservation it locks the room first; dates taken by anything but the reservation itself give a
+// *repository.RoomUnavailableError, and dates that break the room's stay rules a *rules.Error. A
+// reservation that isn't in res.RoomID, or is no longer pending or confirmed, gives sql.ErrNoRows.
+func (m *postgresDBRepo) ChangeReservationDates(res models.Reservation, mail ...models.MailData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	stayRules, err := getStayRules(ctx, tx, res.RoomID)
+	if err != nil {
+		return err
+	}
+	if violations := stayRules.Check(res.StartDate, res.EndDate, rules.Today()); len(violations) > 0 {
+		return &rules.Error{RoomID: res.RoomID, Violations: violations}
+	}
+
+	var taken bool
+	query := `
+	  select exists(
+		  select 1 from room_restrictions
+		  where room_id = $1
+		    and (reservation_id is null or reservation_id <> $2)
+		    and $3 < end_date and $4 > start_date
+	  )
+	`
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.ID, res.StartDate, res.EndDate).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return &repository.RoomUnavailableError{
+			RoomID:    res.RoomID,
+			StartDate: res.StartDate,
+			EndDate:   res.EndDate,
+		}
+	}
+
+	stmt := `
+	  update reservations
+	  set start_date = $1, end_date = $2,
+		  nights = $3, subtotal = $4, fees = $5, taxes = $6, total = $7,
+		  updated_at = now()
+	  where id = $8 and room_id = $9 and status in ($10, $11)
+	`
+	result, err := tx.ExecContext(ctx, stmt,
+		res.StartDate, res.EndDate,
+		res.Quote.Nights, res.Quote.Subtotal, res.Quote.Fees, res.Quote.Taxes, res.Quote.Total,
+		res.ID, res.RoomID, models.StatusPending, models.StatusConfirmed,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	stmt = `
+	  update room_restrictions set start_date = $1, end_date = $2, updated_at = now()
+	  where reservation_id = $3
+	`
+	_, err = tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, res.ID)
+	if err != nil {
+		return roomRestrictionError(err, models.RoomRestriction{
+			StartDate: res.StartDate,
+			EndDate:   res.EndDate,
+			RoomID:    res.RoomID,
+		})
+	}
+
+	for _, msg := range mail {
+		if _, err = insertOutboxMessage(ctx, tx, msg); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpdateReservationStatus moves a reservation from status from to res.Status, storing its status times
+// and refund from res along with any mail about the move. A reservation that no longer holds its room
+// gives its restriction up in the same transaction. A reservation that isn't in status from, because
+// someone else moved it first, gives sql.ErrNoRows.
+func (m *postgresDBRepo) UpdateReservationStatus(res models.Reservation, from string, mail ...models.MailData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+	  update reservations
+	  set status = $1,
+		  confirmed_at = $2, checked_in_at = $3, checked_out_at = $4, cancelled_at = $5, no_show_at = $6,
+		  refund = $7, updated_at = now()
+	  where id = $8 and status = $9
+	`
+	result, err := tx.ExecContext(ctx, stmt,
+		res.Status,
+		nullDate(res.ConfirmedAt), nullDate(res.CheckedInAt), nullDate(res.CheckedOutAt),
+		nullDate(res.CancelledAt), nullDate(res.NoShowAt),
+		res.Refund, res.ID, from,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if !models.HoldsRoom(res.Status) {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, res.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, msg := range mail {
+		if _, err = insertOutboxMessage(ctx, tx, msg); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AllRooms returns every room
+func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var rooms []models.Room
+
+	query := `
+	  select ` + roomColumns + `
+	  from rooms r
+	  order by r.room_name
+	`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// GetRestrictionsForRoomByDate returns the restrictions of every type on a room that overlap the dates
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	query := `
+	  select rr.id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.room_id, rr.start_date, rr.end_date,
+		  r.id, r.restriction_name
+	  from room_restrictions rr
+	  left join restrictions r on (r.id = rr.restriction_id)
+	  where rr.room_id = $1
+	    and $2 < rr.end_date and $3 > rr.start_date
+	  order by rr.start_date
+	`
+	return m.queryRoomRestrictions(query, roomID, start, end)
+}
+
+// GetRestrictionsForRoom returns every restriction on a room, of every type
+func (m *postgresDBRepo) GetRestrictionsForRoom(roomID int) ([]models.RoomRestriction, error) {
+	query := `
+	  select rr.id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.room_id, rr.start_date, rr.end_date,
+		  r.id, r.restriction_name
+	  from room_restrictions rr
+	  left join restrictions r on (r.id = rr.restriction_id)
+	  where rr.room_id = $1
+	  order by rr.start_date
+	`
+	return m.queryRoomRestrictions(query, roomID)
+}
+
+// GetBlocksForRoomByDate returns the restrictions on a room that overlap the dates and are not held by reservations
+func (m *postgresDBRepo) GetBlocksForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	query := `
+	  select rr.id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.room_id, rr.start_date, rr.end_date,
+		  r.id, r.restriction_name
+	  from room_restrictions rr
+	  left join restrictions r on (r.id = rr.restriction_id)
+	  where rr.room_id = $1
+	    and rr.reservation_id is null
+	    and $2 < rr.end_date and $3 > rr.start_date
+	  order by rr.start_date
+	`
+	return m.queryRoomRestrictions(query, roomID, start, end)
+}
+
+// queryRoomRestrictions runs a query selecting room restrictions joined to their restriction type
+func (m *postgresDBRepo) queryRoomRestrictions(query string, args ...interface{}) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var restrictions []models.RoomRestriction
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err := rows.Scan(
+			&rr.ID,
+			&rr.ReservationID,
+			&rr.RestrictionID,
+			&rr.RoomID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&rr.Restriction.ID,
+			&rr.Restriction.RestrictionName,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, rr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// AllRestrictions returns the restriction types, ordered by id
+func (m *postgresDBRepo) AllRestrictions() ([]models.Restriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var restrictions []models.Restriction
+
+	query := `
+	  select id, restriction_name, created_at, updated_at
+	  from restrictions
+	  order by id
+	`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Restriction
+		err := rows.Scan(&r.ID, &r.RestrictionName, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// InsertBlockForRoom takes a room off the market from start to end with a non-reservation restriction,
+// returning the new room restriction's id
+func (m *postgresDBRepo) InsertBlockForRoom(roomID, restrictionID int, start, end time.Time) (int, error) {
+	if err := checkBlock(restrictionID, start, end); err != nil {
+		return 0, err
+	}
+
+	return m.InsertRoomRestriction(models.RoomRestriction{
+		StartDate:     start,
+		EndDate:       end,
+		RoomID:        roomID,
+		RestrictionID: restrictionID,
+	})
+}
+
+// DeleteBlockByID removes a block of any type. Restrictions held by reservations, and bookings imported
+// from other sites' calendars, which only their feed's sync removes, are left alone.
+func (m *postgresDBRepo) DeleteBlockByID(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `
+	  delete from room_restrictions
+	  where id = $1 and reservation_id is null and restriction_id <> $2 and ical_feed_id is null
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, id, models.RestrictionExternal)
+	return err
+}
+
+// icalFeedColumns are the columns scanICalFeed reads, in order
+const icalFeedColumns = `id, room_id, name, url, last_synced_at, last_error, created_at, updated_at`
+
+func scanICalFeed(row rowScanner) (models.ICalFeed, error) {
+	var f models.ICalFeed
+	var syncedAt sql.NullTime
+
+	err := row.Scan(
+		&f.ID,
+		&f.RoomID,
+		&f.Name,
+		&f.URL,
+		&syncedAt,
+		&f.LastError,
+		&f.CreatedAt,
+		&f.UpdatedAt,
+	)
+	f.LastSyncedAt = syncedAt.Time
+
+	return f, err
+}
+
+func (m *postgresDBRepo) queryICalFeeds(query string, args ...interface{}) ([]models.ICalFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var feeds []models.ICalFeed
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return feeds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		f, err := scanICalFeed(rows)
+		if err != nil {
+			return feeds, err
+		}
+		feeds = append(feeds, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return feeds, err
+	}
+
+	return feeds, nil
+}
+
+// AllICalFeeds returns the external calendars of every room
+func (m *postgresDBRepo) AllICalFeeds() ([]models.ICalFeed, error) {
+	return m.queryICalFeeds(`select ` + icalFeedColumns + ` from ical_feeds order by room_id, id`)
+}
+
+// GetICalFeedsForRoom returns the external calendars of a room
+func (m *postgresDBRepo) GetICalFeedsForRoom(roomID int) ([]models.ICalFeed, error) {
+	return m.queryICalFeeds(`select `+icalFeedColumns+` from ical_feeds where room_id = $1 order by id`, roomID)
+}
+
+// InsertICalFeed adds an external calendar to a room
+func (m *postgresDBRepo) InsertICalFeed(f models.ICalFeed) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+	var newID int
+
+	stmt := `
+	  insert into ical_feeds (room_id, name, url, created_at, updated_at)
+	  values ($1, $2, $3, now(), now())
+	  returning id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt, f.RoomID, f.Name, f.URL).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteICalFeed removes an external calendar, releasing the nights booked through it
+func (m *postgresDBRepo) DeleteICalFeed(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	// the feed's room restrictions go with it, by the foreign key
+	_, err := m.DB.ExecContext(ctx, `delete from ical_feeds where id = $1`, id)
+	return err
+}
+
+// SyncICalFeed makes the room's external bookings from a feed match bookings, keyed by UID: new ones
+// block their nights, moved ones move, and ones that are no longer in the feed release their nights.
+// A booking whose nights are already taken is left out and reported as a conflict. The room is locked,
+// as it is for a reservation, so the check for taken nights can't race a booking.
+func (m *postgresDBRepo) SyncICalFeed(feedID int, bookings []models.ExternalBooking) (models.ICalSyncResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+	defer cancel()
+	var result models.ICalSyncResult
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select room_id from ical_feeds where id = $1`, feedID).Scan(&roomID)
+	if err != nil {
+		return result, err
+	}
+
+	_, err = tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, roomID)
+	if err != nil {
+		return result, err
+	}
+
+	existing := make(map[string]models.RoomRestriction)
+	rows, err := tx.QueryContext(ctx, `
+	  select id, external_uid, start_date, end_date
+	  from room_restrictions
+	  where ical_feed_id = $1
+	`, feedID)
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var rr models.RoomRestriction
+		if err := rows.Scan(&rr.ID, &rr.ExternalUID, &rr.StartDate, &rr.EndDate); err != nil {
+			rows.Close()
+			return result, err
+		}
+		existing[rr.ExternalUID] = rr
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return result, err
+	}
+
+	bookings = uniqueExternalBookings(bookings)
+	wanted := make(map[string]bool)
+	for _, b := range bookings {
+		wanted[b.UID] = true
+	}
+
+	// release the nights of bookings that have gone first, so that they can be taken by the rest
+	for uid, rr := range existing {
+		if wanted[uid] {
+			continue
+		}
+		if _, err = tx.ExecContext(ctx, `delete from room_restrictions where id = $1`, rr.ID); err != nil {
+			return result, err
+		}
+		result.Removed++
+	}
+
+	for _, b := range bookings {
+		rr, found := existing[b.UID]
+		if found && rr.StartDate.Equal(b.StartDate) && rr.EndDate.Equal(b.EndDate) {
+			continue
+		}
+
+		var taken bool
+		err = tx.QueryRowContext(ctx, `
+		  select exists(
+			  select 1 from room_restrictions
+			  where room_id = $1 and id <> $2 and $3 < end_date and $4 > start_date
+		  )
+		`, roomID, rr.ID, b.StartDate, b.EndDate).Scan(&taken)
+		if err != nil {
+			return result, err
+		}
+		if taken {
+			result.Conflicts = append(result.Conflicts, b.UID)
+			continue
+		}
+
+		if found {
+			_, err = tx.ExecContext(ctx, `
+			  update room_restrictions set start_date = $1, end_date = $2, updated_at = now()
+			  where id = $3
+			`, b.StartDate, b.EndDate, rr.ID)
+			if err != nil {
+				return result, err
+			}
+			result.Updated++
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		  insert into room_restrictions (
+			  start_date, end_date, room_id, restriction_id, ical_feed_id, external_uid, created_at, updated_at
+		  )
+		  values ($1, $2, $3, $4, $5, $6, now(), now())
+		`, b.StartDate, b.EndDate, roomID, models.RestrictionExternal, feedID, b.UID)
+		if err != nil {
+			return result, err
+		}
+		result.Added++
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	  update ical_feeds set last_synced_at = now(), last_error = '', updated_at = now()
+	  where id = $1
+	`, feedID)
+	if err != nil {
+		return result, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// RecordICalFeedError notes why a feed could not be imported
+func (m *postgresDBRepo) RecordICalFeedError(feedID int, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `update ical_feeds set last_error = $1, updated_at = now() where id = $2`
+	_, err := m.DB.ExecContext(ctx, stmt, message, feedID)
+	return err
+}
+
+// insertOutboxMessage queues an email, to be sent as soon as the outbox worker gets to it. It is due from
+// the application's clock rather than the database's, since that is the clock the worker claims it by.
+func insertOutboxMessage(ctx context.Context, q queryer, msg models.MailData) (int, error) {
+	var newID int
+
+	stmt := `
+	  insert into outbox (
+		  to_address, from_address, subject, body, status, next_attempt_at, created_at, updated_at
+	  )
+	  values ($1, $2, $3, $4, $5, $6, now(), now())
+	  returning id
+	`
+	err := q.QueryRowContext(ctx, stmt,
+		msg.To, msg.From, msg.Subject, msg.Content, models.OutboxPending, outboxTime(time.Now()),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// outboxTime is t as stored in the outbox's timestamp columns, which have no time zone. Postgres drops
+// the offset of a time written to them, so every time is written in UTC to compare them all as instants.
+func outboxTime(t time.Time) time.Time {
+	return t.UTC()
+}
+
+// outboxColumns are the columns scanOutboxMessage reads, in order
+const outboxColumns = `id, to_address, from_address, subject, body, status, attempts, last_error,
+	next_attempt_at, sent_at, created_at, updated_at`
+
+func scanOutboxMessage(row rowScanner) (models.OutboxMessage, error) {
+	var msg models.OutboxMessage
+	var sentAt sql.NullTime
+
+	err := row.Scan(
+		&msg.ID,
+		&msg.Mail.To,
+		&msg.Mail.From,
+		&msg.Mail.Subject,
+		&msg.Mail.Content,
+		&msg.Status,
+		&msg.Attempts,
+		&msg.LastError,
+		&msg.NextAttemptAt,
+		&sentAt,
+		&msg.CreatedAt,
+		&msg.UpdatedAt,
+	)
+	msg.SentAt = sentAt.Time
+
+	return msg, err
+}
+
+func (m *postgresDBRepo) queryOutbox(ctx context.Context, query string, args ...interface{}) ([]models.OutboxMessage, error) {
+	var messages []models.OutboxMessage
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return messages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return messages, err
+	}
+
+	return messages, nil
+}
+
+// ClaimOutboxMessages returns up to limit pending messages that are due at now, oldest first. They are
+// put off for the lease, so that no other worker picks them up while they are being sent, and so that
+// they are tried again if the worker dies before recording what happened.
+func (m *postgresDBRepo) ClaimOutboxMessages(now time.Time, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `
+	  update outbox
+	  set next_attempt_at = $2, updated_at = now()
+	  where id in (
+		  select id
+		  from outbox
+		  where status = $3 and next_attempt_at <= $1
+		  order by next_attempt_at, id
+		  limit $4
+		  for update skip locked
+	  )
+	  returning ` + outboxColumns
+
+	return m.queryOutbox(ctx, query, outboxTime(now), outboxTime(now.Add(lease)), models.OutboxPending, limit)
+}
+
+// MarkOutboxSent records that a message was sent
+func (m *postgresDBRepo) MarkOutboxSent(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `
+	  update outbox
+	  set status = $1, attempts = attempts + 1, sent_at = now(), updated_at = now()
+	  where id = $2
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, models.OutboxSent, id)
+	return err
+}
+
+// RetryOutboxMessage records a failed attempt to send a message, which will be tried again at at
+func (m *postgresDBRepo) RetryOutboxMessage(id int, lastError string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `
+	  update outbox
+	  set attempts = attempts + 1, last_error = $1, next_attempt_at = $2, updated_at = now()
+	  where id = $3
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, lastError, outboxTime(at), id)
+	return err
+}
+
+// FailOutboxMessage records the last failed attempt to send a message, which won't be tried again
+func (m *postgresDBRepo) FailOutboxMessage(id int, lastError string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	stmt := `
+	  update outbox
+	  set status = $1, attempts = attempts + 1, last_error = $2, updated_at = now()
+	  where id = $3
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, models.OutboxFailed, lastError, id)
+	return err
+}
+
+// FailedOutboxMessages returns the messages that could not be sent, newest first
+func (m *postgresDBRepo) FailedOutboxMessages() ([]models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	query := `
+	  select ` + outboxColumns + `
+	  from outbox
+	  where status = $1
+	  order by updated_at desc, id desc
+	`
+	return m.queryOutbox(ctx, query, models.OutboxFailed)
+}
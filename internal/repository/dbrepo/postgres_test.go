@@ -0,0 +1,305 @@
+package dbrepo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/driver"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"github.com/tsawler/bookings-app/internal/rules"
+)
+
+// getTestPostgresRepo connects to the database named by TEST_DATABASE_URL, skipping the test if it is not set.
+// The database needs to have been migrated.
+func getTestPostgresRepo(t *testing.T) (*postgresDBRepo, func()) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping postgres test")
+	}
+
+	db, err := driver.NewDatabase(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`
+	  insert into restrictions (id, restriction_name, created_at, updated_at)
+	  values (1, 'Reservation', now(), now())
+	  on conflict do nothing
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &postgresDBRepo{App: &config.AppConfig{}, DB: db}
+	return repo, func() { db.Close() }
+}
+
+func TestPostgresCreateReservationConcurrent(t *testing.T) {
+	repo, closeDB := getTestPostgresRepo(t)
+	defer closeDB()
+
+	var roomID int
+	err := repo.DB.QueryRow(`
+	  insert into rooms (room_name, created_at, updated_at)
+	  values ('Concurrency Test Room', now(), now())
+	  returning id
+	`).Scan(&roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.DB.Exec(`delete from rooms where id = $1`, roomID)
+
+	testConcurrentBookings(t, repo, roomID)
+}
+
+func TestRoomRestrictionError(t *testing.T) {
+	rr := models.RoomRestriction{
+		RoomID:    1,
+		StartDate: time.Date(2030, time.March, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2030, time.March, 3, 0, 0, 0, 0, time.UTC),
+	}
+
+	pgErr := &pgconn.PgError{Code: exclusionViolation, ConstraintName: "room_restrictions_no_overlap"}
+	err := roomRestrictionError(pgErr, rr)
+	if !repository.IsRoomUnavailable(err) {
+		t.Errorf("expected exclusion violation to map to RoomUnavailableError, got %T", err)
+	}
+	if !errors.Is(err, pgErr) {
+		t.Error("expected the original postgres error to be wrapped")
+	}
+
+	other := &pgconn.PgError{Code: "23505"}
+	if err := roomRestrictionError(other, rr); err != other {
+		t.Errorf("expected other postgres errors to pass through unchanged, got %v", err)
+	}
+}
+
+func TestRoomError(t *testing.T) {
+	slug := &pgconn.PgError{Code: uniqueViolation, ConstraintName: roomsSlugIndex}
+	if err := roomError(slug); err != repository.ErrDuplicateSlug {
+		t.Errorf("expected a duplicate slug to map to ErrDuplicateSlug, got %v", err)
+	}
+
+	other := &pgconn.PgError{Code: uniqueViolation, ConstraintName: "rooms_ical_token_idx"}
+	if err := roomError(other); err != other {
+		t.Errorf("expected other unique violations to pass through unchanged, got %v", err)
+	}
+}
+
+func TestPostgresExclusionConstraint(t *testing.T) {
+	repo, closeDB := getTestPostgresRepo(t)
+	defer closeDB()
+
+	var roomID int
+	err := repo.DB.QueryRow(`
+	  insert into rooms (room_name, created_at, updated_at)
+	  values ('Exclusion Test Room', now(), now())
+	  returning id
+	`).Scan(&roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.DB.Exec(`delete from rooms where id = $1`, roomID)
+
+	start := time.Date(2030, time.April, 1, 0, 0, 0, 0, time.UTC)
+	resID, err := repo.InsertReservation(models.Reservation{
+		FirstName: "First",
+		LastName:  "Guest",
+		Email:     "first@here.com",
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, 2),
+		RoomID:    roomID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := models.RoomRestriction{
+		StartDate:     start,
+		EndDate:       start.AddDate(0, 0, 2),
+		RoomID:        roomID,
+		ReservationID: resID,
+		RestrictionID: models.RestrictionReservation,
+	}
+	if _, err := repo.InsertRoomRestriction(rr); err != nil {
+		t.Fatal(err)
+	}
+
+	// a stay starting on the previous guest's departure day does not overlap
+	rr.StartDate = start.AddDate(0, 0, 2)
+	rr.EndDate = start.AddDate(0, 0, 4)
+	if _, err := repo.InsertRoomRestriction(rr); err != nil {
+		t.Errorf("back to back restrictions should be allowed, got %s", err)
+	}
+
+	rr.StartDate = start.AddDate(0, 0, 1)
+	rr.EndDate = start.AddDate(0, 0, 3)
+	_, err = repo.InsertRoomRestriction(rr)
+	if !repository.IsRoomUnavailable(err) {
+		t.Errorf("expected overlapping restriction to be refused, got %v", err)
+	}
+}
+
+func TestPostgresAvailability(t *testing.T) {
+	repo, closeDB := getTestPostgresRepo(t)
+	defer closeDB()
+
+	var roomID int
+	err := repo.DB.QueryRow(`
+	  insert into rooms (room_name, created_at, updated_at)
+	  values ('Availability Test Room', now(), now())
+	  returning id
+	`).Scan(&roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.DB.Exec(`delete from rooms where id = $1`, roomID)
+
+	start := time.Date(2030, time.May, 10, 0, 0, 0, 0, time.UTC)
+	_, err = repo.InsertRoomRestriction(models.RoomRestriction{
+		StartDate:     start,
+		EndDate:       start.AddDate(0, 0, 3),
+		RoomID:        roomID,
+		RestrictionID: models.RestrictionReservation,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name      string
+		start     time.Time
+		end       time.Time
+		available bool
+	}{
+		{"same dates", start, start.AddDate(0, 0, 3), false},
+		{"inside", start.AddDate(0, 0, 1), start.AddDate(0, 0, 2), false},
+		{"overlaps arrival", start.AddDate(0, 0, -2), start.AddDate(0, 0, 1), false},
+		{"overlaps departure", start.AddDate(0, 0, 2), start.AddDate(0, 0, 5), false},
+		{"ends on arrival day", start.AddDate(0, 0, -2), start, true},
+		{"starts on departure day", start.AddDate(0, 0, 3), start.AddDate(0, 0, 5), true},
+	}
+
+	for _, e := range tests {
+		available, err := repo.SearchAvailabilityByDatesByRoomID(e.start, e.end, roomID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if available != e.available {
+			t.Errorf("%s: expected available to be %t but got %t", e.name, e.available, available)
+		}
+
+		rooms, err := repo.SearchAvailabilityForAllRooms(e.start, e.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var offered bool
+		for _, room := range rooms {
+			offered = offered || room.ID == roomID
+		}
+		if offered != e.available {
+			t.Errorf("%s: expected the room to be offered to be %t", e.name, e.available)
+		}
+	}
+}
+
+func TestPostgresStayRules(t *testing.T) {
+	repo, closeDB := getTestPostgresRepo(t)
+	defer closeDB()
+
+	var roomID int
+	err := repo.DB.QueryRow(`
+	  insert into rooms (room_name, created_at, updated_at)
+	  values ('Stay Rules Test Room', now(), now())
+	  returning id
+	`).Scan(&roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.DB.Exec(`delete from rooms where id = $1`, roomID)
+
+	start := time.Date(2030, time.May, 3, 0, 0, 0, 0, time.UTC)
+	stayRules := rules.StayRules{
+		RoomID:          roomID,
+		MinNights:       2,
+		MaxNights:       7,
+		ArrivalWeekdays: []time.Weekday{time.Friday},
+		ClosedDates:     []time.Time{start.AddDate(0, 0, 7)},
+	}
+	if err := repo.SetStayRules(stayRules); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := repo.GetStayRulesForRoom(roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.MinNights != 2 || saved.MaxNights != 7 || len(saved.ArrivalWeekdays) != 1 || len(saved.ClosedDates) != 1 {
+		t.Errorf("rules were not saved: %+v", saved)
+	}
+
+	_, err = repo.CreateReservation(models.Reservation{StartDate: start, EndDate: start.AddDate(0, 0, 1), RoomID: roomID})
+	var broken *rules.Error
+	if !errors.As(err, &broken) {
+		t.Errorf("expected a one night stay to break the rules, got %v", err)
+	}
+}
+
+func TestPostgresOutbox(t *testing.T) {
+	repo, closeDB := getTestPostgresRepo(t)
+	defer closeDB()
+
+	var roomID int
+	err := repo.DB.QueryRow(`
+	  insert into rooms (room_name, created_at, updated_at)
+	  values ('Outbox Test Room', now(), now())
+	  returning id
+	`).Scan(&roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.DB.Exec(`delete from rooms where id = $1`, roomID)
+
+	subject := fmt.Sprintf("Outbox test %d", roomID)
+	defer repo.DB.Exec(`delete from outbox where subject = $1`, subject)
+
+	testOutbox(t, repo, roomID, subject)
+}
+
+func TestPostgresChangeAndCancel(t *testing.T) {
+	repo, closeDB := getTestPostgresRepo(t)
+	defer closeDB()
+
+	var roomID int
+	err := repo.DB.QueryRow(`
+	  insert into rooms (room_name, created_at, updated_at)
+	  values ('Change and Cancel Test Room', now(), now())
+	  returning id
+	`).Scan(&roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.DB.Exec(`delete from rooms where id = $1`, roomID)
+
+	testChangeAndCancel(t, repo, roomID)
+}
+
+func TestWeekdays(t *testing.T) {
+	days := []time.Weekday{time.Friday, time.Saturday}
+	if s := formatWeekdays(days); s != "5,6" {
+		t.Errorf("expected 5,6 but got %s", s)
+	}
+	if parsed := parseWeekdays("5, 6,9,x"); len(parsed) != 2 || parsed[0] != time.Friday || parsed[1] != time.Saturday {
+		t.Errorf("expected Friday and Saturday, got %v", parsed)
+	}
+	if parsed := parseWeekdays(""); len(parsed) != 0 {
+		t.Errorf("expected no days, got %v", parsed)
+	}
+}
@@ -0,0 +1,222 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// testConcurrentBookings has several guests race to book overlapping stays in roomID, and checks
+// that exactly one of them gets the room
+func testConcurrentBookings(t *testing.T, repo repository.DatabaseRepo, roomID int) {
+	const attempts = 10
+	start := time.Date(2030, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	results := make(chan error, attempts)
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := repo.CreateReservation(models.Reservation{
+				FirstName: "Guest",
+				LastName:  "Number",
+				Email:     "guest@here.com",
+				StartDate: start.AddDate(0, 0, i%2),
+				EndDate:   start.AddDate(0, 0, 3),
+				RoomID:    roomID,
+			})
+			results <- err
+		}(i)
+	}
+
+	wg.Wait()
+	close(results)
+
+	won, lost := 0, 0
+	for err := range results {
+		switch {
+		case err == nil:
+			won++
+		case repository.IsRoomUnavailable(err):
+			lost++
+		default:
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+
+	if won != 1 {
+		t.Errorf("expected exactly one booking to succeed, but %d did", won)
+	}
+	if lost != attempts-1 {
+		t.Errorf("expected %d bookings to be refused, but %d were", attempts-1, lost)
+	}
+}
+
+// testOutbox books roomID with mail about it, then takes the mail through a retry and a failure. The worker's
+// clock is in time zones either side of the database's, so the mail is only due on time if every time is
+// compared as an instant.
+func testOutbox(t *testing.T, repo repository.DatabaseRepo, roomID int, subject string) {
+	start := time.Date(2030, time.April, 1, 0, 0, 0, 0, time.UTC)
+	_, err := repo.CreateReservation(models.Reservation{
+		FirstName: "Guest",
+		LastName:  "Number",
+		Email:     "guest@here.com",
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, 2),
+		RoomID:    roomID,
+	}, models.MailData{To: "guest@here.com", Subject: subject, Content: "<p>Hello</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// find returns the message about this booking among those that are due at now
+	find := func(now time.Time) (models.OutboxMessage, bool) {
+		messages, err := repo.ClaimOutboxMessages(now, 100, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range messages {
+			if msg.Mail.Subject == subject {
+				return msg, true
+			}
+		}
+		return models.OutboxMessage{}, false
+	}
+
+	behind := time.FixedZone("UTC-7", -7*60*60)
+	ahead := time.FixedZone("UTC+10", 10*60*60)
+
+	now := time.Now().Add(time.Second).In(behind)
+	msg, ok := find(now)
+	if !ok {
+		t.Fatal("expected the booking's mail to be due")
+	}
+	if msg.Status != models.OutboxPending || msg.Mail.To != "guest@here.com" || msg.Mail.Content != "<p>Hello</p>" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	// a claimed message is not handed out again until its lease is up
+	if _, ok := find(now); ok {
+		t.Error("expected the claimed message to be leased")
+	}
+
+	if err := repo.RetryOutboxMessage(msg.ID, "connection refused", now.Add(time.Hour).In(ahead)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := find(now.Add(30 * time.Minute)); ok {
+		t.Error("expected the message to wait for its retry")
+	}
+	msg, ok = find(now.Add(time.Hour))
+	if !ok || msg.Attempts != 1 || msg.LastError != "connection refused" {
+		t.Fatalf("expected the message to be retried, got %+v", msg)
+	}
+
+	if err := repo.FailOutboxMessage(msg.ID, "mailbox unavailable"); err != nil {
+		t.Fatal(err)
+	}
+	failed, err := repo.FailedOutboxMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) == 0 || failed[0].ID != msg.ID || failed[0].Attempts != 2 || failed[0].LastError != "mailbox unavailable" {
+		t.Errorf("expected the message to be dead-lettered, got %+v", failed)
+	}
+	if _, ok := find(now.Add(48 * time.Hour)); ok {
+		t.Error("expected a failed message not to be tried again")
+	}
+}
+
+// testChangeAndCancel books two stays in roomID, moves the first within its own nights and against the
+// second, then confirms and cancels it, checking that its nights are freed while the reservation itself
+// is kept with the time of each move
+func testChangeAndCancel(t *testing.T, repo repository.DatabaseRepo, roomID int) {
+	start := time.Date(2030, time.October, 1, 0, 0, 0, 0, time.UTC)
+	book := func(start time.Time, nights int) models.Reservation {
+		id, err := repo.CreateReservation(models.Reservation{
+			FirstName: "Guest",
+			LastName:  "Number",
+			Email:     "guest@here.com",
+			StartDate: start,
+			EndDate:   start.AddDate(0, 0, nights),
+			RoomID:    roomID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := repo.GetReservationByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	res := book(start, 3)
+	book(start.AddDate(0, 0, 5), 2)
+
+	// a stay can move onto the nights it already holds
+	res.StartDate = start.AddDate(0, 0, 1)
+	res.EndDate = start.AddDate(0, 0, 5)
+	res.Quote = models.Quote{Nights: 4, Total: 40000}
+	if err := repo.ChangeReservationDates(res); err != nil {
+		t.Fatal(err)
+	}
+	moved, _ := repo.GetReservationByID(res.ID)
+	if !moved.StartDate.Equal(res.StartDate) || !moved.EndDate.Equal(res.EndDate) || moved.Quote.Total != 40000 {
+		t.Errorf("expected the stay to move, got %s to %s for %d", moved.StartDate, moved.EndDate, moved.Quote.Total)
+	}
+	if available, _ := repo.SearchAvailabilityByDatesByRoomID(start, start.AddDate(0, 0, 1), roomID); !available {
+		t.Error("expected the night the stay moved off to be freed")
+	}
+	if available, _ := repo.SearchAvailabilityByDatesByRoomID(start.AddDate(0, 0, 4), start.AddDate(0, 0, 5), roomID); available {
+		t.Error("expected the night the stay moved onto to be taken")
+	}
+
+	// but not onto someone else's
+	res.EndDate = start.AddDate(0, 0, 6)
+	if err := repo.ChangeReservationDates(res); !repository.IsRoomUnavailable(err) {
+		t.Errorf("expected moving onto another stay to be refused, got %v", err)
+	}
+
+	if res.Status != models.StatusPending {
+		t.Errorf("expected a new reservation to be pending, got %q", res.Status)
+	}
+	confirmedAt := time.Date(2030, time.September, 1, 12, 0, 0, 0, time.UTC)
+	res.Status = models.StatusConfirmed
+	res.ConfirmedAt = confirmedAt
+	if err := repo.UpdateReservationStatus(res, models.StatusPending); err != nil {
+		t.Fatal(err)
+	}
+	if available, _ := repo.SearchAvailabilityByDatesByRoomID(start.AddDate(0, 0, 1), start.AddDate(0, 0, 5), roomID); available {
+		t.Error("expected a confirmed stay to keep its nights")
+	}
+
+	res.Status = models.StatusCancelled
+	res.CancelledAt = confirmedAt.Add(time.Hour)
+	res.Refund = 20000
+	if err := repo.UpdateReservationStatus(res, models.StatusConfirmed); err != nil {
+		t.Fatal(err)
+	}
+	cancelled, err := repo.GetReservationByID(res.ID)
+	if err != nil || !cancelled.Cancelled() || cancelled.Refund != 20000 {
+		t.Errorf("expected the reservation to be kept and marked cancelled, got %+v (%v)", cancelled, err)
+	}
+	if !cancelled.ConfirmedAt.Equal(confirmedAt) || !cancelled.CancelledAt.Equal(res.CancelledAt) || !cancelled.CheckedInAt.IsZero() {
+		t.Errorf("expected the times of each move to be kept, got %s and %s", cancelled.ConfirmedAt, cancelled.CancelledAt)
+	}
+	if available, _ := repo.SearchAvailabilityByDatesByRoomID(start.AddDate(0, 0, 1), start.AddDate(0, 0, 5), roomID); !available {
+		t.Error("expected cancelling to free the room")
+	}
+
+	// a move from a status the reservation has already left is someone else's lost race
+	if err := repo.UpdateReservationStatus(res, models.StatusConfirmed); err != sql.ErrNoRows {
+		t.Errorf("expected cancelling twice to give sql.ErrNoRows, got %v", err)
+	}
+	if err := repo.ChangeReservationDates(cancelled); err != sql.ErrNoRows {
+		t.Errorf("expected a cancelled stay not to move, got %v", err)
+	}
+}
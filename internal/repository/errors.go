@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidCredentials is returned by Authenticate when the email or password is wrong
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrDuplicateSlug is returned when a room is saved with a slug another room already has
+var ErrDuplicateSlug = errors.New("another room already has that slug")
+
+// ErrInvalidBlock is returned when a block is given the Reservation or External booking restriction type,
+// or does not end after it starts
+var ErrInvalidBlock = errors.New("a block needs a restriction type other than reservation or external booking, and an end date after its start date")
+
+// RoomUnavailableError is returned when a booking overlaps an existing restriction on the room
+type RoomUnavailableError struct {
+	RoomID    int
+	StartDate time.Time
+	EndDate   time.Time
+	// Err is the underlying database error, if the overlap was caught by the database itself
+	Err error
+}
+
+func (e *RoomUnavailableError) Error() string {
+	return fmt.Sprintf(
+		"room %d is no longer available from %s to %s",
+		e.RoomID,
+		e.StartDate.Format("2006-01-02"),
+		e.EndDate.Format("2006-01-02"),
+	)
+}
+
+func (e *RoomUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// IsRoomUnavailable reports whether err is, or wraps, a RoomUnavailableError
+func IsRoomUnavailable(err error) bool {
+	var unavailable *RoomUnavailableError
+	return errors.As(err, &unavailable)
+}
@@ -1,10 +1,62 @@
 package repository
 
-import "github.com/tsawler/bookings-app/internal/models"
+import (
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/rules"
+)
 
 type DatabaseRepo interface {
-	AllUsers() bool
+	AllUsers() ([]models.User, error)
+	GetUserByID(id int) (models.User, error)
+	Authenticate(email, testPassword string) (models.User, error)
 
 	InsertReservation(res models.Reservation) (int, error)
 	InsertRoomRestriction(res models.RoomRestriction) (int, error)
+	CreateReservation(res models.Reservation, mail ...models.MailData) (int, error)
+	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
+	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
+	GetRoomByID(id int) (models.Room, error)
+
+	AllReservations() ([]models.Reservation, error)
+	AllNewReservations() ([]models.Reservation, error)
+	GetReservationByID(id int) (models.Reservation, error)
+	GetReservationByCode(code string) (models.Reservation, error)
+	UpdateReservation(res models.Reservation) error
+	ChangeReservationDates(res models.Reservation, mail ...models.MailData) error
+	UpdateReservationStatus(res models.Reservation, from string, mail ...models.MailData) error
+
+	AllRooms() ([]models.Room, error)
+	GetRoomBySlug(slug string) (models.Room, error)
+	InsertRoom(room models.Room, nightlyRate int) (int, error)
+	ResetRoomICalToken(roomID int) (string, error)
+
+	GetRoomRate(roomID int) (models.RoomRate, error)
+	SetRoomRate(roomID, nightlyRate int) error
+	GetRateOverridesForRoom(roomID int, start, end time.Time) ([]models.RateOverride, error)
+	InsertRateOverride(o models.RateOverride) (int, error)
+
+	GetStayRulesForRoom(roomID int) (rules.StayRules, error)
+	SetStayRules(r rules.StayRules) error
+	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	GetRestrictionsForRoom(roomID int) ([]models.RoomRestriction, error)
+
+	ClaimOutboxMessages(now time.Time, limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	MarkOutboxSent(id int) error
+	RetryOutboxMessage(id int, lastError string, at time.Time) error
+	FailOutboxMessage(id int, lastError string) error
+	FailedOutboxMessages() ([]models.OutboxMessage, error)
+
+	AllRestrictions() ([]models.Restriction, error)
+	GetBlocksForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlockForRoom(roomID, restrictionID int, start, end time.Time) (int, error)
+	DeleteBlockByID(id int) error
+
+	AllICalFeeds() ([]models.ICalFeed, error)
+	GetICalFeedsForRoom(roomID int) ([]models.ICalFeed, error)
+	InsertICalFeed(f models.ICalFeed) (int, error)
+	DeleteICalFeed(id int) error
+	SyncICalFeed(feedID int, bookings []models.ExternalBooking) (models.ICalSyncResult, error)
+	RecordICalFeedError(feedID int, message string) error
 }
@@ -0,0 +1,136 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StayRules limit the stays that can be booked in a room. Zero values mean no limit, except that
+// a stay is always at least one night, can't end before it starts and can't arrive in the past.
+type StayRules struct {
+	RoomID    int
+	MinNights int
+	MaxNights int
+	// MinLeadDays is how many days ahead of arrival a stay must be booked
+	MinLeadDays int
+	// MaxAdvanceDays is how many days ahead bookings open
+	MaxAdvanceDays int
+	// ArrivalWeekdays are the days guests may arrive on; any day if empty
+	ArrivalWeekdays []time.Weekday
+	// ClosedDates are nights the room can't be booked for
+	ClosedDates []time.Time
+}
+
+// Default returns the rules for a room that has none of its own
+func Default(roomID int) StayRules {
+	return StayRules{RoomID: roomID, MinNights: 1}
+}
+
+// The dates a Violation can be about
+const (
+	Arrival   = "arrival"
+	Departure = "departure"
+)
+
+// Violation is a broken rule, with a message for the guest
+type Violation struct {
+	// Field is Arrival or Departure
+	Field   string
+	Message string
+}
+
+// Error is returned when booking a stay that breaks a room's rules
+type Error struct {
+	RoomID     int
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	var messages []string
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return fmt.Sprintf("stay in room %d breaks its rules: %s", e.RoomID, strings.Join(messages, "; "))
+}
+
+// Today returns the current date, at midnight UTC like the dates parsed from forms
+func Today() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Check returns the rules a stay from start to end would break, if booked today
+func (r StayRules) Check(start, end, today time.Time) []Violation {
+	var violations []Violation
+	add := func(field, format string, args ...interface{}) {
+		violations = append(violations, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !end.After(start) {
+		add(Departure, "Departure must be after arrival")
+		return violations
+	}
+
+	if start.Before(today) {
+		add(Arrival, "Arrival can't be in the past")
+	} else if r.MinLeadDays > 0 && start.Before(today.AddDate(0, 0, r.MinLeadDays)) {
+		add(Arrival, "Stays must be booked at least %s ahead", days(r.MinLeadDays))
+	}
+
+	if r.MaxAdvanceDays > 0 && start.After(today.AddDate(0, 0, r.MaxAdvanceDays)) {
+		add(Arrival, "Stays can only be booked up to %s ahead", days(r.MaxAdvanceDays))
+	}
+
+	if len(r.ArrivalWeekdays) > 0 && !r.arrivesOn(start.Weekday()) {
+		var names []string
+		for _, d := range r.ArrivalWeekdays {
+			names = append(names, d.String())
+		}
+		add(Arrival, "Arrivals are only on %s", strings.Join(names, ", "))
+	}
+
+	nights := Nights(start, end)
+	if nights < r.MinNights {
+		add(Departure, "Stays in this room are at least %s", pluralNights(r.MinNights))
+	}
+	if r.MaxNights > 0 && nights > r.MaxNights {
+		add(Departure, "Stays in this room are at most %s", pluralNights(r.MaxNights))
+	}
+
+	for _, closed := range r.ClosedDates {
+		if !closed.Before(start) && closed.Before(end) {
+			add(Arrival, "The room is closed on %s", closed.Format("2006-01-02"))
+		}
+	}
+
+	return violations
+}
+
+func (r StayRules) arrivesOn(day time.Weekday) bool {
+	for _, d := range r.ArrivalWeekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Nights returns the number of nights from start to end
+func Nights(start, end time.Time) int {
+	return int(end.Sub(start).Hours()+12) / 24
+}
+
+func days(n int) string {
+	if n == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", n)
+}
+
+func pluralNights(n int) string {
+	if n == 1 {
+		return "1 night"
+	}
+	return fmt.Sprintf("%d nights", n)
+}
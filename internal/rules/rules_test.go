@@ -0,0 +1,64 @@
+package rules
+
+import (
+	"testing"
+	"time"
+)
+
+// Monday the 1st of July 2030
+var today = time.Date(2030, time.July, 1, 0, 0, 0, 0, time.UTC)
+
+func TestStayRules_Check(t *testing.T) {
+	strict := StayRules{
+		RoomID:          1,
+		MinNights:       2,
+		MaxNights:       7,
+		MinLeadDays:     2,
+		MaxAdvanceDays:  90,
+		ArrivalWeekdays: []time.Weekday{time.Friday, time.Saturday},
+		ClosedDates:     []time.Time{today.AddDate(0, 0, 25)},
+	}
+	friday := today.AddDate(0, 0, 4)
+
+	var tests = []struct {
+		name     string
+		rules    StayRules
+		start    time.Time
+		end      time.Time
+		expected []Violation
+	}{
+		{"default rules", Default(1), today, today.AddDate(0, 0, 1), nil},
+		{"empty stay", Default(1), today, today, []Violation{{Departure, "Departure must be after arrival"}}},
+		{"past arrival", Default(1), today.AddDate(0, 0, -1), today.AddDate(0, 0, 1), []Violation{{Arrival, "Arrival can't be in the past"}}},
+		{"keeps the strict rules", strict, friday, friday.AddDate(0, 0, 2), nil},
+		{"too short", strict, friday, friday.AddDate(0, 0, 1), []Violation{{Departure, "Stays in this room are at least 2 nights"}}},
+		{"too long", strict, friday, friday.AddDate(0, 0, 8), []Violation{{Departure, "Stays in this room are at most 7 nights"}}},
+		{"too soon", strict, today.AddDate(0, 0, 1), today.AddDate(0, 0, 3), []Violation{
+			{Arrival, "Stays must be booked at least 2 days ahead"},
+			{Arrival, "Arrivals are only on Friday, Saturday"},
+		}},
+		{"too far ahead", strict, friday.AddDate(0, 0, 91), friday.AddDate(0, 0, 93), []Violation{{Arrival, "Stays can only be booked up to 90 days ahead"}}},
+		{"over a closed night", strict, friday.AddDate(0, 0, 21), friday.AddDate(0, 0, 23), []Violation{{Arrival, "The room is closed on 2030-07-26"}}},
+		{"leaving on a closed day", strict, friday.AddDate(0, 0, 14), friday.AddDate(0, 0, 21), nil},
+	}
+
+	for _, e := range tests {
+		violations := e.rules.Check(e.start, e.end, today)
+		if len(violations) != len(e.expected) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, violations)
+			continue
+		}
+		for i := range violations {
+			if violations[i] != e.expected[i] {
+				t.Errorf("%s: expected %v but got %v", e.name, e.expected[i], violations[i])
+			}
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	err := &Error{RoomID: 3, Violations: []Violation{{Arrival, "first"}, {Departure, "second"}}}
+	if err.Error() != "stay in room 3 breaks its rules: first; second" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
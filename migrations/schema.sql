@@ -16,10 +16,163 @@ SET xmloption = content;
 SET client_min_messages = warning;
 SET row_security = off;
 
+--
+-- Name: btree_gist; Type: EXTENSION; Schema: -; Owner: -
+--
+
+CREATE EXTENSION IF NOT EXISTS btree_gist WITH SCHEMA public;
+
+
+--
+-- Name: EXTENSION btree_gist; Type: COMMENT; Schema: -; Owner: 
+--
+
+COMMENT ON EXTENSION btree_gist IS 'support for indexing common datatypes in GiST';
+
+
+--
+-- Name: pgcrypto; Type: EXTENSION; Schema: -; Owner: -
+--
+
+CREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public;
+
+
+--
+-- Name: EXTENSION pgcrypto; Type: COMMENT; Schema: -; Owner: 
+--
+
+COMMENT ON EXTENSION pgcrypto IS 'cryptographic functions';
+
+
 SET default_tablespace = '';
 
 SET default_with_oids = false;
 
+--
+-- Name: ical_feeds; Type: TABLE; Schema: public; Owner: saylordb
+--
+
+CREATE TABLE public.ical_feeds (
+    id integer NOT NULL,
+    room_id integer NOT NULL,
+    name character varying(255) DEFAULT ''::character varying NOT NULL,
+    url text NOT NULL,
+    last_synced_at timestamp without time zone,
+    last_error text DEFAULT ''::text NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.ical_feeds OWNER TO saylordb;
+
+--
+-- Name: ical_feeds_id_seq; Type: SEQUENCE; Schema: public; Owner: saylordb
+--
+
+CREATE SEQUENCE public.ical_feeds_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.ical_feeds_id_seq OWNER TO saylordb;
+
+--
+-- Name: ical_feeds_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: saylordb
+--
+
+ALTER SEQUENCE public.ical_feeds_id_seq OWNED BY public.ical_feeds.id;
+
+
+--
+-- Name: outbox; Type: TABLE; Schema: public; Owner: saylordb
+--
+
+CREATE TABLE public.outbox (
+    id integer NOT NULL,
+    to_address character varying(255) NOT NULL,
+    from_address character varying(255) DEFAULT ''::character varying NOT NULL,
+    subject character varying(255) NOT NULL,
+    body text NOT NULL,
+    status character varying(255) DEFAULT 'pending'::character varying NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    last_error text DEFAULT ''::text NOT NULL,
+    next_attempt_at timestamp without time zone NOT NULL,
+    sent_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.outbox OWNER TO saylordb;
+
+--
+-- Name: outbox_id_seq; Type: SEQUENCE; Schema: public; Owner: saylordb
+--
+
+CREATE SEQUENCE public.outbox_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.outbox_id_seq OWNER TO saylordb;
+
+--
+-- Name: outbox_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: saylordb
+--
+
+ALTER SEQUENCE public.outbox_id_seq OWNED BY public.outbox.id;
+
+
+--
+-- Name: rate_overrides; Type: TABLE; Schema: public; Owner: saylordb
+--
+
+CREATE TABLE public.rate_overrides (
+    id integer NOT NULL,
+    room_id integer NOT NULL,
+    name character varying(255) DEFAULT ''::character varying NOT NULL,
+    start_date date,
+    end_date date,
+    weekends_only boolean DEFAULT false NOT NULL,
+    nightly_rate integer NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.rate_overrides OWNER TO saylordb;
+
+--
+-- Name: rate_overrides_id_seq; Type: SEQUENCE; Schema: public; Owner: saylordb
+--
+
+CREATE SEQUENCE public.rate_overrides_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.rate_overrides_id_seq OWNER TO saylordb;
+
+--
+-- Name: rate_overrides_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: saylordb
+--
+
+ALTER SEQUENCE public.rate_overrides_id_seq OWNED BY public.rate_overrides.id;
+
+
 --
 -- Name: reservations; Type: TABLE; Schema: public; Owner: saylordb
 --
@@ -34,7 +187,21 @@ CREATE TABLE public.reservations (
     end_date date NOT NULL,
     room_id integer NOT NULL,
     created_at timestamp without time zone NOT NULL,
-    updated_at timestamp without time zone NOT NULL
+    updated_at timestamp without time zone NOT NULL,
+    nights integer DEFAULT 0 NOT NULL,
+    subtotal integer DEFAULT 0 NOT NULL,
+    fees integer DEFAULT 0 NOT NULL,
+    taxes integer DEFAULT 0 NOT NULL,
+    total integer DEFAULT 0 NOT NULL,
+    code character varying(255) DEFAULT ''::character varying NOT NULL,
+    cancelled_at timestamp without time zone,
+    status character varying(255) DEFAULT 'pending'::character varying NOT NULL,
+    confirmed_at timestamp without time zone,
+    checked_in_at timestamp without time zone,
+    checked_out_at timestamp without time zone,
+    no_show_at timestamp without time zone,
+    refund integer DEFAULT 0 NOT NULL,
+    partner character varying(255) DEFAULT ''::character varying NOT NULL
 );
 
 
@@ -98,6 +265,80 @@ ALTER TABLE public.restrictions_id_seq OWNER TO saylordb;
 ALTER SEQUENCE public.restrictions_id_seq OWNED BY public.restrictions.id;
 
 
+--
+-- Name: room_closed_dates; Type: TABLE; Schema: public; Owner: saylordb
+--
+
+CREATE TABLE public.room_closed_dates (
+    id integer NOT NULL,
+    room_id integer NOT NULL,
+    closed_date date NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.room_closed_dates OWNER TO saylordb;
+
+--
+-- Name: room_closed_dates_id_seq; Type: SEQUENCE; Schema: public; Owner: saylordb
+--
+
+CREATE SEQUENCE public.room_closed_dates_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.room_closed_dates_id_seq OWNER TO saylordb;
+
+--
+-- Name: room_closed_dates_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: saylordb
+--
+
+ALTER SEQUENCE public.room_closed_dates_id_seq OWNED BY public.room_closed_dates.id;
+
+
+--
+-- Name: room_rates; Type: TABLE; Schema: public; Owner: saylordb
+--
+
+CREATE TABLE public.room_rates (
+    id integer NOT NULL,
+    room_id integer NOT NULL,
+    nightly_rate integer NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.room_rates OWNER TO saylordb;
+
+--
+-- Name: room_rates_id_seq; Type: SEQUENCE; Schema: public; Owner: saylordb
+--
+
+CREATE SEQUENCE public.room_rates_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.room_rates_id_seq OWNER TO saylordb;
+
+--
+-- Name: room_rates_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: saylordb
+--
+
+ALTER SEQUENCE public.room_rates_id_seq OWNED BY public.room_rates.id;
+
+
 --
 -- Name: room_restrictions; Type: TABLE; Schema: public; Owner: saylordb
 --
@@ -107,10 +348,12 @@ CREATE TABLE public.room_restrictions (
     start_date date NOT NULL,
     end_date date NOT NULL,
     room_id integer NOT NULL,
-    reservation_id integer NOT NULL,
+    reservation_id integer,
     restriction_id integer NOT NULL,
     created_at timestamp without time zone NOT NULL,
-    updated_at timestamp without time zone NOT NULL
+    updated_at timestamp without time zone NOT NULL,
+    ical_feed_id integer,
+    external_uid character varying(255) DEFAULT ''::character varying NOT NULL
 );
 
 
@@ -146,7 +389,13 @@ CREATE TABLE public.rooms (
     id integer NOT NULL,
     room_name character varying(255) DEFAULT ''::character varying NOT NULL,
     created_at timestamp without time zone NOT NULL,
-    updated_at timestamp without time zone NOT NULL
+    updated_at timestamp without time zone NOT NULL,
+    slug character varying(255) DEFAULT ''::character varying NOT NULL,
+    description text DEFAULT ''::text NOT NULL,
+    capacity integer DEFAULT 2 NOT NULL,
+    amenities text DEFAULT ''::text NOT NULL,
+    photos text DEFAULT ''::text NOT NULL,
+    ical_token character varying(255) DEFAULT ''::character varying NOT NULL
 );
 
 
@@ -185,6 +434,47 @@ CREATE TABLE public.schema_migration (
 
 ALTER TABLE public.schema_migration OWNER TO saylordb;
 
+--
+-- Name: stay_rules; Type: TABLE; Schema: public; Owner: saylordb
+--
+
+CREATE TABLE public.stay_rules (
+    id integer NOT NULL,
+    room_id integer NOT NULL,
+    min_nights integer DEFAULT 1 NOT NULL,
+    max_nights integer DEFAULT 0 NOT NULL,
+    min_lead_days integer DEFAULT 0 NOT NULL,
+    max_advance_days integer DEFAULT 0 NOT NULL,
+    arrival_weekdays character varying(255) DEFAULT ''::character varying NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.stay_rules OWNER TO saylordb;
+
+--
+-- Name: stay_rules_id_seq; Type: SEQUENCE; Schema: public; Owner: saylordb
+--
+
+CREATE SEQUENCE public.stay_rules_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.stay_rules_id_seq OWNER TO saylordb;
+
+--
+-- Name: stay_rules_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: saylordb
+--
+
+ALTER SEQUENCE public.stay_rules_id_seq OWNED BY public.stay_rules.id;
+
+
 --
 -- Name: users; Type: TABLE; Schema: public; Owner: saylordb
 --
@@ -225,6 +515,27 @@ ALTER TABLE public.users_id_seq OWNER TO saylordb;
 ALTER SEQUENCE public.users_id_seq OWNED BY public.users.id;
 
 
+--
+-- Name: ical_feeds id; Type: DEFAULT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.ical_feeds ALTER COLUMN id SET DEFAULT nextval('public.ical_feeds_id_seq'::regclass);
+
+
+--
+-- Name: outbox id; Type: DEFAULT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.outbox ALTER COLUMN id SET DEFAULT nextval('public.outbox_id_seq'::regclass);
+
+
+--
+-- Name: rate_overrides id; Type: DEFAULT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.rate_overrides ALTER COLUMN id SET DEFAULT nextval('public.rate_overrides_id_seq'::regclass);
+
+
 --
 -- Name: reservations id; Type: DEFAULT; Schema: public; Owner: saylordb
 --
@@ -239,6 +550,20 @@ ALTER TABLE ONLY public.reservations ALTER COLUMN id SET DEFAULT nextval('public
 ALTER TABLE ONLY public.restrictions ALTER COLUMN id SET DEFAULT nextval('public.restrictions_id_seq'::regclass);
 
 
+--
+-- Name: room_closed_dates id; Type: DEFAULT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.room_closed_dates ALTER COLUMN id SET DEFAULT nextval('public.room_closed_dates_id_seq'::regclass);
+
+
+--
+-- Name: room_rates id; Type: DEFAULT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.room_rates ALTER COLUMN id SET DEFAULT nextval('public.room_rates_id_seq'::regclass);
+
+
 --
 -- Name: room_restrictions id; Type: DEFAULT; Schema: public; Owner: saylordb
 --
@@ -253,6 +578,13 @@ ALTER TABLE ONLY public.room_restrictions ALTER COLUMN id SET DEFAULT nextval('p
 ALTER TABLE ONLY public.rooms ALTER COLUMN id SET DEFAULT nextval('public.rooms_id_seq'::regclass);
 
 
+--
+-- Name: stay_rules id; Type: DEFAULT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.stay_rules ALTER COLUMN id SET DEFAULT nextval('public.stay_rules_id_seq'::regclass);
+
+
 --
 -- Name: users id; Type: DEFAULT; Schema: public; Owner: saylordb
 --
@@ -260,6 +592,30 @@ ALTER TABLE ONLY public.rooms ALTER COLUMN id SET DEFAULT nextval('public.rooms_
 ALTER TABLE ONLY public.users ALTER COLUMN id SET DEFAULT nextval('public.users_id_seq'::regclass);
 
 
+--
+-- Name: ical_feeds ical_feeds_pkey; Type: CONSTRAINT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.ical_feeds
+    ADD CONSTRAINT ical_feeds_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: outbox outbox_pkey; Type: CONSTRAINT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.outbox
+    ADD CONSTRAINT outbox_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: rate_overrides rate_overrides_pkey; Type: CONSTRAINT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.rate_overrides
+    ADD CONSTRAINT rate_overrides_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: reservations reservations_pkey; Type: CONSTRAINT; Schema: public; Owner: saylordb
 --
@@ -276,6 +632,22 @@ ALTER TABLE ONLY public.restrictions
     ADD CONSTRAINT restrictions_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: room_closed_dates room_closed_dates_pkey; Type: CONSTRAINT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.room_closed_dates
+    ADD CONSTRAINT room_closed_dates_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: room_rates room_rates_pkey; Type: CONSTRAINT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.room_rates
+    ADD CONSTRAINT room_rates_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: room_restrictions room_restrictions_pkey; Type: CONSTRAINT; Schema: public; Owner: saylordb
 --
@@ -284,6 +656,14 @@ ALTER TABLE ONLY public.room_restrictions
     ADD CONSTRAINT room_restrictions_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: room_restrictions room_restrictions_no_overlap; Type: CONSTRAINT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.room_restrictions
+    ADD CONSTRAINT room_restrictions_no_overlap EXCLUDE USING gist (room_id WITH =, daterange(start_date, end_date, '[)'::text) WITH &&);
+
+
 --
 -- Name: rooms rooms_pkey; Type: CONSTRAINT; Schema: public; Owner: saylordb
 --
@@ -292,6 +672,14 @@ ALTER TABLE ONLY public.rooms
     ADD CONSTRAINT rooms_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: stay_rules stay_rules_pkey; Type: CONSTRAINT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.stay_rules
+    ADD CONSTRAINT stay_rules_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: saylordb
 --
@@ -300,6 +688,34 @@ ALTER TABLE ONLY public.users
     ADD CONSTRAINT users_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: ical_feeds_room_id_idx; Type: INDEX; Schema: public; Owner: saylordb
+--
+
+CREATE INDEX ical_feeds_room_id_idx ON public.ical_feeds USING btree (room_id);
+
+
+--
+-- Name: outbox_status_next_attempt_at_idx; Type: INDEX; Schema: public; Owner: saylordb
+--
+
+CREATE INDEX outbox_status_next_attempt_at_idx ON public.outbox USING btree (status, next_attempt_at);
+
+
+--
+-- Name: rate_overrides_room_id_idx; Type: INDEX; Schema: public; Owner: saylordb
+--
+
+CREATE INDEX rate_overrides_room_id_idx ON public.rate_overrides USING btree (room_id);
+
+
+--
+-- Name: reservations_code_idx; Type: INDEX; Schema: public; Owner: saylordb
+--
+
+CREATE UNIQUE INDEX reservations_code_idx ON public.reservations USING btree (code);
+
+
 --
 -- Name: reservations_email_idx; Type: INDEX; Schema: public; Owner: saylordb
 --
@@ -328,6 +744,34 @@ CREATE INDEX reservations_room_id_idx ON public.reservations USING btree (room_i
 CREATE INDEX reservations_start_date_end_date_idx ON public.reservations USING btree (start_date, end_date);
 
 
+--
+-- Name: reservations_status_idx; Type: INDEX; Schema: public; Owner: saylordb
+--
+
+CREATE INDEX reservations_status_idx ON public.reservations USING btree (status);
+
+
+--
+-- Name: room_closed_dates_room_id_closed_date_idx; Type: INDEX; Schema: public; Owner: saylordb
+--
+
+CREATE UNIQUE INDEX room_closed_dates_room_id_closed_date_idx ON public.room_closed_dates USING btree (room_id, closed_date);
+
+
+--
+-- Name: room_rates_room_id_idx; Type: INDEX; Schema: public; Owner: saylordb
+--
+
+CREATE UNIQUE INDEX room_rates_room_id_idx ON public.room_rates USING btree (room_id);
+
+
+--
+-- Name: room_restrictions_ical_feed_id_external_uid_idx; Type: INDEX; Schema: public; Owner: saylordb
+--
+
+CREATE UNIQUE INDEX room_restrictions_ical_feed_id_external_uid_idx ON public.room_restrictions USING btree (ical_feed_id, external_uid);
+
+
 --
 -- Name: room_restrictions_reservation_id_idx; Type: INDEX; Schema: public; Owner: saylordb
 --
@@ -356,6 +800,20 @@ CREATE INDEX room_restrictions_start_date_end_date_idx ON public.room_restrictio
 CREATE UNIQUE INDEX schema_migration_version_idx ON public.schema_migration USING btree (version);
 
 
+--
+-- Name: rooms_slug_idx; Type: INDEX; Schema: public; Owner: saylordb
+--
+
+CREATE UNIQUE INDEX rooms_slug_idx ON public.rooms USING btree (slug);
+
+
+--
+-- Name: stay_rules_room_id_idx; Type: INDEX; Schema: public; Owner: saylordb
+--
+
+CREATE UNIQUE INDEX stay_rules_room_id_idx ON public.stay_rules USING btree (room_id);
+
+
 --
 -- Name: users_email_idx; Type: INDEX; Schema: public; Owner: saylordb
 --
@@ -363,6 +821,22 @@ CREATE UNIQUE INDEX schema_migration_version_idx ON public.schema_migration USIN
 CREATE UNIQUE INDEX users_email_idx ON public.users USING btree (email);
 
 
+--
+-- Name: ical_feeds ical_feeds_rooms_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.ical_feeds
+    ADD CONSTRAINT ical_feeds_rooms_id_fk FOREIGN KEY (room_id) REFERENCES public.rooms(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: rate_overrides rate_overrides_rooms_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.rate_overrides
+    ADD CONSTRAINT rate_overrides_rooms_id_fk FOREIGN KEY (room_id) REFERENCES public.rooms(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- Name: reservations reservations_rooms_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: saylordb
 --
@@ -371,6 +845,30 @@ ALTER TABLE ONLY public.reservations
     ADD CONSTRAINT reservations_rooms_id_fk FOREIGN KEY (room_id) REFERENCES public.rooms(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
+--
+-- Name: room_closed_dates room_closed_dates_rooms_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.room_closed_dates
+    ADD CONSTRAINT room_closed_dates_rooms_id_fk FOREIGN KEY (room_id) REFERENCES public.rooms(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: room_rates room_rates_rooms_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.room_rates
+    ADD CONSTRAINT room_rates_rooms_id_fk FOREIGN KEY (room_id) REFERENCES public.rooms(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: room_restrictions room_restrictions_ical_feeds_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: saylordb
+--
+
+ALTER TABLE ONLY public.room_restrictions
+    ADD CONSTRAINT room_restrictions_ical_feeds_id_fk FOREIGN KEY (ical_feed_id) REFERENCES public.ical_feeds(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- Name: room_restrictions room_restrictions_reservations_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: saylordb
 --
@@ -396,6 +894,13 @@ ALTER TABLE ONLY public.room_restrictions
 
 
 --
--- PostgreSQL database dump complete
+-- Name: stay_rules stay_rules_rooms_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: saylordb
 --
 
+ALTER TABLE ONLY public.stay_rules
+    ADD CONSTRAINT stay_rules_rooms_id_fk FOREIGN KEY (room_id) REFERENCES public.rooms(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- PostgreSQL database dump complete
+--